@@ -0,0 +1,39 @@
+package treadonme
+
+import (
+	"time"
+)
+
+// Clock is what the treadmill tells the time and waits with, pacing writes and timing out responses. It can be swapped for a fake clock
+// so tests don't have to wait, see the treadtest package.
+type Clock interface {
+	Now() time.Time
+	// After waits for the duration to pass and then sends the current time on the returned channel, see time.After.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls fn once the duration has passed, see time.AfterFunc. The returned function stops the timer and
+	// reports whether it stopped it before fn was called.
+	AfterFunc(d time.Duration, fn func()) (stop func() bool)
+}
+
+type realClock struct{}
+
+// clockOf returns the clock the equipment waits with, or the real clock if it doesn't say.
+func clockOf(equipment interface{}) Clock {
+	if clocked, ok := equipment.(interface{ Clock() Clock }); ok {
+		return clocked.Clock()
+	}
+
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, fn func()) func() bool {
+	return time.AfterFunc(d, fn).Stop
+}
@@ -0,0 +1,390 @@
+package treadonme_test
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/treadtest"
+)
+
+// retryInterval is the least time the README says the treadmill needs between writes.
+const retryInterval = 300 * time.Millisecond
+
+// fakeWrite is a frame written by the host and when it was written.
+type fakeWrite struct {
+	frame string
+	at    time.Time
+}
+
+// fakeTransport stands in for the treadmill, respond is called with every frame the host writes and returns the frames
+// the treadmill sends back.
+type fakeTransport struct {
+	mutex   sync.Mutex
+	recv    func([]byte)
+	respond func(frame string, attempt int) []string
+	writes  []fakeWrite
+}
+
+func (ft *fakeTransport) dial(_ context.Context, recv func([]byte)) (treadonme.Transport, error) {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	ft.recv = recv
+
+	return ft, nil
+}
+
+func (ft *fakeTransport) Write(frame []byte) error {
+	encoded := strings.ToUpper(hex.EncodeToString(frame))
+
+	ft.mutex.Lock()
+	ft.writes = append(ft.writes, fakeWrite{frame: encoded, at: time.Now()})
+
+	attempt := 0
+	for _, w := range ft.writes {
+		if w.frame == encoded {
+			attempt++
+		}
+	}
+
+	var replies []string
+	if ft.respond != nil {
+		replies = ft.respond(encoded, attempt)
+	}
+	ft.mutex.Unlock()
+
+	// Replies arrive asynchronously like BLE notifications do.
+	go func() {
+		for _, reply := range replies {
+			ft.push(reply)
+		}
+	}()
+
+	return nil
+}
+
+func (ft *fakeTransport) Close() error {
+	return nil
+}
+
+func (ft *fakeTransport) push(frame string) {
+	ft.mutex.Lock()
+	recv := ft.recv
+	ft.mutex.Unlock()
+
+	recv(fromHex(frame))
+}
+
+func (ft *fakeTransport) written() []fakeWrite {
+	ft.mutex.Lock()
+	defer ft.mutex.Unlock()
+
+	return append([]fakeWrite(nil), ft.writes...)
+}
+
+func (ft *fakeTransport) frames() []string {
+	var frames []string
+	for _, w := range ft.written() {
+		frames = append(frames, w.frame)
+	}
+
+	return frames
+}
+
+type ConformanceTestSuite struct {
+	suite.Suite
+}
+
+func (s *ConformanceTestSuite) connect(
+	respond func(frame string, attempt int) []string,
+) (*treadonme.Treadmill, *fakeTransport) {
+	ft := &fakeTransport{respond: respond}
+
+	tm := treadonme.NewWithDialer(ft.dial)
+	s.Require().NoError(tm.Connect(context.Background()))
+
+	return tm, ft
+}
+
+// waitForWrites waits for the host to write at least count frames.
+func (s *ConformanceTestSuite) waitForWrites(ft *fakeTransport, count int) {
+	s.Require().Eventually(func() bool {
+		return len(ft.written()) >= count
+	}, time.Second, 5*time.Millisecond)
+}
+
+func encode(msg treadonme.Message) string {
+	data, err := treadonme.EncodeMessage(msg)
+	if err != nil {
+		panic(err)
+	}
+
+	return strings.ToUpper(hex.EncodeToString(data))
+}
+
+// TestTreadmillToHost replays the messages the treadmill sends from the README's message table, along with the other
+// messages it reports, and checks the host's reply to each.
+func (s *ConformanceTestSuite) TestTreadmillToHost() {
+	tests := []struct {
+		name  string
+		frame string
+		reply string
+	}{
+		{"Acknowledge", "5B0400094F4B5D", ""},
+		{"Workout Mode", "5B0203015D", "5B0203015D"},
+		{"Workout Data", "5B0F06093B0000000000050000000000015D", "5B0400064F4B5D"},
+		{"Heart Rate Type", "5B030901005D", "5B0400094F4B5D"},
+		{"Error Code", "5B0210005D", "5B0400104F4B5D"},
+		{"End Workout", "5B0A320013000000000800005D", "5B0400324F4B5D"},
+		// These aren't sent by the treadmill but shouldn't be answered if they are.
+		{"Set Workout Mode", "5B0202025D", ""},
+		{"Get Device Info", "5B08F092000178050F125D", ""},
+		// Not in the table.
+		{"Speed", encode(&treadonme.MessageSpeed{Speed: 30}), "5B0400114F4B5D"},
+		{"Incline", encode(&treadonme.MessageIncline{Incline: 2}), "5B0400124F4B5D"},
+		{"Level", encode(&treadonme.MessageLevel{}), "5B0400134F4B5D"},
+		{"RPM", encode(&treadonme.MessageRPM{}), "5B0400144F4B5D"},
+		{"Heart Rate", encode(&treadonme.MessageHeartRate{}), "5B0400154F4B5D"},
+		{"Target Heart Rate", encode(&treadonme.MessageTargetHeartRate{}), "5B0400204F4B5D"},
+		{"Max Speed", encode(&treadonme.MessageMaxSpeed{}), "5B0400214F4B5D"},
+		{"Max Incline", encode(&treadonme.MessageMaxIncline{}), "5B0400224F4B5D"},
+		{"Max Level", encode(&treadonme.MessageMaxLevel{}), "5B0400234F4B5D"},
+		{"Program Graphics", encode(&treadonme.MessageProgramGraphics{}), "5B0400404F4B5D"},
+	}
+
+	for _, test := range tests {
+		_, ft := s.connect(nil)
+
+		ft.push(test.frame)
+
+		if test.reply == "" {
+			// Replies are written asynchronously, give one a chance to turn up.
+			time.Sleep(50 * time.Millisecond)
+			s.Require().Empty(ft.frames(), test.name)
+
+			continue
+		}
+
+		s.waitForWrites(ft, 1)
+		s.Require().Equal([]string{test.reply}, ft.frames(), test.name)
+	}
+}
+
+// TestHostToTreadmill sends the README's host messages, answering each the way the table says the treadmill does, and
+// checks the exact frames written and the gaps between them.
+func (s *ConformanceTestSuite) TestHostToTreadmill() {
+	responses := map[string]string{
+		"5B0202025D":         "5B0202025D",
+		"5B05040A0000005D":   "5B0400044F4B5D",
+		"5B06070123009B435D": "5B0400074F4B5D",
+		"5B030810015D":       "5B0400084F4B5D",
+		"5B01F05D":           "5B08F092000178050F125D",
+	}
+
+	tm, ft := s.connect(func(frame string, _ int) []string {
+		if reply, ok := responses[frame]; ok {
+			return []string{reply}
+		}
+
+		return nil
+	})
+
+	info, err := tm.GetDeviceInfo()
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.DeviceModelF80, info.Model)
+	s.Require().Equal(info, tm.DeviceInfo())
+
+	s.Require().NoError(tm.SetUserProfile(treadonme.SexTypeMale, 35, 155, 67))
+	s.Require().NoError(tm.SetWorkoutTime(10 * time.Minute))
+	s.Require().NoError(tm.SetProgram(treadonme.ProgramManual))
+	s.Require().NoError(tm.SetWorkoutMode(treadonme.WorkoutModeStart))
+
+	s.Require().Equal([]string{
+		"5B01F05D",
+		"5B06070123009B435D",
+		"5B05040A0000005D",
+		"5B030810015D",
+		"5B0202025D",
+	}, ft.frames())
+	s.requirePacing(ft.written())
+
+	sent := tm.Counters().Snapshot().Sent
+	s.Require().Equal(uint64(1), sent[treadonme.MessageTypeDeviceInfo])
+	s.Require().Equal(uint64(1), sent[treadonme.MessageTypeSetWorkoutMode])
+}
+
+// TestRetry checks a command is written again until it's acknowledged.
+func (s *ConformanceTestSuite) TestRetry() {
+	tm, ft := s.connect(func(frame string, attempt int) []string {
+		if attempt < 3 {
+			return nil
+		}
+
+		return []string{"5B0400044F4B5D"}
+	})
+
+	s.Require().NoError(tm.SetWorkoutTime(10 * time.Minute))
+
+	s.Require().Equal([]string{"5B05040A0000005D", "5B05040A0000005D", "5B05040A0000005D"}, ft.frames())
+	s.requirePacing(ft.written())
+	s.Require().Zero(tm.Counters().Snapshot().AckTimeouts)
+}
+
+// TestAckTimeout checks a command is given up on after ten attempts, acknowledging something else doesn't count.
+func (s *ConformanceTestSuite) TestAckTimeout() {
+	tm, ft := s.connect(func(frame string, _ int) []string {
+		return []string{"5B0400074F4B5D"}
+	})
+
+	err := tm.SetWorkoutTime(10 * time.Minute)
+	s.Require().ErrorIs(err, treadonme.ErrAckTimeout)
+
+	writes := ft.written()
+	s.Require().Len(writes, 10)
+
+	for _, w := range writes {
+		s.Require().Equal("5B05040A0000005D", w.frame)
+	}
+
+	s.requirePacing(writes)
+	s.Require().Equal(uint64(1), tm.Counters().Snapshot().AckTimeouts)
+}
+
+// TestEmergencyStop checks the stop is resent until it's acknowledged, without waiting the usual retry interval.
+func (s *ConformanceTestSuite) TestEmergencyStop() {
+	stop := encode(&treadonme.MessageCommand{Command: treadonme.CommandTypeStop})
+
+	tm, ft := s.connect(func(frame string, attempt int) []string {
+		if frame != stop || attempt < 3 {
+			return nil
+		}
+
+		return []string{"5B0400F14F4B5D"}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	s.Require().NoError(tm.EmergencyStop(ctx))
+	s.Require().Less(int64(time.Since(start)), int64(2*retryInterval))
+	s.Require().Equal([]string{stop, stop, stop}, ft.frames())
+}
+
+// TestWriteAfterClose checks writes, including replies to frames that arrive late, fail once the connection is closed.
+func (s *ConformanceTestSuite) TestWriteAfterClose() {
+	tm, ft := s.connect(nil)
+
+	errs := make(chan error, 1)
+	tm.AddListener(func(_ treadonme.Message, err error) {
+		if err != nil {
+			errs <- err
+		}
+	})
+
+	s.Require().NoError(tm.Close())
+	s.Require().ErrorIs(tm.SetWorkoutTime(10*time.Minute), treadonme.ErrNotConnected)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	s.Require().ErrorIs(tm.EmergencyStop(ctx), context.DeadlineExceeded)
+
+	ft.push("5B0F06093B0000000000050000000000015D")
+
+	select {
+	case err := <-errs:
+		s.Require().ErrorIs(err, treadonme.ErrNotConnected)
+	case <-time.After(time.Second):
+		s.FailNow("reply after close didn't fail")
+	}
+
+	s.Require().Empty(ft.frames())
+}
+
+func setMode(mode treadonme.WorkoutMode) string {
+	return treadtest.Frame(&treadonme.MessageSetWorkoutMode{Mode: mode})
+}
+
+// TestPauseResume checks pausing and resuming wait for the treadmill to report the new mode.
+func (s *ConformanceTestSuite) TestPauseResume() {
+	mock := treadtest.New(s.T())
+	mock.ExpectWrite(setMode(treadonme.WorkoutModePause)).Reply(setMode(treadonme.WorkoutModePause)).
+		ThenPush(treadtest.Frame(workoutMode(treadonme.WorkoutModePause)))
+	mock.ExpectWrite(setMode(treadonme.WorkoutModeRunning)).Reply(setMode(treadonme.WorkoutModeRunning)).
+		ThenPush(treadtest.Frame(workoutMode(treadonme.WorkoutModeRunning)))
+
+	tm := mock.Treadmill()
+	defer func() { s.Require().NoError(tm.Close()) }()
+
+	// Waiting for each confirmation moves the fake clock on further than the telemetry timeout.
+	tm.SetTelemetryTimeout(0)
+
+	s.Require().NoError(tm.Pause())
+	s.Require().Equal(treadonme.WorkoutModePause, tm.CurrentMode())
+	s.Require().NoError(tm.Resume())
+	s.Require().Equal(treadonme.WorkoutModeRunning, tm.CurrentMode())
+	s.Require().True(mock.AssertExpectations())
+}
+
+// TestLevelDown checks stepping down is written again until it's acknowledged, then waits for the new speed.
+func (s *ConformanceTestSuite) TestLevelDown() {
+	mock := treadtest.New(s.T())
+	mock.ExpectWrite(levelDown).Times(2)
+	mock.ExpectWrite(levelDown).Reply(ackLevel).ThenPush(speed(29))
+
+	tm := mock.Treadmill()
+	defer func() { s.Require().NoError(tm.Close()) }()
+
+	s.Require().NoError(tm.LevelDown())
+	s.Require().True(mock.AssertExpectations())
+
+	current, ok := tm.CurrentSpeed()
+	s.Require().True(ok)
+	s.Require().Equal(treadonme.Speed(29), current)
+}
+
+// TestNotConfirmed checks commands that are acknowledged but never take effect time out, as do commands that are
+// never acknowledged.
+func (s *ConformanceTestSuite) TestNotConfirmed() {
+	mock := treadtest.New(s.T())
+	tm := mock.Treadmill()
+	defer func() { s.Require().NoError(tm.Close()) }()
+
+	// The treadmill echoes the pause but carries on running.
+	mock.ExpectWrite(setMode(treadonme.WorkoutModePause)).Reply(setMode(treadonme.WorkoutModePause))
+	s.Require().ErrorIs(tm.Pause(), treadonme.ErrNotConfirmed)
+
+	mock.ExpectWrite(setMode(treadonme.WorkoutModeRunning)).Reply(setMode(treadonme.WorkoutModeRunning))
+	s.Require().ErrorIs(tm.Resume(), treadonme.ErrNotConfirmed)
+
+	mock.ExpectWrite(levelDown).Reply(ackLevel)
+	s.Require().ErrorIs(tm.LevelDown(), treadonme.ErrNotConfirmed)
+	s.Require().True(mock.AssertExpectations())
+
+	mock.ExpectWrite(setMode(treadonme.WorkoutModeRunning)).Times(10)
+	s.Require().ErrorIs(tm.Resume(), treadonme.ErrAckTimeout)
+
+	mock.ExpectWrite(levelDown).Times(10)
+	s.Require().ErrorIs(tm.LevelDown(), treadonme.ErrAckTimeout)
+	s.Require().True(mock.AssertExpectations())
+}
+
+// requirePacing checks there's at least the retry interval between every write.
+func (s *ConformanceTestSuite) requirePacing(writes []fakeWrite) {
+	for idx := 1; idx < len(writes); idx++ {
+		gap := writes[idx].at.Sub(writes[idx-1].at)
+		s.Require().GreaterOrEqual(int64(gap), int64(retryInterval), "gap before write %d was %s", idx, gap)
+	}
+}
+
+func TestConformanceTestSuite(t *testing.T) {
+	t.Parallel()
+	suite.Run(t, new(ConformanceTestSuite))
+}
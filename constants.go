@@ -1,5 +1,15 @@
 package treadonme
 
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownUnits   = fmt.Errorf("unknown units")
+	ErrUnknownProgram = fmt.Errorf("unknown program")
+)
+
 type MessageType byte
 
 const (
@@ -67,7 +77,7 @@ func (mt MessageType) Create() Message {
 	case MessageTypeMaxLevel:
 		return &MessageMaxLevel{}
 	case MessageTypeUserIncline:
-		return &MessageUserLevel{}
+		return &MessageUserIncline{}
 	case MessageTypeUserLevel:
 		return &MessageUserLevel{}
 	case MessageTypeEndWorkout:
@@ -177,6 +187,31 @@ func (ut UnitsType) String() string {
 	}
 }
 
+func ParseUnitsType(units string) (UnitsType, error) {
+	switch strings.ToLower(units) {
+	case "metric":
+		return UnitsTypeMetric, nil
+	case "imperial":
+		return UnitsTypeImperial, nil
+	default:
+		return 0, fmt.Errorf("%w: %q", ErrUnknownUnits, units)
+	}
+}
+
+const kilometersPerMile = 1.609344
+
+// Convert converts a length (or length per unit of time) from one unit system to another.
+func (ut UnitsType) Convert(value float64, to UnitsType) float64 {
+	switch {
+	case ut == UnitsTypeImperial && to == UnitsTypeMetric:
+		return value * kilometersPerMile
+	case ut == UnitsTypeMetric && to == UnitsTypeImperial:
+		return value / kilometersPerMile
+	default:
+		return value
+	}
+}
+
 type WorkoutMode byte
 
 const (
@@ -253,6 +288,11 @@ func (ct CommandType) String() string {
 
 type Speed byte
 
+// Float returns the speed in the device's units per hour, speeds are transmitted multiplied by ten.
+func (s Speed) Float() float64 {
+	return float64(s) / 10
+}
+
 type Weight uint16
 
 type Height byte
@@ -273,6 +313,21 @@ const (
 	ProgramFusion   Program = 0x600c //0x60 0x0c
 )
 
+var programs = []Program{
+	ProgramManual, ProgramHill, ProgramFatBurn, ProgramCardio, ProgramStrength, ProgramInterval, ProgramHR1, ProgramHR2,
+	ProgramUser1, ProgramUser2, ProgramFusion,
+}
+
+func ParseProgram(name string) (Program, error) {
+	for _, p := range programs {
+		if strings.EqualFold(p.String(), name) {
+			return p, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %q", ErrUnknownProgram, name)
+}
+
 func (p Program) String() string {
 	switch p {
 	case ProgramManual:
@@ -0,0 +1,90 @@
+package treadonme
+
+import (
+	"sync"
+)
+
+// Counters keep track of the traffic to and from a treadmill. The same counters can be shared between connections
+// (see Treadmill.SetCounters) so they keep counting across workouts.
+type Counters struct {
+	mutex       sync.Mutex
+	sent        map[MessageType]uint64
+	received    map[MessageType]uint64
+	ackTimeouts uint64
+	parseErrors uint64
+	reconnects  uint64
+}
+
+// CounterSnapshot is a copy of the counters at a point in time.
+type CounterSnapshot struct {
+	Sent        map[MessageType]uint64
+	Received    map[MessageType]uint64
+	AckTimeouts uint64
+	ParseErrors uint64
+	Reconnects  uint64
+}
+
+func NewCounters() *Counters {
+	return &Counters{
+		sent:     map[MessageType]uint64{},
+		received: map[MessageType]uint64{},
+	}
+}
+
+func (c *Counters) Snapshot() CounterSnapshot {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	snapshot := CounterSnapshot{
+		Sent:        make(map[MessageType]uint64, len(c.sent)),
+		Received:    make(map[MessageType]uint64, len(c.received)),
+		AckTimeouts: c.ackTimeouts,
+		ParseErrors: c.parseErrors,
+		Reconnects:  c.reconnects,
+	}
+
+	for k, v := range c.sent {
+		snapshot.Sent[k] = v
+	}
+
+	for k, v := range c.received {
+		snapshot.Received[k] = v
+	}
+
+	return snapshot
+}
+
+func (c *Counters) frameSent(msgType MessageType) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.sent[msgType]++
+}
+
+func (c *Counters) frameReceived(msgType MessageType) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.received[msgType]++
+}
+
+func (c *Counters) ackTimeout() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.ackTimeouts++
+}
+
+func (c *Counters) parseError() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.parseErrors++
+}
+
+func (c *Counters) reconnect() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.reconnects++
+}
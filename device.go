@@ -0,0 +1,62 @@
+package treadonme
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux"
+)
+
+// The adapter is shared between everything connected at the same time (the treadmill and a heart rate monitor for
+// instance), it's only stopped once the last connection releases it.
+var (
+	deviceMutex sync.Mutex
+	device      ble.Device
+	deviceRefs  int
+)
+
+// AcquireDevice returns the shared adapter, creating it if needed. Every successful call has to be matched by a call to
+// ReleaseDevice.
+func AcquireDevice() (ble.Device, error) {
+	deviceMutex.Lock()
+	defer deviceMutex.Unlock()
+
+	if device == nil {
+		bleDevice, err := linux.NewDevice()
+		if err != nil {
+			return nil, fmt.Errorf("problem creating new linux ble device handle: %w", err)
+		}
+
+		ble.SetDefaultDevice(bleDevice)
+		device = bleDevice
+	}
+
+	deviceRefs++
+
+	return device, nil
+}
+
+// ReleaseDevice gives up a reference to the shared adapter, stopping it once nothing else is using it. Releasing more
+// times than the adapter was acquired does nothing.
+func ReleaseDevice() error {
+	deviceMutex.Lock()
+	defer deviceMutex.Unlock()
+
+	if deviceRefs == 0 {
+		return nil
+	}
+
+	if deviceRefs--; deviceRefs > 0 {
+		return nil
+	}
+
+	dev := device
+	device = nil
+
+	if dev == nil {
+		return nil
+	}
+
+	return dev.Stop()
+}
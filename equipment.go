@@ -0,0 +1,24 @@
+package treadonme
+
+import (
+	"context"
+)
+
+// Equipment is a treadmill that can be monitored and controlled. It's implemented by Treadmill for Sole treadmills and
+// by ftms.Client for treadmills that speak the standard Fitness Machine Service. Telemetry is delivered to listeners as
+// the same messages a Sole treadmill sends so a Session (and anything else built on the messages) works with either.
+type Equipment interface {
+	Controller
+	Connect(ctx context.Context) error
+	Close() error
+	AddListener(listener MessageListener)
+	GetDeviceInfo() (*MessageDeviceInfo, error)
+	Start() error
+	Stop() error
+	Pause() error
+	Resume() error
+	LevelUp() error
+	LevelDown() error
+}
+
+var _ Equipment = (*Treadmill)(nil)
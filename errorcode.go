@@ -0,0 +1,120 @@
+package treadonme
+
+import (
+	"fmt"
+	"time"
+)
+
+// ErrorCode is an error reported by the console, it's the number shown on the console as E1, E2 and so on.
+type ErrorCode byte
+
+const (
+	ErrorCodeNone        ErrorCode = 0x00
+	ErrorCodeSpeedSensor ErrorCode = 0x01
+	ErrorCodeMotor       ErrorCode = 0x02
+	ErrorCodeIncline     ErrorCode = 0x03
+	ErrorCodeOvercurrent ErrorCode = 0x04
+	ErrorCodeComms       ErrorCode = 0x05
+	ErrorCodeOvervoltage ErrorCode = 0x06
+	ErrorCodeSafetyKey   ErrorCode = 0x07
+	ErrorCodeOverheat    ErrorCode = 0x08
+)
+
+type ErrorSeverity byte
+
+const (
+	// ErrorSeverityInfo needs no action.
+	ErrorSeverityInfo ErrorSeverity = iota
+	// ErrorSeverityWarning stops the belt but the workout can carry on once it's been dealt with.
+	ErrorSeverityWarning
+	// ErrorSeverityCritical is a fault with the treadmill, it shouldn't be used until it's been looked at.
+	ErrorSeverityCritical
+)
+
+func (es ErrorSeverity) String() string {
+	switch es {
+	case ErrorSeverityInfo:
+		return "Info"
+	case ErrorSeverityWarning:
+		return "Warning"
+	case ErrorSeverityCritical:
+		return "Critical"
+	default:
+		return "Unknown"
+	}
+}
+
+type errorCodeInfo struct {
+	name        string
+	description string
+	severity    ErrorSeverity
+}
+
+// errorCodes are the codes documented for Sole consoles.
+var errorCodes = map[ErrorCode]errorCodeInfo{
+	ErrorCodeNone:        {"None", "no error", ErrorSeverityInfo},
+	ErrorCodeSpeedSensor: {"SpeedSensor", "no signal from the speed sensor", ErrorSeverityCritical},
+	ErrorCodeMotor:       {"Motor", "the drive motor isn't responding", ErrorSeverityCritical},
+	ErrorCodeIncline:     {"Incline", "the incline motor failed to reach its position", ErrorSeverityCritical},
+	ErrorCodeOvercurrent: {"Overcurrent", "the motor drew too much current", ErrorSeverityCritical},
+	ErrorCodeComms:       {"Comms", "the console lost contact with the motor controller", ErrorSeverityCritical},
+	ErrorCodeOvervoltage: {"Overvoltage", "the supply voltage is too high", ErrorSeverityCritical},
+	ErrorCodeSafetyKey:   {"SafetyKey", "the safety key has been pulled", ErrorSeverityWarning},
+	ErrorCodeOverheat:    {"Overheat", "the motor controller is overheating", ErrorSeverityWarning},
+}
+
+// Known returns whether the code is in the catalog.
+func (ec ErrorCode) Known() bool {
+	_, ok := errorCodes[ec]
+
+	return ok
+}
+
+func (ec ErrorCode) String() string {
+	if info, ok := errorCodes[ec]; ok {
+		return info.name
+	}
+
+	return fmt.Sprintf("E%d", byte(ec))
+}
+
+func (ec ErrorCode) Description() string {
+	if info, ok := errorCodes[ec]; ok {
+		return info.description
+	}
+
+	return fmt.Sprintf("unknown error E%d", byte(ec))
+}
+
+// Severity returns how serious the error is, unknown codes are treated as critical.
+func (ec ErrorCode) Severity() ErrorSeverity {
+	if info, ok := errorCodes[ec]; ok {
+		return info.severity
+	}
+
+	return ErrorSeverityCritical
+}
+
+// ErrorEvent is an error code reported by the treadmill.
+type ErrorEvent struct {
+	Time        time.Time
+	Code        ErrorCode
+	Severity    ErrorSeverity
+	Description string
+}
+
+func NewErrorEvent(code ErrorCode, at time.Time) ErrorEvent {
+	return ErrorEvent{Time: at, Code: code, Severity: code.Severity(), Description: code.Description()}
+}
+
+type ErrorListener func(ErrorEvent)
+
+// ErrorEventListener returns a message listener that decodes error codes reported by the treadmill into events for
+// the error listener.
+func ErrorEventListener(listener ErrorListener) MessageListener {
+	return func(msg Message, err error) {
+		if e, ok := msg.(*MessageErrorCode); ok && err == nil {
+			listener(NewErrorEvent(e.Code, time.Now()))
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package treadonme_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+type ErrorCodeTestSuite struct {
+	suite.Suite
+}
+
+func (s *ErrorCodeTestSuite) TestCatalog() {
+	s.Require().True(treadonme.ErrorCodeSafetyKey.Known())
+	s.Require().Equal("SafetyKey", treadonme.ErrorCodeSafetyKey.String())
+	s.Require().Equal(treadonme.ErrorSeverityWarning, treadonme.ErrorCodeSafetyKey.Severity())
+	s.Require().Equal(treadonme.ErrorSeverityCritical, treadonme.ErrorCodeMotor.Severity())
+	s.Require().Equal(treadonme.ErrorSeverityInfo, treadonme.ErrorCodeNone.Severity())
+
+	unknown := treadonme.ErrorCode(0x42)
+	s.Require().False(unknown.Known())
+	s.Require().Equal("E66", unknown.String())
+	s.Require().Equal("unknown error E66", unknown.Description())
+	s.Require().Equal(treadonme.ErrorSeverityCritical, unknown.Severity())
+}
+
+func (s *ErrorCodeTestSuite) TestEvents() {
+	msg, err := treadonme.ParseMessage(fromHex("5b0210075d"))
+	s.Require().NoError(err)
+	s.Require().Equal(&treadonme.MessageErrorCode{Code: treadonme.ErrorCodeSafetyKey}, msg)
+
+	var events []treadonme.ErrorEvent
+
+	listener := treadonme.ErrorEventListener(func(event treadonme.ErrorEvent) {
+		events = append(events, event)
+	})
+	listener(msg, nil)
+	listener(&treadonme.MessageWorkoutData{}, nil)
+
+	s.Require().Len(events, 1)
+	s.Require().Equal(treadonme.ErrorCodeSafetyKey, events[0].Code)
+	s.Require().Equal(treadonme.ErrorSeverityWarning, events[0].Severity)
+	s.Require().Equal("the safety key has been pulled", events[0].Description)
+
+	session := treadonme.NewSession(&treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial})
+	session.HandleMessage(msg, nil)
+	s.Require().Len(session.Errors(), 1)
+	s.Require().Equal(treadonme.ErrorCodeSafetyKey, session.Errors()[0].Code)
+}
+
+func TestErrorCodeTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &ErrorCodeTestSuite{})
+}
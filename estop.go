@@ -0,0 +1,204 @@
+package treadonme
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrEmergencyStop = fmt.Errorf("emergency stop in progress")
+
+const (
+	// emergencyStopInterval is how often the stop command is resent until the treadmill acknowledges it. It's shorter
+	// than the usual retry interval, getting the belt stopped matters more than not flooding the treadmill.
+	emergencyStopInterval = 100 * time.Millisecond
+	// hostStopWindow is how long after the host asks the treadmill to stop or pause that the treadmill stopping isn't
+	// reported as a console stop.
+	hostStopWindow = 3 * time.Second
+)
+
+// EmergencyStopper is implemented by equipment that can be stopped ahead of anything else being sent to it.
+type EmergencyStopper interface {
+	EmergencyStop(ctx context.Context) error
+}
+
+var _ EmergencyStopper = (*Treadmill)(nil)
+
+// EmergencyStop sends the stop command ahead of anything waiting to be written and keeps resending it until the
+// treadmill acknowledges it or reports the workout has stopped, or the context is done. Anything else written while
+// it's in progress fails with ErrEmergencyStop.
+func (t *Treadmill) EmergencyStop(ctx context.Context) error {
+	atomic.AddInt32(&t.emergency, 1)
+	defer atomic.AddInt32(&t.emergency, -1)
+
+	msg := &MessageCommand{Command: CommandTypeStop}
+	acked := matchResponse(msg, MessageTypeACK)
+	match := func(msg Message) bool {
+		return acked(msg) || matchStopped(msg)
+	}
+
+	stopped := t.expect(match)
+	defer func() { t.unexpect(stopped) }()
+
+	t.stops.HostStop()
+
+	for attempt := 1; ; attempt++ {
+		if err := t.writeFrame(msg, true); err != nil {
+			log.Printf("problem sending emergency stop (attempt %d): %s", attempt, err)
+		}
+
+		var result interface{}
+
+		select {
+		case result = <-stopped.result:
+		case <-ctx.Done():
+			return fmt.Errorf("emergency stop not acknowledged after %d attempts: %w", attempt, ctx.Err())
+		case <-t.clock.After(emergencyStopInterval):
+			// The stop might have been acknowledged just as the interval passed.
+			select {
+			case result = <-stopped.result:
+			default:
+				continue
+			}
+		}
+
+		if _, err := expectationResult(result); err == nil {
+			return nil
+		}
+
+		// Errors are delivered to everyone waiting, keep waiting for the stop to be acknowledged.
+		t.unexpect(stopped)
+		stopped = t.expect(match)
+	}
+}
+
+func matchStopped(msg Message) bool {
+	switch v := msg.(type) {
+	case *MessageWorkoutMode:
+		return v.Mode == WorkoutModeDone || v.Mode == WorkoutModeIdle
+	case *MessageEndWorkout:
+		return true
+	default:
+		return false
+	}
+}
+
+type StopEventType byte
+
+const (
+	// StopEventSafetyKey is reported when the safety key is pulled.
+	StopEventSafetyKey StopEventType = iota
+	// StopEventConsoleStop is reported when the workout is stopped or paused from the console.
+	StopEventConsoleStop
+)
+
+func (set StopEventType) String() string {
+	switch set {
+	case StopEventSafetyKey:
+		return "SafetyKey"
+	case StopEventConsoleStop:
+		return "ConsoleStop"
+	default:
+		return "Unknown"
+	}
+}
+
+// StopEvent is reported when the treadmill stops without the host asking it to.
+type StopEvent struct {
+	Type StopEventType
+	Time time.Time
+	// Mode is the workout mode the treadmill went to, it's zero if the stop was detected from an error code.
+	Mode WorkoutMode
+	Code ErrorCode
+}
+
+type StopListener func(StopEvent)
+
+// StopDetector works out when a running treadmill was stopped by the runner rather than the host. The safety key
+// being pulled is detected from its error code, or from the treadmill dropping straight from running to idle without
+// finishing the workout. Pausing or finishing while running is reported as a console stop. Stops within a few seconds
+// of the host asking for one aren't reported, and only the first stop is reported until the treadmill is running
+// again.
+type StopDetector struct {
+	mutex     sync.Mutex
+	clock     Clock
+	mode      WorkoutMode
+	hostStop  time.Time
+	reported  bool
+	listeners []StopListener
+}
+
+func NewStopDetector() *StopDetector {
+	return &StopDetector{clock: realClock{}}
+}
+
+// SetClock replaces the clock the times of host stops and stop events are taken from.
+func (sd *StopDetector) SetClock(clock Clock) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	sd.clock = clock
+}
+
+func (sd *StopDetector) AddListener(listener StopListener) {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	sd.listeners = append(sd.listeners, listener)
+}
+
+// HostStop records that the host asked the treadmill to stop or pause, so the treadmill doing so isn't reported.
+func (sd *StopDetector) HostStop() {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+
+	sd.hostStop = sd.clock.Now()
+}
+
+func (sd *StopDetector) HandleMessage(msg Message, err error) {
+	if err != nil {
+		return
+	}
+
+	sd.mutex.Lock()
+
+	now := sd.clock.Now()
+
+	var event *StopEvent
+
+	switch v := msg.(type) {
+	case *MessageErrorCode:
+		if v.Code == ErrorCodeSafetyKey && !sd.reported {
+			event = &StopEvent{Type: StopEventSafetyKey, Time: now, Code: v.Code}
+		}
+	case *MessageWorkoutMode:
+		previous := sd.mode
+		sd.mode = v.Mode
+
+		switch {
+		case v.Mode == WorkoutModeRunning:
+			sd.reported = false
+		case previous != WorkoutModeRunning || sd.reported || now.Sub(sd.hostStop) <= hostStopWindow:
+		case v.Mode == WorkoutModeIdle:
+			event = &StopEvent{Type: StopEventSafetyKey, Time: now, Mode: v.Mode}
+		case v.Mode == WorkoutModePause || v.Mode == WorkoutModeDone:
+			event = &StopEvent{Type: StopEventConsoleStop, Time: now, Mode: v.Mode}
+		}
+	}
+
+	if event != nil {
+		sd.reported = true
+	}
+
+	listeners := sd.listeners
+	sd.mutex.Unlock()
+
+	if event != nil {
+		for _, l := range listeners {
+			l(*event)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package treadonme_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/treadtest"
+)
+
+type StopDetectorTestSuite struct {
+	suite.Suite
+}
+
+func (s *StopDetectorTestSuite) detector() (*treadonme.StopDetector, *[]treadonme.StopEvent) {
+	var events []treadonme.StopEvent
+
+	sd := treadonme.NewStopDetector()
+	sd.AddListener(func(event treadonme.StopEvent) {
+		events = append(events, event)
+	})
+
+	return sd, &events
+}
+
+func workoutMode(m treadonme.WorkoutMode) treadonme.Message {
+	return &treadonme.MessageWorkoutMode{Mode: m}
+}
+
+func (s *StopDetectorTestSuite) TestConsoleStop() {
+	sd, events := s.detector()
+
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeRunning), nil)
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModePause), nil)
+	s.Require().Len(*events, 1)
+	s.Require().Equal(treadonme.StopEventConsoleStop, (*events)[0].Type)
+	s.Require().Equal(treadonme.WorkoutModePause, (*events)[0].Mode)
+
+	// Finishing from pause isn't another stop.
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeDone), nil)
+	s.Require().Len(*events, 1)
+
+	// Stops the host asked for aren't reported.
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeRunning), nil)
+	sd.HostStop()
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeDone), nil)
+	s.Require().Len(*events, 1)
+}
+
+func (s *StopDetectorTestSuite) TestSafetyKey() {
+	sd, events := s.detector()
+
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeRunning), nil)
+	sd.HandleMessage(&treadonme.MessageErrorCode{Code: treadonme.ErrorCodeSafetyKey}, nil)
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeIdle), nil)
+	s.Require().Len(*events, 1)
+	s.Require().Equal(treadonme.StopEventSafetyKey, (*events)[0].Type)
+	s.Require().Equal(treadonme.ErrorCodeSafetyKey, (*events)[0].Code)
+
+	// Dropping straight from running to idle looks like the key being pulled, even without the error code.
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeRunning), nil)
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeIdle), nil)
+	s.Require().Len(*events, 2)
+	s.Require().Equal(treadonme.StopEventSafetyKey, (*events)[1].Type)
+	s.Require().Equal(treadonme.WorkoutModeIdle, (*events)[1].Mode)
+}
+
+func (s *StopDetectorTestSuite) TestHostStopToIdle() {
+	sd, events := s.detector()
+
+	clock := treadtest.NewClock(time.Unix(0, 0))
+	sd.SetClock(clock)
+
+	// The host stopping the workout can leave the treadmill idle, that isn't the safety key.
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeRunning), nil)
+	sd.HostStop()
+	clock.Advance(time.Second)
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeIdle), nil)
+	s.Require().Empty(*events)
+
+	// Long after the host asked it is.
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeRunning), nil)
+	clock.Advance(5 * time.Second)
+	sd.HandleMessage(workoutMode(treadonme.WorkoutModeIdle), nil)
+	s.Require().Len(*events, 1)
+	s.Require().Equal(treadonme.StopEventSafetyKey, (*events)[0].Type)
+	s.Require().Equal(clock.Now(), (*events)[0].Time)
+}
+
+func (s *StopDetectorTestSuite) TestEmergencyStopToIdle() {
+	stop := treadtest.Frame(&treadonme.MessageCommand{Command: treadonme.CommandTypeStop})
+
+	mock := treadtest.New(s.T())
+	mock.ExpectWrite(stop).
+		Reply(treadtest.Frame(&treadonme.MessageACK{Acknowledged: treadonme.MessageTypeCommand})).
+		ThenPush(treadtest.Frame(workoutMode(treadonme.WorkoutModeIdle)))
+
+	tm := mock.Treadmill()
+
+	var events []treadonme.StopEvent
+	tm.AddStopListener(func(event treadonme.StopEvent) {
+		events = append(events, event)
+	})
+
+	mock.Push(treadtest.Frame(workoutMode(treadonme.WorkoutModeRunning)))
+	s.Require().NoError(tm.EmergencyStop(context.Background()))
+	s.Require().True(mock.AssertExpectations())
+	s.Require().Empty(events)
+}
+
+func TestStopDetectorTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &StopDetectorTestSuite{})
+}
@@ -0,0 +1,186 @@
+package treadonme
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrUnknownExportFormat = fmt.Errorf("unknown export format")
+
+type ExportFormat string
+
+const (
+	ExportFormatCSV   ExportFormat = "csv"
+	ExportFormatJSONL ExportFormat = "jsonl"
+)
+
+func ParseExportFormat(format string) (ExportFormat, error) {
+	switch ExportFormat(strings.ToLower(format)) {
+	case ExportFormatCSV:
+		return ExportFormatCSV, nil
+	case ExportFormatJSONL:
+		return ExportFormatJSONL, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrUnknownExportFormat, format)
+	}
+}
+
+func (ef ExportFormat) ContentType() string {
+	switch ef {
+	case ExportFormatCSV:
+		return "text/csv"
+	case ExportFormatJSONL:
+		return "application/x-ndjson"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+// SampleWriter streams samples to an underlying writer as they're recorded. Flush must be called once finished.
+type SampleWriter interface {
+	WriteSample(Sample) error
+	Flush() error
+}
+
+// NewSampleWriter creates a writer for the given format, samples are assumed to be in the "from" units and will be
+// written in the "to" units.
+func NewSampleWriter(w io.Writer, format ExportFormat, from, to UnitsType) (SampleWriter, error) {
+	switch format {
+	case ExportFormatCSV:
+		return &csvSampleWriter{w: csv.NewWriter(w), from: from, to: to}, nil
+	case ExportFormatJSONL:
+		return &jsonlSampleWriter{enc: json.NewEncoder(w), from: from, to: to}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownExportFormat, format)
+	}
+}
+
+// ExportSession writes all of the samples recorded so far in a session.
+func ExportSession(w io.Writer, session *Session, format ExportFormat, units UnitsType) error {
+	return ExportSamples(w, session.Samples(), session.Units, format, units)
+}
+
+func ExportSamples(w io.Writer, samples []Sample, from UnitsType, format ExportFormat, to UnitsType) error {
+	sw, err := NewSampleWriter(w, format, from, to)
+	if err != nil {
+		return err
+	}
+
+	for _, sample := range samples {
+		if err := sw.WriteSample(sample); err != nil {
+			return err
+		}
+	}
+
+	return sw.Flush()
+}
+
+type exportRow struct {
+	Timestamp       time.Time `json:"timestamp"`
+	Elapsed         float64   `json:"elapsed"`
+	Distance        float64   `json:"distance"`
+	Speed           float64   `json:"speed"`
+	Incline         byte      `json:"incline"`
+	HeartRate       byte      `json:"heart_rate"`
+	HeartRateSource string    `json:"heart_rate_source"`
+	Calories        uint16    `json:"calories"`
+	ProgramRow      byte      `json:"program_row"`
+	ProgramColumn   byte      `json:"program_column"`
+	Mode            string    `json:"mode"`
+}
+
+var exportColumns = []string{
+	"timestamp", "elapsed", "distance", "speed", "incline", "heart_rate", "heart_rate_source", "calories", "program_row",
+	"program_column", "mode",
+}
+
+func newExportRow(sample Sample, from, to UnitsType) exportRow {
+	return exportRow{
+		Timestamp: sample.Timestamp.UTC(),
+		Elapsed:   sample.Elapsed.Seconds(),
+		// Distances are transmitted multiplied by one hundred.
+		Distance:        from.Convert(float64(sample.Distance)/100, to),
+		Speed:           from.Convert(sample.Speed.Float(), to),
+		Incline:         sample.Incline,
+		HeartRate:       sample.HeartRate,
+		HeartRateSource: sample.HeartRateSource.String(),
+		Calories:        sample.Calories,
+		ProgramRow:      sample.ProgramRow,
+		ProgramColumn:   sample.ProgramColumn,
+		Mode:            sample.Mode.String(),
+	}
+}
+
+type csvSampleWriter struct {
+	w             *csv.Writer
+	from, to      UnitsType
+	headerWritten bool
+}
+
+func (cw *csvSampleWriter) WriteSample(sample Sample) error {
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+
+	row := newExportRow(sample, cw.from, cw.to)
+
+	if err := cw.w.Write([]string{
+		row.Timestamp.Format(time.RFC3339Nano),
+		strconv.FormatFloat(row.Elapsed, 'f', 3, 64),
+		strconv.FormatFloat(row.Distance, 'f', 3, 64),
+		strconv.FormatFloat(row.Speed, 'f', 2, 64),
+		strconv.Itoa(int(row.Incline)),
+		strconv.Itoa(int(row.HeartRate)),
+		row.HeartRateSource,
+		strconv.Itoa(int(row.Calories)),
+		strconv.Itoa(int(row.ProgramRow)),
+		strconv.Itoa(int(row.ProgramColumn)),
+		row.Mode,
+	}); err != nil {
+		return err
+	}
+
+	// Each row is flushed so it's streamed straight away and write errors aren't held back until the end.
+	cw.w.Flush()
+
+	return cw.w.Error()
+}
+
+func (cw *csvSampleWriter) Flush() error {
+	// Always write the header, even for an empty session.
+	if err := cw.writeHeader(); err != nil {
+		return err
+	}
+
+	cw.w.Flush()
+
+	return cw.w.Error()
+}
+
+func (cw *csvSampleWriter) writeHeader() error {
+	if cw.headerWritten {
+		return nil
+	}
+
+	cw.headerWritten = true
+
+	return cw.w.Write(exportColumns)
+}
+
+type jsonlSampleWriter struct {
+	enc      *json.Encoder
+	from, to UnitsType
+}
+
+func (jw *jsonlSampleWriter) WriteSample(sample Sample) error {
+	return jw.enc.Encode(newExportRow(sample, jw.from, jw.to))
+}
+
+func (jw *jsonlSampleWriter) Flush() error {
+	return nil
+}
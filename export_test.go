@@ -0,0 +1,142 @@
+package treadonme_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+var errDiskFull = fmt.Errorf("disk full")
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errDiskFull
+}
+
+type ExportTestSuite struct {
+	suite.Suite
+}
+
+func (s *ExportTestSuite) sample() treadonme.Sample {
+	return treadonme.Sample{
+		Timestamp:     time.Date(2022, 5, 1, 12, 0, 30, 0, time.UTC),
+		Elapsed:       30 * time.Second,
+		Distance:      102,
+		Calories:      12,
+		Speed:         62,
+		Incline:       2,
+		HeartRate:     120,
+		ProgramRow:    0,
+		ProgramColumn: 1,
+		Mode:          treadonme.WorkoutModeRunning,
+	}
+}
+
+func (s *ExportTestSuite) TestCSV() {
+	buf := &bytes.Buffer{}
+
+	sw, err := treadonme.NewSampleWriter(buf, treadonme.ExportFormatCSV, treadonme.UnitsTypeImperial, treadonme.UnitsTypeImperial)
+	s.Require().NoError(err)
+	s.Require().NoError(sw.WriteSample(s.sample()))
+	s.Require().NoError(sw.Flush())
+
+	s.Require().Equal(
+		"timestamp,elapsed,distance,speed,incline,heart_rate,heart_rate_source,calories,program_row,program_column,mode\n"+
+			"2022-05-01T12:00:30Z,30.000,1.020,6.20,2,120,Treadmill,12,0,1,Running\n",
+		buf.String(),
+	)
+}
+
+func (s *ExportTestSuite) TestCSVStreamed() {
+	buf := &bytes.Buffer{}
+
+	sw, err := treadonme.NewSampleWriter(buf, treadonme.ExportFormatCSV, treadonme.UnitsTypeMetric, treadonme.UnitsTypeMetric)
+	s.Require().NoError(err)
+	s.Require().NoError(sw.WriteSample(s.sample()))
+	s.Require().Equal(2, strings.Count(buf.String(), "\n"))
+
+	sw, err = treadonme.NewSampleWriter(failingWriter{}, treadonme.ExportFormatCSV, treadonme.UnitsTypeMetric,
+		treadonme.UnitsTypeMetric)
+	s.Require().NoError(err)
+	s.Require().ErrorIs(sw.WriteSample(s.sample()), errDiskFull)
+}
+
+func (s *ExportTestSuite) TestCSVEmpty() {
+	buf := &bytes.Buffer{}
+
+	sw, err := treadonme.NewSampleWriter(buf, treadonme.ExportFormatCSV, treadonme.UnitsTypeMetric, treadonme.UnitsTypeMetric)
+	s.Require().NoError(err)
+	s.Require().NoError(sw.Flush())
+	s.Require().True(strings.HasPrefix(buf.String(), "timestamp,"))
+}
+
+func (s *ExportTestSuite) TestJSONLMetric() {
+	buf := &bytes.Buffer{}
+
+	sw, err := treadonme.NewSampleWriter(buf, treadonme.ExportFormatJSONL, treadonme.UnitsTypeImperial, treadonme.UnitsTypeMetric)
+	s.Require().NoError(err)
+	s.Require().NoError(sw.WriteSample(s.sample()))
+	s.Require().NoError(sw.WriteSample(s.sample()))
+	s.Require().NoError(sw.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	s.Require().Len(lines, 2)
+
+	row := map[string]interface{}{}
+	s.Require().NoError(json.Unmarshal([]byte(lines[0]), &row))
+	s.Require().InDelta(1.6415, row["distance"], 0.001)
+	s.Require().InDelta(9.978, row["speed"], 0.001)
+	s.Require().Equal("Running", row["mode"])
+}
+
+func (s *ExportTestSuite) TestSessionRecordsWorkoutData() {
+	session := treadonme.NewSession(&treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial})
+
+	var streamed []treadonme.Sample
+
+	session.AddSampleListener(func(sample treadonme.Sample) {
+		streamed = append(streamed, sample)
+	})
+
+	session.HandleMessage(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeRunning}, nil)
+	session.HandleMessage(&treadonme.MessageWorkoutData{Distance: 5, Speed: 30}, nil)
+	session.HandleMessage(&treadonme.MessageWorkoutData{Distance: 6, Speed: 31}, nil)
+	session.HandleMessage(&treadonme.MessageEndWorkout{Seconds: 2}, nil)
+
+	samples := session.Samples()
+	s.Require().Len(samples, 2)
+	s.Require().Equal(samples, streamed)
+	s.Require().Equal(treadonme.WorkoutModeRunning, samples[0].Mode)
+	s.Require().Equal(treadonme.Speed(31), samples[1].Speed)
+	s.Require().True(session.Ended())
+	s.Require().Equal(uint16(2), session.Summary.Seconds)
+}
+
+func (s *ExportTestSuite) TestParse() {
+	format, err := treadonme.ParseExportFormat("JSONL")
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.ExportFormatJSONL, format)
+
+	_, err = treadonme.ParseExportFormat("xml")
+	s.Require().ErrorIs(err, treadonme.ErrUnknownExportFormat)
+
+	units, err := treadonme.ParseUnitsType("metric")
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.UnitsTypeMetric, units)
+
+	_, err = treadonme.ParseUnitsType("furlongs")
+	s.Require().ErrorIs(err, treadonme.ErrUnknownUnits)
+}
+
+func TestExportTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &ExportTestSuite{})
+}
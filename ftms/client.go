@@ -0,0 +1,479 @@
+package ftms
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/swedishborgie/treadonme"
+)
+
+var (
+	ErrNotConnected   = fmt.Errorf("not connected to fitness machine")
+	ErrControlFailed  = fmt.Errorf("fitness machine rejected the request")
+	ErrControlTimeout = fmt.Errorf("fitness machine didn't respond to the request")
+)
+
+// controlTimeout is how long to wait for the control point to respond to a request.
+const controlTimeout = 10 * time.Second
+
+// Client is a driver for treadmills that implement the standard Fitness Machine Service. It implements
+// treadonme.Equipment: telemetry is translated into the messages a Sole treadmill would send (in metric units) so it
+// can be used anywhere a Sole treadmill can.
+type Client struct {
+	addr       ble.Addr
+	client     ble.Client
+	bleDevice  ble.Device
+	controlChr *ble.Characteristic
+
+	controlMutex sync.Mutex
+	controlled   bool
+	responses    chan []byte
+
+	mutex     sync.Mutex
+	listeners []treadonme.MessageListener
+	info      *treadonme.MessageDeviceInfo
+	mode      treadonme.WorkoutMode
+	last      *treadonme.MessageWorkoutData
+	elapsed   uint16
+}
+
+var _ treadonme.Equipment = (*Client)(nil)
+
+func NewClient(addr string) *Client {
+	return &Client{
+		addr:      ble.NewAddr(addr),
+		mode:      treadonme.WorkoutModeIdle,
+		responses: make(chan []byte, 1),
+	}
+}
+
+func (c *Client) AddListener(listener treadonme.MessageListener) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.listeners = append(c.listeners, listener)
+}
+
+func (c *Client) Connect(ctx context.Context) error {
+	if c.bleDevice == nil {
+		bleDevice, err := treadonme.AcquireDevice()
+		if err != nil {
+			return err
+		}
+
+		c.bleDevice = bleDevice
+	}
+
+	cleanUp := func() {
+		if err := c.Close(); err != nil {
+			log.Printf("failed to clean up after failed fitness machine connect: %s", err)
+		}
+	}
+
+	var (
+		mutex sync.Mutex
+		found bool
+	)
+
+	toContext, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	dev, err := ble.Connect(toContext, func(a ble.Advertisement) bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !found && a.Addr().String() == c.addr.String() {
+			found = true
+
+			return true
+		}
+
+		return false
+	})
+	if err != nil {
+		defer cleanUp()
+
+		return fmt.Errorf("problem connecting to fitness machine: %w", err)
+	}
+
+	c.client = dev
+
+	if err := c.discover(dev); err != nil {
+		defer cleanUp()
+
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) discover(dev ble.Client) error {
+	svcs, err := dev.DiscoverServices([]ble.UUID{ServiceUUID})
+	if err != nil {
+		return fmt.Errorf("failed to discover services on fitness machine: %w", err)
+	} else if len(svcs) == 0 {
+		return fmt.Errorf("%w: %s", treadonme.ErrMissingService, ServiceUUID.String())
+	}
+
+	chrs, err := dev.DiscoverCharacteristics(nil, svcs[0])
+	if err != nil {
+		return fmt.Errorf("failed to discover characteristics on fitness machine: %w", err)
+	}
+
+	found := map[string]*ble.Characteristic{}
+
+	for _, chr := range chrs {
+		if _, err := dev.DiscoverDescriptors(nil, chr); err != nil {
+			return fmt.Errorf("failed to discover descriptors on fitness machine: %w", err)
+		}
+
+		found[chr.UUID.String()] = chr
+	}
+
+	for _, required := range []ble.UUID{TreadmillDataUUID, SupportedSpeedRangeUUID, ControlPointUUID} {
+		if found[required.String()] == nil {
+			return fmt.Errorf("%w: %s", treadonme.ErrMissingCharacteristic, required.String())
+		}
+	}
+
+	speed := &SpeedRange{}
+	if err := c.read(dev, found[SupportedSpeedRangeUUID.String()], speed); err != nil {
+		return err
+	}
+
+	// Not every treadmill can incline.
+	var incline *InclinationRange
+	if chr := found[SupportedInclinationRangeUUID.String()]; chr != nil {
+		incline = &InclinationRange{}
+		if err := c.read(dev, chr, incline); err != nil {
+			return err
+		}
+	}
+
+	c.controlChr = found[ControlPointUUID.String()]
+
+	if err := dev.Subscribe(c.controlChr, true, c.recvControl); err != nil {
+		return fmt.Errorf("failed to subscribe to control point: %w", err)
+	}
+
+	if chr := found[StatusUUID.String()]; chr != nil {
+		if err := dev.Subscribe(chr, false, c.recvStatus); err != nil {
+			return fmt.Errorf("failed to subscribe to fitness machine status: %w", err)
+		}
+	}
+
+	if err := dev.Subscribe(found[TreadmillDataUUID.String()], false, c.recvData); err != nil {
+		return fmt.Errorf("failed to subscribe to treadmill data: %w", err)
+	}
+
+	info := DecodeDeviceInfo(speed, incline)
+
+	c.mutex.Lock()
+	c.info = info
+	c.mutex.Unlock()
+
+	c.dispatch(info, nil)
+
+	return nil
+}
+
+func (c *Client) read(dev ble.Client, chr *ble.Characteristic, v interface{ UnmarshalBinary([]byte) error }) error {
+	data, err := dev.ReadCharacteristic(chr)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", chr.UUID.String(), err)
+	}
+
+	return v.UnmarshalBinary(data)
+}
+
+func (c *Client) Close() error {
+	if c.client != nil {
+		if err := c.client.ClearSubscriptions(); err != nil {
+			return fmt.Errorf("failed to clear fitness machine subscriptions: %w", err)
+		}
+
+		if err := c.client.CancelConnection(); err != nil {
+			return err
+		}
+
+		c.client = nil
+		c.controlChr = nil
+	}
+
+	c.controlMutex.Lock()
+	c.controlled = false
+	c.controlMutex.Unlock()
+
+	if c.bleDevice != nil {
+		c.bleDevice = nil
+
+		if err := treadonme.ReleaseDevice(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetDeviceInfo returns the device info built from the supported ranges read when connecting.
+func (c *Client) GetDeviceInfo() (*treadonme.MessageDeviceInfo, error) {
+	if info := c.DeviceInfo(); info != nil {
+		return info, nil
+	}
+
+	return nil, ErrNotConnected
+}
+
+func (c *Client) DeviceInfo() *treadonme.MessageDeviceInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.info
+}
+
+func (c *Client) CurrentMode() treadonme.WorkoutMode {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.mode
+}
+
+func (c *Client) CurrentSpeed() (treadonme.Speed, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.last == nil {
+		return 0, false
+	}
+
+	return c.last.Speed, true
+}
+
+func (c *Client) CurrentIncline() (byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.last == nil {
+		return 0, false
+	}
+
+	return c.last.Incline, true
+}
+
+// SetTargetSpeed sets the target speed (in tenths of a km/h), unlike Sole treadmills the target is set directly.
+func (c *Client) SetTargetSpeed(ctx context.Context, speed treadonme.Speed) error {
+	info := c.DeviceInfo()
+	if info == nil {
+		return ErrNotConnected
+	} else if speed < info.MinSpeed || speed > info.MaxSpeed {
+		return fmt.Errorf("%w: speed %d, supported %d-%d", treadonme.ErrTargetOutOfRange, speed, info.MinSpeed,
+			info.MaxSpeed)
+	}
+
+	return c.control(ctx, OpSetTargetSpeed, appendUint16(nil, EncodeSpeed(speed, treadonme.UnitsTypeMetric)))
+}
+
+func (c *Client) SetTargetIncline(ctx context.Context, incline byte) error {
+	info := c.DeviceInfo()
+	if info == nil {
+		return ErrNotConnected
+	} else if incline > info.InclineMax {
+		return fmt.Errorf("%w: incline %d, max %d", treadonme.ErrTargetOutOfRange, incline, info.InclineMax)
+	}
+
+	return c.control(ctx, OpSetTargetInclination, appendUint16(nil, uint16(EncodeIncline(incline))))
+}
+
+// LevelUp increases the speed by a tenth of a km/h.
+func (c *Client) LevelUp() error {
+	speed, ok := c.CurrentSpeed()
+	if !ok {
+		return treadonme.ErrUnknownCurrentValue
+	}
+
+	return c.SetTargetSpeed(context.Background(), speed+1)
+}
+
+// LevelDown decreases the speed by a tenth of a km/h.
+func (c *Client) LevelDown() error {
+	speed, ok := c.CurrentSpeed()
+	if !ok {
+		return treadonme.ErrUnknownCurrentValue
+	}
+
+	return c.SetTargetSpeed(context.Background(), speed-1)
+}
+
+func (c *Client) Start() error {
+	return c.startOrStop(OpStartOrResume, nil, treadonme.WorkoutModeRunning)
+}
+
+func (c *Client) Resume() error {
+	return c.startOrStop(OpStartOrResume, nil, treadonme.WorkoutModeRunning)
+}
+
+func (c *Client) Stop() error {
+	return c.startOrStop(OpStopOrPause, []byte{statusParameterStoppedByUser}, treadonme.WorkoutModeDone)
+}
+
+func (c *Client) Pause() error {
+	return c.startOrStop(OpStopOrPause, []byte{statusParameterPausedByUser}, treadonme.WorkoutModePause)
+}
+
+func (c *Client) startOrStop(op OpCode, params []byte, mode treadonme.WorkoutMode) error {
+	if err := c.control(context.Background(), op, params); err != nil {
+		return err
+	}
+
+	// Not every treadmill sends a status notification, the request succeeding is as good as one.
+	c.setMode(mode)
+
+	return nil
+}
+
+// control writes a request to the control point and waits for the response. Control is requested first if it hasn't
+// been granted yet.
+func (c *Client) control(ctx context.Context, op OpCode, params []byte) error {
+	c.controlMutex.Lock()
+	defer c.controlMutex.Unlock()
+
+	if !c.controlled {
+		if err := c.request(ctx, OpRequestControl, nil); err != nil {
+			return err
+		}
+
+		c.controlled = true
+	}
+
+	return c.request(ctx, op, params)
+}
+
+func (c *Client) request(ctx context.Context, op OpCode, params []byte) error {
+	if c.client == nil || c.controlChr == nil {
+		return ErrNotConnected
+	}
+
+	// Throw away anything left over from a request that timed out.
+	select {
+	case <-c.responses:
+	default:
+	}
+
+	if err := c.client.WriteCharacteristic(c.controlChr, append([]byte{byte(op)}, params...), false); err != nil {
+		return fmt.Errorf("failed to write to control point: %w", err)
+	}
+
+	timeout := time.NewTimer(controlTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return fmt.Errorf("%w: op code %#02x", ErrControlTimeout, byte(op))
+		case rsp := <-c.responses:
+			if len(rsp) < 3 || OpCode(rsp[0]) != OpResponse || OpCode(rsp[1]) != op {
+				continue
+			}
+
+			if result := ResultCode(rsp[2]); result == ResultControlNotPermitted {
+				// Control was taken away (or never granted), it'll be requested again next time.
+				c.controlled = false
+
+				return fmt.Errorf("%w: control not permitted", ErrControlFailed)
+			} else if result != ResultSuccess {
+				return fmt.Errorf("%w: op code %#02x returned %#02x", ErrControlFailed, byte(op), byte(result))
+			}
+
+			return nil
+		}
+	}
+}
+
+func (c *Client) recvControl(data []byte) {
+	select {
+	case c.responses <- append([]byte(nil), data...):
+	default:
+		log.Printf("dropping unexpected control point response: %x", data)
+	}
+}
+
+func (c *Client) recvStatus(data []byte) {
+	status := &Status{}
+	if err := status.UnmarshalBinary(data); err != nil {
+		c.dispatch(nil, err)
+
+		return
+	}
+
+	if mode, ok := DecodeWorkoutMode(status); ok {
+		c.setMode(mode)
+	}
+}
+
+func (c *Client) recvData(data []byte) {
+	td := &TreadmillData{}
+	if err := td.UnmarshalBinary(data); err != nil {
+		c.dispatch(nil, err)
+
+		return
+	}
+
+	wd := DecodeWorkoutData(td)
+
+	c.mutex.Lock()
+	c.last = wd
+	if td.has(TreadmillDataElapsedTime) {
+		c.elapsed = td.ElapsedTime
+	}
+	c.mutex.Unlock()
+
+	c.dispatch(wd, nil)
+}
+
+// setMode records a change in workout mode and tells listeners about it, a workout finishing is followed by a summary
+// of the workout like a Sole treadmill sends.
+func (c *Client) setMode(mode treadonme.WorkoutMode) {
+	c.mutex.Lock()
+	if c.mode == mode {
+		c.mutex.Unlock()
+
+		return
+	}
+
+	c.mode = mode
+
+	var summary *treadonme.MessageEndWorkout
+	if mode == treadonme.WorkoutModeDone && c.last != nil {
+		summary = &treadonme.MessageEndWorkout{
+			Seconds:   c.elapsed,
+			Distance:  c.last.Distance,
+			Calories:  c.last.Calories,
+			Speed:     c.last.Speed,
+			HeartRate: c.last.HeartRate,
+			Incline:   c.last.Incline,
+		}
+	}
+	c.mutex.Unlock()
+
+	c.dispatch(&treadonme.MessageWorkoutMode{Mode: mode}, nil)
+
+	if summary != nil {
+		c.dispatch(summary, nil)
+	}
+}
+
+func (c *Client) dispatch(msg treadonme.Message, err error) {
+	c.mutex.Lock()
+	listeners := c.listeners
+	c.mutex.Unlock()
+
+	for _, l := range listeners {
+		l(msg, err)
+	}
+}
@@ -0,0 +1,188 @@
+package ftms
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// OpCode is the first byte of a request written to the Fitness Machine Control Point (0x2AD9).
+type OpCode byte
+
+const (
+	OpRequestControl       OpCode = 0x00
+	OpReset                OpCode = 0x01
+	OpSetTargetSpeed       OpCode = 0x02
+	OpSetTargetInclination OpCode = 0x03
+	OpStartOrResume        OpCode = 0x07
+	OpStopOrPause          OpCode = 0x08
+	OpResponse             OpCode = 0x80
+)
+
+// ResultCode is the outcome of a control point request, sent back in an indication.
+type ResultCode byte
+
+const (
+	ResultSuccess             ResultCode = 0x01
+	ResultNotSupported        ResultCode = 0x02
+	ResultInvalidParameter    ResultCode = 0x03
+	ResultOperationFailed     ResultCode = 0x04
+	ResultControlNotPermitted ResultCode = 0x05
+)
+
+// WorkoutController is implemented by equipment whose workouts can be started and stopped by the host. The start,
+// stop and pause op codes are only supported if the equipment implements it.
+type WorkoutController interface {
+	Start() error
+	Stop() error
+	Pause() error
+	Resume() error
+}
+
+// ControlPoint maps FTMS control point requests onto a treadmill. The client has to request control before anything
+// else is accepted, as the spec requires.
+type ControlPoint struct {
+	equipment treadonme.Controller
+
+	mutex      sync.Mutex
+	controlled bool
+}
+
+func NewControlPoint(equipment treadonme.Controller) *ControlPoint {
+	return &ControlPoint{equipment: equipment}
+}
+
+// Release gives up control, it should be called when the client disconnects.
+func (cp *ControlPoint) Release() {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	cp.controlled = false
+}
+
+// Handle runs a control point request and returns the response to indicate back to the client, along with a status
+// notification if the request changed the state of the treadmill. Requests are run one at a time, setting a target
+// can take a while since the treadmill has to be stepped there.
+func (cp *ControlPoint) Handle(ctx context.Context, req []byte) ([]byte, *Status) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+
+	if len(req) == 0 {
+		return nil, nil
+	}
+
+	op := OpCode(req[0])
+	result, status := cp.handle(ctx, op, req[1:])
+
+	return []byte{byte(OpResponse), byte(op), byte(result)}, status
+}
+
+func (cp *ControlPoint) handle(ctx context.Context, op OpCode, params []byte) (ResultCode, *Status) {
+	switch op {
+	case OpRequestControl:
+		cp.controlled = true
+
+		return ResultSuccess, nil
+	case OpReset, OpSetTargetSpeed, OpSetTargetInclination, OpStartOrResume, OpStopOrPause:
+		if !cp.controlled {
+			return ResultControlNotPermitted, nil
+		}
+	default:
+		return ResultNotSupported, nil
+	}
+
+	switch op {
+	case OpReset:
+		cp.controlled = false
+
+		return ResultSuccess, &Status{OpCode: StatusReset}
+	case OpSetTargetSpeed:
+		return cp.setTargetSpeed(ctx, params)
+	case OpSetTargetInclination:
+		return cp.setTargetInclination(ctx, params)
+	default:
+		return cp.startOrStop(op, params)
+	}
+}
+
+func (cp *ControlPoint) setTargetSpeed(ctx context.Context, params []byte) (ResultCode, *Status) {
+	if len(params) != 2 {
+		return ResultInvalidParameter, nil
+	}
+
+	speed := binary.LittleEndian.Uint16(params)
+
+	info := cp.equipment.DeviceInfo()
+	if info == nil {
+		return ResultOperationFailed, nil
+	}
+
+	if err := cp.equipment.SetTargetSpeed(ctx, DecodeSpeed(speed, info.Units)); err != nil {
+		return resultFor(err), nil
+	}
+
+	return ResultSuccess, StatusTargetSpeed(speed)
+}
+
+func (cp *ControlPoint) setTargetInclination(ctx context.Context, params []byte) (ResultCode, *Status) {
+	if len(params) != 2 {
+		return ResultInvalidParameter, nil
+	}
+
+	incline := int16(binary.LittleEndian.Uint16(params))
+	if incline < 0 {
+		return ResultInvalidParameter, nil
+	}
+
+	if err := cp.equipment.SetTargetIncline(ctx, DecodeIncline(incline)); err != nil {
+		return resultFor(err), nil
+	}
+
+	return ResultSuccess, StatusTargetIncline(incline)
+}
+
+func (cp *ControlPoint) startOrStop(op OpCode, params []byte) (ResultCode, *Status) {
+	workout, ok := cp.equipment.(WorkoutController)
+	if !ok {
+		return ResultNotSupported, nil
+	}
+
+	var (
+		err    error
+		status *Status
+	)
+
+	switch {
+	case op == OpStartOrResume && cp.equipment.CurrentMode() == treadonme.WorkoutModePause:
+		err, status = workout.Resume(), StatusStarted()
+	case op == OpStartOrResume && cp.equipment.CurrentMode() == treadonme.WorkoutModeRunning:
+		return ResultSuccess, nil
+	case op == OpStartOrResume:
+		err, status = workout.Start(), StatusStarted()
+	case len(params) != 1:
+		return ResultInvalidParameter, nil
+	case params[0] == statusParameterStoppedByUser:
+		err, status = workout.Stop(), StatusStopped()
+	case params[0] == statusParameterPausedByUser:
+		err, status = workout.Pause(), StatusPaused()
+	default:
+		return ResultInvalidParameter, nil
+	}
+
+	if err != nil {
+		return resultFor(err), nil
+	}
+
+	return ResultSuccess, status
+}
+
+func resultFor(err error) ResultCode {
+	if errors.Is(err, treadonme.ErrTargetOutOfRange) {
+		return ResultInvalidParameter
+	}
+
+	return ResultOperationFailed
+}
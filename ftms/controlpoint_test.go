@@ -0,0 +1,174 @@
+package ftms_test
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/ftms"
+)
+
+type fakeTreadmill struct {
+	info     *treadonme.MessageDeviceInfo
+	mode     treadonme.WorkoutMode
+	speed    treadonme.Speed
+	incline  byte
+	calls    []string
+	failNext error
+}
+
+func (f *fakeTreadmill) DeviceInfo() *treadonme.MessageDeviceInfo { return f.info }
+func (f *fakeTreadmill) CurrentMode() treadonme.WorkoutMode       { return f.mode }
+func (f *fakeTreadmill) CurrentSpeed() (treadonme.Speed, bool)    { return f.speed, true }
+func (f *fakeTreadmill) CurrentIncline() (byte, bool)             { return f.incline, true }
+
+func (f *fakeTreadmill) SetTargetSpeed(_ context.Context, speed treadonme.Speed) error {
+	if speed < f.info.MinSpeed || speed > f.info.MaxSpeed {
+		return fmt.Errorf("%w: %d", treadonme.ErrTargetOutOfRange, speed)
+	}
+
+	f.speed = speed
+
+	return f.fail()
+}
+
+func (f *fakeTreadmill) SetTargetIncline(_ context.Context, incline byte) error {
+	if incline > f.info.InclineMax {
+		return fmt.Errorf("%w: %d", treadonme.ErrTargetOutOfRange, incline)
+	}
+
+	f.incline = incline
+
+	return f.fail()
+}
+
+func (f *fakeTreadmill) Start() error  { return f.call("start") }
+func (f *fakeTreadmill) Stop() error   { return f.call("stop") }
+func (f *fakeTreadmill) Pause() error  { return f.call("pause") }
+func (f *fakeTreadmill) Resume() error { return f.call("resume") }
+
+func (f *fakeTreadmill) call(name string) error {
+	f.calls = append(f.calls, name)
+
+	return f.fail()
+}
+
+func (f *fakeTreadmill) fail() error {
+	err := f.failNext
+	f.failNext = nil
+
+	return err
+}
+
+type ControlPointTestSuite struct {
+	suite.Suite
+
+	treadmill *fakeTreadmill
+	cp        *ftms.ControlPoint
+}
+
+func (s *ControlPointTestSuite) SetupTest() {
+	s.treadmill = &fakeTreadmill{
+		info: &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial, MinSpeed: 5, MaxSpeed: 120, InclineMax: 15},
+		mode: treadonme.WorkoutModeRunning,
+	}
+	s.cp = ftms.NewControlPoint(s.treadmill)
+}
+
+func (s *ControlPointTestSuite) handle(req string) (string, *ftms.Status) {
+	rsp, status := s.cp.Handle(context.Background(), fromHex(req))
+
+	return hex.EncodeToString(rsp), status
+}
+
+func (s *ControlPointTestSuite) TestRequiresControl() {
+	rsp, status := s.handle("02c603")
+	s.Require().Equal("800205", rsp)
+	s.Require().Nil(status)
+	s.Require().Zero(s.treadmill.speed)
+
+	rsp, _ = s.handle("00")
+	s.Require().Equal("800001", rsp)
+
+	rsp, _ = s.handle("02c603")
+	s.Require().Equal("800201", rsp)
+
+	s.cp.Release()
+
+	rsp, _ = s.handle("0300")
+	s.Require().Equal("800305", rsp)
+}
+
+func (s *ControlPointTestSuite) TestSetTargetSpeed() {
+	s.handle("00")
+
+	// 9.66 km/h is 6.0 mph.
+	rsp, status := s.handle("02c603")
+	s.Require().Equal("800201", rsp)
+	s.Require().Equal(treadonme.Speed(60), s.treadmill.speed)
+	s.Require().Equal(ftms.StatusTargetSpeedChanged, status.OpCode)
+
+	// 30 km/h is faster than the treadmill goes.
+	rsp, status = s.handle("02b80b")
+	s.Require().Equal("800203", rsp)
+	s.Require().Nil(status)
+
+	rsp, _ = s.handle("02c6")
+	s.Require().Equal("800203", rsp)
+
+	s.treadmill.failNext = treadonme.ErrNotConfirmed
+	rsp, _ = s.handle("02c603")
+	s.Require().Equal("800204", rsp)
+}
+
+func (s *ControlPointTestSuite) TestSetTargetInclination() {
+	s.handle("00")
+
+	rsp, status := s.handle("033200")
+	s.Require().Equal("800301", rsp)
+	s.Require().Equal(byte(5), s.treadmill.incline)
+	s.Require().Equal(ftms.StatusTargetInclineChanged, status.OpCode)
+
+	// Declines aren't supported.
+	rsp, _ = s.handle("03f6ff")
+	s.Require().Equal("800303", rsp)
+}
+
+func (s *ControlPointTestSuite) TestStartStop() {
+	s.handle("00")
+
+	rsp, status := s.handle("0802")
+	s.Require().Equal("800801", rsp)
+	s.Require().Equal(ftms.StatusPaused(), status)
+
+	s.treadmill.mode = treadonme.WorkoutModePause
+	rsp, status = s.handle("07")
+	s.Require().Equal("800701", rsp)
+	s.Require().Equal(ftms.StatusStarted(), status)
+
+	rsp, status = s.handle("0801")
+	s.Require().Equal("800801", rsp)
+	s.Require().Equal(ftms.StatusStopped(), status)
+
+	rsp, _ = s.handle("0803")
+	s.Require().Equal("800803", rsp)
+
+	s.Require().Equal([]string{"pause", "resume", "stop"}, s.treadmill.calls)
+}
+
+func (s *ControlPointTestSuite) TestUnsupported() {
+	rsp, _ := s.handle("04")
+	s.Require().Equal("800402", rsp)
+
+	rsp, _ = s.handle("")
+	s.Require().Empty(rsp)
+}
+
+func TestControlPointTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &ControlPointTestSuite{})
+}
@@ -0,0 +1,82 @@
+package ftms
+
+import (
+	"math"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// DecodeWorkoutData converts FTMS treadmill data into the workout data a Sole treadmill would send, in metric units.
+// Fields the treadmill didn't include are left at zero.
+func DecodeWorkoutData(td *TreadmillData) *treadonme.MessageWorkoutData {
+	wd := &treadonme.MessageWorkoutData{
+		Speed:     decodeSpeed(td.Speed),
+		HeartRate: td.HeartRate,
+	}
+
+	if td.has(TreadmillDataMoreData) {
+		wd.Speed = 0
+	}
+
+	if td.has(TreadmillDataTotalDistance) {
+		// Distances are in hundredths of a kilometer.
+		wd.Distance = uint16(math.Min(float64(td.TotalDistance)/10, math.MaxUint16))
+	}
+
+	if td.has(TreadmillDataInclination) && td.Inclination > 0 {
+		wd.Incline = DecodeIncline(td.Inclination)
+	}
+
+	if td.has(TreadmillDataExpendedEnergy) && td.TotalEnergy != 0xffff {
+		wd.Calories = td.TotalEnergy
+	}
+
+	if td.has(TreadmillDataRemainingTime) {
+		wd.Minute = byte(math.Min(float64(td.RemainingTime/60), math.MaxUint8))
+		wd.Second = byte(td.RemainingTime % 60)
+	}
+
+	return wd
+}
+
+// DecodeWorkoutMode converts a fitness machine status notification into the workout mode it implies, false is
+// returned if it doesn't change the mode.
+func DecodeWorkoutMode(status *Status) (treadonme.WorkoutMode, bool) {
+	switch status.OpCode {
+	case StatusStartedOrResumed:
+		return treadonme.WorkoutModeRunning, true
+	case StatusStoppedOrPaused:
+		if len(status.Parameters) > 0 && status.Parameters[0] == statusParameterPausedByUser {
+			return treadonme.WorkoutModePause, true
+		}
+
+		return treadonme.WorkoutModeDone, true
+	case StatusStoppedBySafetyKey:
+		return treadonme.WorkoutModeDone, true
+	case StatusReset:
+		return treadonme.WorkoutModeIdle, true
+	default:
+		return 0, false
+	}
+}
+
+// DecodeDeviceInfo builds device info for an FTMS treadmill from its supported ranges, FTMS treadmills always report
+// in metric units.
+func DecodeDeviceInfo(speed *SpeedRange, incline *InclinationRange) *treadonme.MessageDeviceInfo {
+	info := &treadonme.MessageDeviceInfo{
+		Units:    treadonme.UnitsTypeMetric,
+		MinSpeed: decodeSpeed(speed.Min),
+		MaxSpeed: decodeSpeed(speed.Max),
+	}
+
+	if incline != nil && incline.Max > 0 {
+		info.InclineMax = DecodeIncline(incline.Max)
+	}
+
+	return info
+}
+
+// decodeSpeed converts 0.01 km/h into tenths of a km/h, capped at the fastest speed a Sole message can hold.
+func decodeSpeed(speed uint16) treadonme.Speed {
+	return treadonme.Speed(math.Min(math.Round(float64(speed)/10), math.MaxUint8))
+}
@@ -0,0 +1,147 @@
+package ftms_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/ftms"
+)
+
+type DecodeTestSuite struct {
+	suite.Suite
+}
+
+func (s *DecodeTestSuite) parse(payload string) *ftms.TreadmillData {
+	td := &ftms.TreadmillData{}
+	s.Require().NoError(td.UnmarshalBinary(fromHex(payload)))
+
+	return td
+}
+
+func (s *DecodeTestSuite) TestTreadmillData() {
+	// Speed, distance, inclination (with the ramp angle not available), energy and elapsed time.
+	td := s.parse("8c045203d204001900ff7f5500ffffff5802")
+	s.Require().Equal(ftms.TreadmillDataTotalDistance|ftms.TreadmillDataInclination|ftms.TreadmillDataExpendedEnergy|
+		ftms.TreadmillDataElapsedTime, td.Flags)
+	s.Require().Equal(uint16(850), td.Speed)
+	s.Require().Equal(uint32(1234), td.TotalDistance)
+	s.Require().Equal(int16(25), td.Inclination)
+	s.Require().Equal(ftms.RampAngleNotAvailable, td.RampAngle)
+	s.Require().Equal(uint16(85), td.TotalEnergy)
+	s.Require().Equal(uint16(600), td.ElapsedTime)
+
+	wd := ftms.DecodeWorkoutData(td)
+	s.Require().Equal(treadonme.Speed(85), wd.Speed)
+	s.Require().Equal(uint16(123), wd.Distance)
+	s.Require().Equal(byte(3), wd.Incline)
+	s.Require().Equal(uint16(85), wd.Calories)
+	s.Require().Zero(wd.Minute)
+}
+
+func (s *DecodeTestSuite) TestSpeedOnly() {
+	td := s.parse("00002c01")
+	s.Require().Equal(uint16(300), td.Speed)
+	s.Require().Equal(treadonme.Speed(30), ftms.DecodeWorkoutData(td).Speed)
+}
+
+func (s *DecodeTestSuite) TestMoreData() {
+	// Treadmills that split their data over several notifications set "more data" and leave out the speed.
+	td := s.parse("010182")
+	s.Require().Equal(byte(130), td.HeartRate)
+	s.Require().Zero(td.Speed)
+
+	wd := ftms.DecodeWorkoutData(td)
+	s.Require().Equal(byte(130), wd.HeartRate)
+	s.Require().Zero(wd.Speed)
+}
+
+func (s *DecodeTestSuite) TestEverything() {
+	td := s.parse("fe1f" + "2c01" + "e803" + "0a0000" + "1400" + "0b00" + "0500" + "0000" + "40" + "41" +
+		"0000" + "0000" + "00" + "96" + "50" + "1e00" + "2c01" + "f4ff" + "c800")
+	s.Require().Equal(uint16(1000), td.AverageSpeed)
+	s.Require().Equal(uint32(10), td.TotalDistance)
+	s.Require().Equal(int16(20), td.Inclination)
+	s.Require().Equal(int16(11), td.RampAngle)
+	s.Require().Equal(uint16(5), td.PositiveElevationGain)
+	s.Require().Equal(byte(0x40), td.InstantaneousPace)
+	s.Require().Equal(byte(0x41), td.AveragePace)
+	s.Require().Equal(byte(150), td.HeartRate)
+	s.Require().Equal(byte(80), td.MetabolicEquivalent)
+	s.Require().Equal(uint16(30), td.ElapsedTime)
+	s.Require().Equal(uint16(300), td.RemainingTime)
+	s.Require().Equal(int16(-12), td.ForceOnBelt)
+	s.Require().Equal(int16(200), td.PowerOutput)
+
+	wd := ftms.DecodeWorkoutData(td)
+	s.Require().Equal(byte(5), wd.Minute)
+	s.Require().Equal(byte(0), wd.Second)
+}
+
+func (s *DecodeTestSuite) TestTruncated() {
+	for _, payload := range []string{"", "00", "0000c6", "8c045203d2"} {
+		td := &ftms.TreadmillData{}
+		s.Require().ErrorIs(td.UnmarshalBinary(fromHex(payload)), ftms.ErrInvalidPayload, payload)
+	}
+}
+
+func (s *DecodeTestSuite) TestRoundTrip() {
+	encoded := ftms.EncodeTreadmillData(&treadonme.MessageWorkoutData{
+		Minute: 10, Second: 5, Distance: 123, Calories: 40, HeartRate: 120, Speed: 85, Incline: 2,
+	}, treadonme.UnitsTypeMetric, time.Minute)
+
+	data, err := encoded.MarshalBinary()
+	s.Require().NoError(err)
+
+	decoded := &ftms.TreadmillData{}
+	s.Require().NoError(decoded.UnmarshalBinary(data))
+	s.Require().Equal(encoded, decoded)
+
+	wd := ftms.DecodeWorkoutData(decoded)
+	s.Require().Equal(&treadonme.MessageWorkoutData{
+		Minute: 10, Second: 5, Distance: 123, Calories: 40, HeartRate: 120, Speed: 85, Incline: 2,
+	}, wd)
+}
+
+func (s *DecodeTestSuite) TestDeviceInfo() {
+	speed := &ftms.SpeedRange{}
+	s.Require().NoError(speed.UnmarshalBinary(fromHex("6400b0040a00")))
+
+	incline := &ftms.InclinationRange{}
+	s.Require().NoError(incline.UnmarshalBinary(fromHex("000096000a00")))
+
+	info := ftms.DecodeDeviceInfo(speed, incline)
+	s.Require().Equal(treadonme.UnitsTypeMetric, info.Units)
+	s.Require().Equal(treadonme.Speed(10), info.MinSpeed)
+	s.Require().Equal(treadonme.Speed(120), info.MaxSpeed)
+	s.Require().Equal(byte(15), info.InclineMax)
+
+	s.Require().Zero(ftms.DecodeDeviceInfo(speed, nil).InclineMax)
+}
+
+func (s *DecodeTestSuite) TestWorkoutMode() {
+	for payload, expected := range map[string]treadonme.WorkoutMode{
+		"04":   treadonme.WorkoutModeRunning,
+		"0202": treadonme.WorkoutModePause,
+		"0201": treadonme.WorkoutModeDone,
+		"03":   treadonme.WorkoutModeDone,
+		"01":   treadonme.WorkoutModeIdle,
+	} {
+		status := &ftms.Status{}
+		s.Require().NoError(status.UnmarshalBinary(fromHex(payload)))
+
+		mode, ok := ftms.DecodeWorkoutMode(status)
+		s.Require().True(ok, payload)
+		s.Require().Equal(expected, mode, payload)
+	}
+
+	_, ok := ftms.DecodeWorkoutMode(ftms.StatusTargetSpeed(100))
+	s.Require().False(ok)
+}
+
+func TestDecodeTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &DecodeTestSuite{})
+}
@@ -0,0 +1,55 @@
+package ftms
+
+import (
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// EncodeTreadmillData converts Sole workout data into FTMS treadmill data. The treadmill doesn't report how long the
+// workout has been running so the elapsed time has to be tracked by the caller.
+func EncodeTreadmillData(data *treadonme.MessageWorkoutData, units treadonme.UnitsType,
+	elapsed time.Duration) *TreadmillData {
+	td := &TreadmillData{
+		Flags: TreadmillDataTotalDistance | TreadmillDataInclination | TreadmillDataExpendedEnergy |
+			TreadmillDataElapsedTime | TreadmillDataRemainingTime,
+		Speed:         EncodeSpeed(data.Speed, units),
+		TotalDistance: EncodeDistance(data.Distance, units),
+		Inclination:   EncodeIncline(data.Incline),
+		// The treadmill doesn't report the ramp angle, zero would claim it's flat.
+		RampAngle:   RampAngleNotAvailable,
+		TotalEnergy: data.Calories,
+		// The per hour and per minute rates aren't available, the spec uses all ones for "not available".
+		EnergyPerHour:   0xffff,
+		EnergyPerMinute: 0xff,
+		ElapsedTime:     uint16(elapsed / time.Second),
+		RemainingTime:   uint16(data.Minute)*60 + uint16(data.Second),
+	}
+
+	if data.HeartRate != 0 {
+		td.Flags |= TreadmillDataHeartRate
+		td.HeartRate = data.HeartRate
+	}
+
+	return td
+}
+
+// EncodeStatus converts a Sole message into a fitness machine status notification, nil is returned if the message
+// doesn't change the status.
+func EncodeStatus(msg treadonme.Message) *Status {
+	v, ok := msg.(*treadonme.MessageWorkoutMode)
+	if !ok {
+		return nil
+	}
+
+	switch v.Mode {
+	case treadonme.WorkoutModeRunning:
+		return StatusStarted()
+	case treadonme.WorkoutModePause:
+		return StatusPaused()
+	case treadonme.WorkoutModeDone:
+		return StatusStopped()
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,476 @@
+// Package ftms implements the parts of the Bluetooth Fitness Machine Service (FTMS) needed to present a treadmill to
+// apps that only speak the standard protocol.
+package ftms
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"github.com/go-ble/ble"
+	"github.com/swedishborgie/treadonme"
+)
+
+var (
+	ServiceUUID                   = ble.UUID16(0x1826)
+	TreadmillDataUUID             = ble.UUID16(0x2acd)
+	FeatureUUID                   = ble.UUID16(0x2acc)
+	SupportedSpeedRangeUUID       = ble.UUID16(0x2ad4)
+	SupportedInclinationRangeUUID = ble.UUID16(0x2ad5)
+	ControlPointUUID              = ble.UUID16(0x2ad9)
+	StatusUUID                    = ble.UUID16(0x2ada)
+)
+
+var ErrInvalidPayload = fmt.Errorf("invalid ftms payload")
+
+// TreadmillDataFlags mark which fields are present in a treadmill data payload. Note that the instantaneous speed is
+// present when TreadmillDataMoreData is *not* set.
+type TreadmillDataFlags uint16
+
+const (
+	TreadmillDataMoreData TreadmillDataFlags = 1 << iota
+	TreadmillDataAverageSpeed
+	TreadmillDataTotalDistance
+	TreadmillDataInclination
+	TreadmillDataElevationGain
+	TreadmillDataInstantaneousPace
+	TreadmillDataAveragePace
+	TreadmillDataExpendedEnergy
+	TreadmillDataHeartRate
+	TreadmillDataMetabolicEquivalent
+	TreadmillDataElapsedTime
+	TreadmillDataRemainingTime
+	TreadmillDataForceAndPower
+)
+
+// RampAngleNotAvailable is the ramp angle sent when it isn't known.
+const RampAngleNotAvailable int16 = 0x7fff
+
+// TreadmillData is the Treadmill Data characteristic (0x2ACD). Values are in the units of the spec: speeds are in
+// 0.01 km/h, distances in meters, inclination in 0.1%, ramp angle in 0.1 degrees and elevation in 0.1 meters.
+type TreadmillData struct {
+	Flags                 TreadmillDataFlags
+	Speed                 uint16
+	AverageSpeed          uint16
+	TotalDistance         uint32
+	Inclination           int16
+	RampAngle             int16
+	PositiveElevationGain uint16
+	NegativeElevationGain uint16
+	InstantaneousPace     byte
+	AveragePace           byte
+	TotalEnergy           uint16
+	EnergyPerHour         uint16
+	EnergyPerMinute       byte
+	HeartRate             byte
+	MetabolicEquivalent   byte
+	ElapsedTime           uint16
+	RemainingTime         uint16
+	ForceOnBelt           int16
+	PowerOutput           int16
+}
+
+func (td *TreadmillData) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 2, 34)
+	binary.LittleEndian.PutUint16(buf, uint16(td.Flags))
+
+	if !td.has(TreadmillDataMoreData) {
+		buf = appendUint16(buf, td.Speed)
+	}
+
+	if td.has(TreadmillDataAverageSpeed) {
+		buf = appendUint16(buf, td.AverageSpeed)
+	}
+
+	if td.has(TreadmillDataTotalDistance) {
+		buf = append(buf, byte(td.TotalDistance), byte(td.TotalDistance>>8), byte(td.TotalDistance>>16))
+	}
+
+	if td.has(TreadmillDataInclination) {
+		buf = appendUint16(buf, uint16(td.Inclination))
+		buf = appendUint16(buf, uint16(td.RampAngle))
+	}
+
+	if td.has(TreadmillDataElevationGain) {
+		buf = appendUint16(buf, td.PositiveElevationGain)
+		buf = appendUint16(buf, td.NegativeElevationGain)
+	}
+
+	if td.has(TreadmillDataInstantaneousPace) {
+		buf = append(buf, td.InstantaneousPace)
+	}
+
+	if td.has(TreadmillDataAveragePace) {
+		buf = append(buf, td.AveragePace)
+	}
+
+	if td.has(TreadmillDataExpendedEnergy) {
+		buf = appendUint16(buf, td.TotalEnergy)
+		buf = appendUint16(buf, td.EnergyPerHour)
+		buf = append(buf, td.EnergyPerMinute)
+	}
+
+	if td.has(TreadmillDataHeartRate) {
+		buf = append(buf, td.HeartRate)
+	}
+
+	if td.has(TreadmillDataMetabolicEquivalent) {
+		buf = append(buf, td.MetabolicEquivalent)
+	}
+
+	if td.has(TreadmillDataElapsedTime) {
+		buf = appendUint16(buf, td.ElapsedTime)
+	}
+
+	if td.has(TreadmillDataRemainingTime) {
+		buf = appendUint16(buf, td.RemainingTime)
+	}
+
+	if td.has(TreadmillDataForceAndPower) {
+		buf = appendUint16(buf, uint16(td.ForceOnBelt))
+		buf = appendUint16(buf, uint16(td.PowerOutput))
+	}
+
+	return buf, nil
+}
+
+func (td *TreadmillData) UnmarshalBinary(data []byte) error {
+	r := &payloadReader{data: data}
+	td.Flags = TreadmillDataFlags(r.uint16())
+
+	if !td.has(TreadmillDataMoreData) {
+		td.Speed = r.uint16()
+	}
+
+	if td.has(TreadmillDataAverageSpeed) {
+		td.AverageSpeed = r.uint16()
+	}
+
+	if td.has(TreadmillDataTotalDistance) {
+		td.TotalDistance = r.uint24()
+	}
+
+	if td.has(TreadmillDataInclination) {
+		td.Inclination = int16(r.uint16())
+		td.RampAngle = int16(r.uint16())
+	}
+
+	if td.has(TreadmillDataElevationGain) {
+		td.PositiveElevationGain = r.uint16()
+		td.NegativeElevationGain = r.uint16()
+	}
+
+	if td.has(TreadmillDataInstantaneousPace) {
+		td.InstantaneousPace = r.byte()
+	}
+
+	if td.has(TreadmillDataAveragePace) {
+		td.AveragePace = r.byte()
+	}
+
+	if td.has(TreadmillDataExpendedEnergy) {
+		td.TotalEnergy = r.uint16()
+		td.EnergyPerHour = r.uint16()
+		td.EnergyPerMinute = r.byte()
+	}
+
+	if td.has(TreadmillDataHeartRate) {
+		td.HeartRate = r.byte()
+	}
+
+	if td.has(TreadmillDataMetabolicEquivalent) {
+		td.MetabolicEquivalent = r.byte()
+	}
+
+	if td.has(TreadmillDataElapsedTime) {
+		td.ElapsedTime = r.uint16()
+	}
+
+	if td.has(TreadmillDataRemainingTime) {
+		td.RemainingTime = r.uint16()
+	}
+
+	if td.has(TreadmillDataForceAndPower) {
+		td.ForceOnBelt = int16(r.uint16())
+		td.PowerOutput = int16(r.uint16())
+	}
+
+	return r.err("treadmill data")
+}
+
+func (td *TreadmillData) has(flag TreadmillDataFlags) bool {
+	return td.Flags&flag != 0
+}
+
+// MachineFeatures are the fitness machine features (the first half of the Fitness Machine Feature characteristic).
+type MachineFeatures uint32
+
+const (
+	FeatureAverageSpeed MachineFeatures = 1 << iota
+	FeatureCadence
+	FeatureTotalDistance
+	FeatureInclination
+	FeatureElevationGain
+	FeaturePace
+	FeatureStepCount
+	FeatureResistanceLevel
+	FeatureStrideCount
+	FeatureExpendedEnergy
+	FeatureHeartRateMeasurement
+	FeatureMetabolicEquivalent
+	FeatureElapsedTime
+	FeatureRemainingTime
+	FeaturePowerMeasurement
+	FeatureForceOnBeltAndPowerOutput
+	FeatureUserDataRetention
+)
+
+// TargetSettingFeatures are the target setting features (the second half of the Fitness Machine Feature
+// characteristic), only the ones relevant to treadmills are listed.
+type TargetSettingFeatures uint32
+
+const (
+	TargetSpeed TargetSettingFeatures = 1 << iota
+	TargetInclination
+)
+
+// Feature is the Fitness Machine Feature characteristic (0x2ACC).
+type Feature struct {
+	Machine MachineFeatures
+	Target  TargetSettingFeatures
+}
+
+// FeatureFor returns the features supported by a Sole treadmill.
+func FeatureFor(info *treadonme.MessageDeviceInfo) *Feature {
+	feature := &Feature{
+		Machine: FeatureTotalDistance | FeatureExpendedEnergy | FeatureHeartRateMeasurement | FeatureElapsedTime |
+			FeatureRemainingTime,
+		Target: TargetSpeed,
+	}
+
+	if info.InclineMax > 0 {
+		feature.Machine |= FeatureInclination
+		feature.Target |= TargetInclination
+	}
+
+	return feature
+}
+
+func (f *Feature) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint32(buf, uint32(f.Machine))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(f.Target))
+
+	return buf, nil
+}
+
+func (f *Feature) UnmarshalBinary(data []byte) error {
+	r := &payloadReader{data: data}
+	f.Machine = MachineFeatures(r.uint32())
+	f.Target = TargetSettingFeatures(r.uint32())
+
+	return r.err("feature")
+}
+
+// SpeedRange is the Supported Speed Range characteristic (0x2AD4), in 0.01 km/h.
+type SpeedRange struct {
+	Min       uint16
+	Max       uint16
+	Increment uint16
+}
+
+func SpeedRangeFor(info *treadonme.MessageDeviceInfo) *SpeedRange {
+	return &SpeedRange{
+		Min:       EncodeSpeed(info.MinSpeed, info.Units),
+		Max:       EncodeSpeed(info.MaxSpeed, info.Units),
+		Increment: EncodeSpeed(1, info.Units),
+	}
+}
+
+func (sr *SpeedRange) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 6)
+	buf = appendUint16(buf, sr.Min)
+	buf = appendUint16(buf, sr.Max)
+	buf = appendUint16(buf, sr.Increment)
+
+	return buf, nil
+}
+
+func (sr *SpeedRange) UnmarshalBinary(data []byte) error {
+	r := &payloadReader{data: data}
+	sr.Min = r.uint16()
+	sr.Max = r.uint16()
+	sr.Increment = r.uint16()
+
+	return r.err("speed range")
+}
+
+// InclinationRange is the Supported Inclination Range characteristic (0x2AD5), in 0.1%.
+type InclinationRange struct {
+	Min       int16
+	Max       int16
+	Increment uint16
+}
+
+func InclinationRangeFor(info *treadonme.MessageDeviceInfo) *InclinationRange {
+	return &InclinationRange{
+		Min:       0,
+		Max:       EncodeIncline(info.InclineMax),
+		Increment: uint16(EncodeIncline(1)),
+	}
+}
+
+func (ir *InclinationRange) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 0, 6)
+	buf = appendUint16(buf, uint16(ir.Min))
+	buf = appendUint16(buf, uint16(ir.Max))
+	buf = appendUint16(buf, ir.Increment)
+
+	return buf, nil
+}
+
+func (ir *InclinationRange) UnmarshalBinary(data []byte) error {
+	r := &payloadReader{data: data}
+	ir.Min = int16(r.uint16())
+	ir.Max = int16(r.uint16())
+	ir.Increment = r.uint16()
+
+	return r.err("inclination range")
+}
+
+// StatusOpCode is the first byte of a Fitness Machine Status (0x2ADA) notification.
+type StatusOpCode byte
+
+const (
+	StatusReset                 StatusOpCode = 0x01
+	StatusStoppedOrPaused       StatusOpCode = 0x02
+	StatusStoppedBySafetyKey    StatusOpCode = 0x03
+	StatusStartedOrResumed      StatusOpCode = 0x04
+	StatusTargetSpeedChanged    StatusOpCode = 0x05
+	StatusTargetInclineChanged  StatusOpCode = 0x06
+	StatusControlPermissionLost StatusOpCode = 0xff
+)
+
+const (
+	statusParameterStoppedByUser = 0x01
+	statusParameterPausedByUser  = 0x02
+)
+
+// Status is a Fitness Machine Status notification.
+type Status struct {
+	OpCode     StatusOpCode
+	Parameters []byte
+}
+
+func (s *Status) MarshalBinary() ([]byte, error) {
+	return append([]byte{byte(s.OpCode)}, s.Parameters...), nil
+}
+
+func (s *Status) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 {
+		return fmt.Errorf("%w: empty status", ErrInvalidPayload)
+	}
+
+	s.OpCode = StatusOpCode(data[0])
+	s.Parameters = append([]byte(nil), data[1:]...)
+
+	return nil
+}
+
+func StatusStopped() *Status {
+	return &Status{OpCode: StatusStoppedOrPaused, Parameters: []byte{statusParameterStoppedByUser}}
+}
+
+func StatusPaused() *Status {
+	return &Status{OpCode: StatusStoppedOrPaused, Parameters: []byte{statusParameterPausedByUser}}
+}
+
+func StatusStarted() *Status {
+	return &Status{OpCode: StatusStartedOrResumed}
+}
+
+func StatusTargetSpeed(speed uint16) *Status {
+	return &Status{OpCode: StatusTargetSpeedChanged, Parameters: appendUint16(nil, speed)}
+}
+
+func StatusTargetIncline(incline int16) *Status {
+	return &Status{OpCode: StatusTargetInclineChanged, Parameters: appendUint16(nil, uint16(incline))}
+}
+
+// EncodeSpeed converts a speed in the treadmill's units into 0.01 km/h.
+func EncodeSpeed(speed treadonme.Speed, units treadonme.UnitsType) uint16 {
+	return uint16(math.Round(units.Convert(speed.Float(), treadonme.UnitsTypeMetric) * 100))
+}
+
+// DecodeSpeed converts a speed in 0.01 km/h into the closest speed in the treadmill's units.
+func DecodeSpeed(speed uint16, units treadonme.UnitsType) treadonme.Speed {
+	return treadonme.Speed(math.Round(treadonme.UnitsTypeMetric.Convert(float64(speed)/100, units) * 10))
+}
+
+// EncodeDistance converts a distance in hundredths of the treadmill's units into meters.
+func EncodeDistance(distance uint16, units treadonme.UnitsType) uint32 {
+	return uint32(math.Round(units.Convert(float64(distance)/100, treadonme.UnitsTypeMetric) * 1000))
+}
+
+// EncodeIncline converts the treadmill's incline (in percent) into 0.1%.
+func EncodeIncline(incline byte) int16 {
+	return int16(incline) * 10
+}
+
+// DecodeIncline converts an incline in 0.1% into the closest incline the treadmill supports.
+func DecodeIncline(incline int16) byte {
+	return byte(math.Round(float64(incline) / 10))
+}
+
+func appendUint16(buf []byte, value uint16) []byte {
+	return append(buf, byte(value), byte(value>>8))
+}
+
+// payloadReader reads little endian fields from a payload, reading past the end sets a flag rather than failing every
+// read so the fields can be read in sequence and checked once at the end.
+type payloadReader struct {
+	data      []byte
+	offset    int
+	truncated bool
+}
+
+func (r *payloadReader) next(n int) []byte {
+	if r.offset+n > len(r.data) {
+		r.truncated = true
+		r.offset = len(r.data)
+
+		return make([]byte, n)
+	}
+
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+
+	return b
+}
+
+func (r *payloadReader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *payloadReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.next(2))
+}
+
+func (r *payloadReader) uint24() uint32 {
+	b := r.next(3)
+
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+func (r *payloadReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.next(4))
+}
+
+func (r *payloadReader) err(name string) error {
+	if r.truncated {
+		return fmt.Errorf("%w: truncated %s: %s", ErrInvalidPayload, name, hex.EncodeToString(r.data))
+	}
+
+	return nil
+}
@@ -0,0 +1,106 @@
+package ftms_test
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/ftms"
+)
+
+type EncodeTestSuite struct {
+	suite.Suite
+}
+
+func (s *EncodeTestSuite) marshal(v interface{ MarshalBinary() ([]byte, error) }) string {
+	data, err := v.MarshalBinary()
+	s.Require().NoError(err)
+
+	return hex.EncodeToString(data)
+}
+
+func (s *EncodeTestSuite) TestTreadmillData() {
+	td := ftms.EncodeTreadmillData(&treadonme.MessageWorkoutData{
+		Minute:    29,
+		Second:    30,
+		Distance:  150,
+		Calories:  42,
+		HeartRate: 130,
+		Speed:     60,
+		Incline:   3,
+	}, treadonme.UnitsTypeImperial, 30*time.Second)
+
+	// 6.0 mph is 9.66 km/h and 1.5 miles is 2414 meters.
+	s.Require().Equal(uint16(966), td.Speed)
+	s.Require().Equal(uint32(2414), td.TotalDistance)
+	s.Require().Equal(int16(30), td.Inclination)
+	s.Require().Equal(ftms.RampAngleNotAvailable, td.RampAngle)
+	s.Require().Equal(uint16(1770), td.RemainingTime)
+
+	s.Require().Equal(
+		// flags
+		"8c0d"+
+			// speed, distance, inclination and ramp angle
+			"c603"+"6e0900"+"1e00"+"ff7f"+
+			// energy, heart rate, elapsed and remaining time
+			"2a00"+"ffff"+"ff"+"82"+"1e00"+"ea06",
+		s.marshal(td),
+	)
+}
+
+func (s *EncodeTestSuite) TestTreadmillDataNoHeartRate() {
+	td := ftms.EncodeTreadmillData(&treadonme.MessageWorkoutData{Speed: 100}, treadonme.UnitsTypeMetric, 0)
+	s.Require().Zero(td.Flags & ftms.TreadmillDataHeartRate)
+	s.Require().Equal(uint16(1000), td.Speed)
+}
+
+func (s *EncodeTestSuite) TestMoreData() {
+	td := &ftms.TreadmillData{Flags: ftms.TreadmillDataMoreData | ftms.TreadmillDataElapsedTime, ElapsedTime: 5}
+	s.Require().Equal("01040500", s.marshal(td))
+}
+
+func (s *EncodeTestSuite) TestFeature() {
+	info := &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial, MinSpeed: 5, MaxSpeed: 120, InclineMax: 15}
+
+	s.Require().Equal("0c360000"+"03000000", s.marshal(ftms.FeatureFor(info)))
+	s.Require().Equal("5000"+"8b07"+"1000", s.marshal(ftms.SpeedRangeFor(info)))
+	s.Require().Equal("0000"+"9600"+"0a00", s.marshal(ftms.InclinationRangeFor(info)))
+
+	info.InclineMax = 0
+	s.Require().Equal("04360000"+"01000000", s.marshal(ftms.FeatureFor(info)))
+}
+
+func (s *EncodeTestSuite) TestStatus() {
+	s.Require().Equal("04", s.marshal(ftms.EncodeStatus(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeRunning})))
+	s.Require().Equal("0202", s.marshal(ftms.EncodeStatus(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModePause})))
+	s.Require().Equal("0201", s.marshal(ftms.EncodeStatus(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeDone})))
+	s.Require().Nil(ftms.EncodeStatus(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeIdle}))
+	s.Require().Nil(ftms.EncodeStatus(&treadonme.MessageWorkoutData{}))
+	s.Require().Equal("05c603", s.marshal(ftms.StatusTargetSpeed(966)))
+}
+
+func (s *EncodeTestSuite) TestSpeedConversion() {
+	for speed := treadonme.Speed(5); speed <= 120; speed++ {
+		s.Require().Equal(speed, ftms.DecodeSpeed(ftms.EncodeSpeed(speed, treadonme.UnitsTypeImperial),
+			treadonme.UnitsTypeImperial))
+		s.Require().Equal(speed, ftms.DecodeSpeed(ftms.EncodeSpeed(speed, treadonme.UnitsTypeMetric),
+			treadonme.UnitsTypeMetric))
+	}
+}
+
+func TestEncodeTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &EncodeTestSuite{})
+}
+
+func fromHex(hexStr string) []byte {
+	data, err := hex.DecodeString(hexStr)
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
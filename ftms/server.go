@@ -0,0 +1,221 @@
+package ftms
+
+import (
+	"context"
+	"encoding"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+	"github.com/go-ble/ble/linux"
+	"github.com/swedishborgie/treadonme"
+)
+
+// Server advertises a treadmill as an FTMS treadmill so apps that only speak the standard protocol can use it. The
+// adapter used for the server has to be a different one to the adapter connected to the treadmill.
+//
+// Register Server.HandleMessage as a listener on the treadmill to forward its telemetry.
+type Server struct {
+	name         string
+	deviceID     int
+	equipment    treadonme.Controller
+	controlPoint *ControlPoint
+
+	mutex     sync.Mutex
+	ctx       context.Context
+	start     time.Time
+	data      map[ble.Notifier]struct{}
+	status    map[ble.Notifier]struct{}
+	responses map[ble.Notifier]struct{}
+}
+
+// NewServer creates a server that advertises with the given name on the adapter with the given HCI device ID (1 for
+// hci1 for instance).
+func NewServer(name string, deviceID int, equipment treadonme.Controller) *Server {
+	return &Server{
+		name:         name,
+		deviceID:     deviceID,
+		equipment:    equipment,
+		controlPoint: NewControlPoint(equipment),
+		ctx:          context.Background(),
+		data:         map[ble.Notifier]struct{}{},
+		status:       map[ble.Notifier]struct{}{},
+		responses:    map[ble.Notifier]struct{}{},
+	}
+}
+
+// Serve advertises the fitness machine service until the context is cancelled.
+func (s *Server) Serve(ctx context.Context) error {
+	dev, err := linux.NewDevice(ble.OptDeviceID(s.deviceID))
+	if err != nil {
+		return fmt.Errorf("problem creating ble device handle for hci%d: %w", s.deviceID, err)
+	}
+
+	defer func() {
+		if err := dev.Stop(); err != nil {
+			log.Printf("problem stopping ftms server device: %s", err)
+		}
+	}()
+
+	s.mutex.Lock()
+	s.ctx = ctx
+	s.mutex.Unlock()
+
+	if err := dev.AddService(s.service()); err != nil {
+		return fmt.Errorf("problem adding fitness machine service: %w", err)
+	}
+
+	if err := dev.AdvertiseNameAndServices(ctx, s.name, ServiceUUID); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("problem advertising fitness machine service: %w", err)
+	}
+
+	return ctx.Err()
+}
+
+// HandleMessage forwards the treadmill's telemetry and status changes to subscribed clients.
+func (s *Server) HandleMessage(msg treadonme.Message, err error) {
+	if err != nil {
+		return
+	}
+
+	if status := EncodeStatus(msg); status != nil {
+		s.notify(s.status, status)
+	}
+
+	switch v := msg.(type) {
+	case *treadonme.MessageWorkoutMode:
+		if v.Mode == treadonme.WorkoutModeDone {
+			s.mutex.Lock()
+			s.start = time.Time{}
+			s.mutex.Unlock()
+		}
+	case *treadonme.MessageWorkoutData:
+		info := s.equipment.DeviceInfo()
+		if info == nil {
+			return
+		}
+
+		s.mutex.Lock()
+		now := time.Now()
+		if s.start.IsZero() {
+			s.start = now
+		}
+		elapsed := now.Sub(s.start)
+		s.mutex.Unlock()
+
+		s.notify(s.data, EncodeTreadmillData(v, info.Units, elapsed))
+	}
+}
+
+func (s *Server) service() *ble.Service {
+	svc := ble.NewService(ServiceUUID)
+
+	svc.NewCharacteristic(FeatureUUID).HandleRead(s.read(
+		func(info *treadonme.MessageDeviceInfo) encoding.BinaryMarshaler {
+			return FeatureFor(info)
+		}))
+	svc.NewCharacteristic(SupportedSpeedRangeUUID).HandleRead(s.read(
+		func(info *treadonme.MessageDeviceInfo) encoding.BinaryMarshaler {
+			return SpeedRangeFor(info)
+		}))
+	svc.NewCharacteristic(SupportedInclinationRangeUUID).HandleRead(s.read(
+		func(info *treadonme.MessageDeviceInfo) encoding.BinaryMarshaler {
+			return InclinationRangeFor(info)
+		}))
+	svc.NewCharacteristic(TreadmillDataUUID).HandleNotify(s.subscribe(s.data, nil))
+	svc.NewCharacteristic(StatusUUID).HandleNotify(s.subscribe(s.status, nil))
+
+	cp := svc.NewCharacteristic(ControlPointUUID)
+	cp.HandleWrite(ble.WriteHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		// The write has to be acknowledged before the response is indicated, and setting a target can take a while.
+		data := append([]byte(nil), req.Data()...)
+		go s.control(data)
+	}))
+	cp.HandleIndicate(s.subscribe(s.responses, s.controlPoint.Release))
+
+	return svc
+}
+
+func (s *Server) read(fn func(*treadonme.MessageDeviceInfo) encoding.BinaryMarshaler) ble.ReadHandler {
+	return ble.ReadHandlerFunc(func(req ble.Request, rsp ble.ResponseWriter) {
+		info := s.equipment.DeviceInfo()
+		if info == nil {
+			rsp.SetStatus(ble.ErrUnlikely)
+
+			return
+		}
+
+		data, err := fn(info).MarshalBinary()
+		if err != nil {
+			rsp.SetStatus(ble.ErrUnlikely)
+
+			return
+		}
+
+		if _, err := rsp.Write(data); err != nil {
+			log.Printf("problem writing ftms read response: %s", err)
+		}
+	})
+}
+
+// subscribe keeps track of a subscribed client until it unsubscribes or disconnects.
+func (s *Server) subscribe(notifiers map[ble.Notifier]struct{}, done func()) ble.NotifyHandler {
+	return ble.NotifyHandlerFunc(func(req ble.Request, n ble.Notifier) {
+		s.mutex.Lock()
+		notifiers[n] = struct{}{}
+		s.mutex.Unlock()
+
+		<-n.Context().Done()
+
+		s.mutex.Lock()
+		delete(notifiers, n)
+		s.mutex.Unlock()
+
+		if done != nil {
+			done()
+		}
+	})
+}
+
+func (s *Server) control(req []byte) {
+	s.mutex.Lock()
+	ctx := s.ctx
+	s.mutex.Unlock()
+
+	rsp, status := s.controlPoint.Handle(ctx, req)
+	if rsp == nil {
+		return
+	}
+
+	s.notify(s.responses, rawPayload(rsp))
+
+	if status != nil {
+		s.notify(s.status, status)
+	}
+}
+
+func (s *Server) notify(notifiers map[ble.Notifier]struct{}, payload encoding.BinaryMarshaler) {
+	data, err := payload.MarshalBinary()
+	if err != nil {
+		log.Printf("problem encoding ftms payload: %s", err)
+
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for n := range notifiers {
+		if _, err := n.Write(data); err != nil {
+			log.Printf("problem notifying ftms client: %s", err)
+		}
+	}
+}
+
+type rawPayload []byte
+
+func (rp rawPayload) MarshalBinary() ([]byte, error) {
+	return rp, nil
+}
@@ -7,5 +7,5 @@ require (
 	github.com/gorilla/websocket v1.5.0
 	github.com/stretchr/testify v1.7.0
 	github.com/urfave/cli/v2 v2.7.1
-	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b // indirect
+	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 )
@@ -0,0 +1,278 @@
+package treadonme
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+var (
+	heartRateServiceUUID     = ble.UUID16(0x180d)
+	heartRateMeasurementUUID = ble.UUID16(0x2a37)
+)
+
+var ErrInvalidHeartRateMeasurement = fmt.Errorf("invalid heart rate measurement")
+
+type HeartRateSource byte
+
+const (
+	HeartRateSourceTreadmill HeartRateSource = iota
+	HeartRateSourceExternal
+)
+
+func (hrs HeartRateSource) String() string {
+	switch hrs {
+	case HeartRateSourceTreadmill:
+		return "Treadmill"
+	case HeartRateSourceExternal:
+		return "External"
+	default:
+		return "Unknown"
+	}
+}
+
+type SensorContact byte
+
+const (
+	SensorContactUnsupported SensorContact = iota
+	SensorContactNotDetected
+	SensorContactDetected
+)
+
+func (sc SensorContact) String() string {
+	switch sc {
+	case SensorContactUnsupported:
+		return "Unsupported"
+	case SensorContactNotDetected:
+		return "NotDetected"
+	case SensorContactDetected:
+		return "Detected"
+	default:
+		return "Unknown"
+	}
+}
+
+const (
+	heartRateFlagUInt16         = 0x01
+	heartRateFlagContactStatus  = 0x02
+	heartRateFlagContactSupport = 0x04
+	heartRateFlagEnergyExpended = 0x08
+	heartRateFlagRRInterval     = 0x10
+)
+
+// HeartRateMeasurement is a reading from a standard Bluetooth heart rate monitor (the Heart Rate Measurement
+// characteristic, 0x2A37).
+type HeartRateMeasurement struct {
+	HeartRate     uint16
+	SensorContact SensorContact
+	// EnergyExpended is the accumulated energy expended in kilojoules, nil if the monitor didn't include it.
+	EnergyExpended *uint16
+	// RRIntervals are the times between beats since the last measurement.
+	RRIntervals []time.Duration
+}
+
+func ParseHeartRateMeasurement(data []byte) (*HeartRateMeasurement, error) {
+	if len(data) < 2 {
+		return nil, fmt.Errorf("%w: expected at least 2 bytes, got %d: %s",
+			ErrInvalidHeartRateMeasurement, len(data), hex.EncodeToString(data))
+	}
+
+	flags := data[0]
+	data = data[1:]
+	m := &HeartRateMeasurement{}
+
+	if flags&heartRateFlagUInt16 != 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("%w: truncated heart rate", ErrInvalidHeartRateMeasurement)
+		}
+
+		m.HeartRate = uint16(data[0]) + uint16(data[1])<<8
+		data = data[2:]
+	} else {
+		m.HeartRate = uint16(data[0])
+		data = data[1:]
+	}
+
+	if flags&heartRateFlagContactSupport != 0 {
+		m.SensorContact = SensorContactNotDetected
+		if flags&heartRateFlagContactStatus != 0 {
+			m.SensorContact = SensorContactDetected
+		}
+	}
+
+	if flags&heartRateFlagEnergyExpended != 0 {
+		if len(data) < 2 {
+			return nil, fmt.Errorf("%w: truncated energy expended", ErrInvalidHeartRateMeasurement)
+		}
+
+		energy := uint16(data[0]) + uint16(data[1])<<8
+		m.EnergyExpended = &energy
+		data = data[2:]
+	}
+
+	if flags&heartRateFlagRRInterval != 0 {
+		if len(data)%2 != 0 {
+			return nil, fmt.Errorf("%w: truncated rr interval", ErrInvalidHeartRateMeasurement)
+		}
+
+		for ; len(data) >= 2; data = data[2:] {
+			// RR intervals have a resolution of 1/1024 of a second.
+			rr := uint16(data[0]) + uint16(data[1])<<8
+			m.RRIntervals = append(m.RRIntervals, time.Duration(rr)*time.Second/1024)
+		}
+	}
+
+	return m, nil
+}
+
+func (m *HeartRateMeasurement) String() string {
+	return fmt.Sprintf("HeartRateMeasurement[HeartRate=%d,SensorContact=%s,RRIntervals=%v]",
+		m.HeartRate, m.SensorContact, m.RRIntervals)
+}
+
+type HeartRateListener func(*HeartRateMeasurement, error)
+
+// HeartRateMonitor is a standard Bluetooth LE heart rate monitor (like a chest strap), it can be connected at the same
+// time as the treadmill.
+type HeartRateMonitor struct {
+	addr      ble.Addr
+	client    ble.Client
+	bleDevice ble.Device
+
+	mutex     sync.Mutex
+	listeners []HeartRateListener
+}
+
+func NewHeartRateMonitor(addr string) *HeartRateMonitor {
+	return &HeartRateMonitor{addr: ble.NewAddr(addr)}
+}
+
+func (h *HeartRateMonitor) AddListener(listener HeartRateListener) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.listeners = append(h.listeners, listener)
+}
+
+func (h *HeartRateMonitor) Connect(ctx context.Context) error {
+	if h.bleDevice == nil {
+		bleDevice, err := AcquireDevice()
+		if err != nil {
+			return err
+		}
+
+		h.bleDevice = bleDevice
+	}
+
+	cleanUp := func() {
+		if err := h.Close(); err != nil {
+			log.Printf("failed to clean up after failed heart rate monitor connect: %s", err)
+		}
+	}
+
+	var (
+		mutex sync.Mutex
+		found bool
+	)
+
+	toContext, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	dev, err := ble.Connect(toContext, func(a ble.Advertisement) bool {
+		// Same as the treadmill, this can fire concurrently so only return true once.
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !found && a.Addr().String() == h.addr.String() {
+			found = true
+
+			return true
+		}
+
+		return false
+	})
+	if err != nil {
+		defer cleanUp()
+
+		return fmt.Errorf("problem connecting to heart rate monitor: %w", err)
+	}
+
+	h.client = dev
+
+	svcs, err := dev.DiscoverServices([]ble.UUID{heartRateServiceUUID})
+	if err != nil {
+		defer cleanUp()
+
+		return fmt.Errorf("failed to discover services on heart rate monitor: %w", err)
+	} else if len(svcs) == 0 {
+		defer cleanUp()
+
+		return fmt.Errorf("%w: %s", ErrMissingService, heartRateServiceUUID.String())
+	}
+
+	chrs, err := dev.DiscoverCharacteristics([]ble.UUID{heartRateMeasurementUUID}, svcs[0])
+	if err != nil {
+		defer cleanUp()
+
+		return fmt.Errorf("failed to discover characteristics on heart rate monitor: %w", err)
+	} else if len(chrs) == 0 {
+		defer cleanUp()
+
+		return fmt.Errorf("%w: %s", ErrMissingCharacteristic, heartRateMeasurementUUID.String())
+	}
+
+	if _, err := dev.DiscoverDescriptors(nil, chrs[0]); err != nil {
+		defer cleanUp()
+
+		return fmt.Errorf("failed to discover descriptors on heart rate monitor: %w", err)
+	}
+
+	if err := dev.Subscribe(chrs[0], false, h.recv); err != nil {
+		defer cleanUp()
+
+		return fmt.Errorf("failed to subscribe to heart rate measurements: %w", err)
+	}
+
+	return nil
+}
+
+func (h *HeartRateMonitor) Close() error {
+	if h.client != nil {
+		if err := h.client.ClearSubscriptions(); err != nil {
+			return fmt.Errorf("failed to clear heart rate monitor subscriptions: %w", err)
+		}
+
+		if err := h.client.CancelConnection(); err != nil {
+			return err
+		}
+
+		h.client = nil
+	}
+
+	if h.bleDevice != nil {
+		h.bleDevice = nil
+
+		if err := ReleaseDevice(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *HeartRateMonitor) recv(data []byte) {
+	m, err := ParseHeartRateMeasurement(data)
+
+	h.mutex.Lock()
+	listeners := h.listeners
+	h.mutex.Unlock()
+
+	for _, l := range listeners {
+		l(m, err)
+	}
+}
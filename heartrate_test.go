@@ -0,0 +1,97 @@
+package treadonme_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+type HeartRateTestSuite struct {
+	suite.Suite
+}
+
+func (s *HeartRateTestSuite) TestUInt8() {
+	m, err := treadonme.ParseHeartRateMeasurement(fromHex("0048"))
+	s.Require().NoError(err)
+	s.Require().Equal(uint16(72), m.HeartRate)
+	s.Require().Equal(treadonme.SensorContactUnsupported, m.SensorContact)
+	s.Require().Nil(m.EnergyExpended)
+	s.Require().Empty(m.RRIntervals)
+}
+
+func (s *HeartRateTestSuite) TestUInt16WithContact() {
+	m, err := treadonme.ParseHeartRateMeasurement(fromHex("074a01"))
+	s.Require().NoError(err)
+	s.Require().Equal(uint16(330), m.HeartRate)
+	s.Require().Equal(treadonme.SensorContactDetected, m.SensorContact)
+
+	m, err = treadonme.ParseHeartRateMeasurement(fromHex("0450"))
+	s.Require().NoError(err)
+	s.Require().Equal(uint16(80), m.HeartRate)
+	s.Require().Equal(treadonme.SensorContactNotDetected, m.SensorContact)
+}
+
+func (s *HeartRateTestSuite) TestEnergyAndRRIntervals() {
+	// 8 bit heart rate of 100, contact detected, 500 kJ expended and two RR intervals of 1024 and 512 (1s and 0.5s).
+	m, err := treadonme.ParseHeartRateMeasurement(fromHex("1e64f40100040002"))
+	s.Require().NoError(err)
+	s.Require().Equal(uint16(100), m.HeartRate)
+	s.Require().Equal(treadonme.SensorContactDetected, m.SensorContact)
+	s.Require().NotNil(m.EnergyExpended)
+	s.Require().Equal(uint16(500), *m.EnergyExpended)
+	s.Require().Equal([]time.Duration{time.Second, 500 * time.Millisecond}, m.RRIntervals)
+}
+
+func (s *HeartRateTestSuite) TestRRIntervalsOnly() {
+	m, err := treadonme.ParseHeartRateMeasurement(fromHex("103c3003"))
+	s.Require().NoError(err)
+	s.Require().Equal(uint16(60), m.HeartRate)
+	s.Require().Equal([]time.Duration{time.Duration(816) * time.Second / 1024}, m.RRIntervals)
+}
+
+func (s *HeartRateTestSuite) TestInvalid() {
+	for _, payload := range []string{"", "00", "0148", "0848f4", "104800"} {
+		_, err := treadonme.ParseHeartRateMeasurement(fromHex(payload))
+		s.Require().ErrorIs(err, treadonme.ErrInvalidHeartRateMeasurement, payload)
+	}
+}
+
+func (s *HeartRateTestSuite) TestSessionPrefersExternal() {
+	session := treadonme.NewSession(&treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial})
+
+	session.HandleMessage(&treadonme.MessageWorkoutData{HeartRate: 90}, nil)
+	session.HandleHeartRate(&treadonme.HeartRateMeasurement{
+		HeartRate:     130,
+		SensorContact: treadonme.SensorContactDetected,
+		RRIntervals:   []time.Duration{460 * time.Millisecond},
+	}, nil)
+	session.HandleMessage(&treadonme.MessageWorkoutData{HeartRate: 95}, nil)
+	// Readings without skin contact are ignored.
+	session.HandleHeartRate(&treadonme.HeartRateMeasurement{
+		HeartRate:     40,
+		SensorContact: treadonme.SensorContactNotDetected,
+	}, nil)
+	session.HandleMessage(&treadonme.MessageWorkoutData{HeartRate: 96}, nil)
+
+	samples := session.Samples()
+	s.Require().Len(samples, 3)
+
+	s.Require().Equal(byte(90), samples[0].HeartRate)
+	s.Require().Equal(treadonme.HeartRateSourceTreadmill, samples[0].HeartRateSource)
+
+	s.Require().Equal(byte(130), samples[1].HeartRate)
+	s.Require().Equal(treadonme.HeartRateSourceExternal, samples[1].HeartRateSource)
+	s.Require().Equal([]time.Duration{460 * time.Millisecond}, samples[1].RRIntervals)
+
+	// The RR intervals are only attached to the first sample after they arrive.
+	s.Require().Equal(byte(130), samples[2].HeartRate)
+	s.Require().Empty(samples[2].RRIntervals)
+}
+
+func TestHeartRateTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &HeartRateTestSuite{})
+}
@@ -0,0 +1,266 @@
+// Package history persists recorded workouts to disk so they survive restarts.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+var (
+	ErrNotFound  = fmt.Errorf("workout not found")
+	ErrInvalidID = fmt.Errorf("invalid workout id")
+)
+
+const (
+	workoutFile = "workout.json"
+	samplesFile = "samples.jsonl"
+)
+
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Workout is a recorded workout. Samples are only populated when fetching a single workout.
+type Workout struct {
+	ID      string
+	User    string
+	Model   treadonme.DeviceModel
+	Units   treadonme.UnitsType
+	Start   time.Time
+	End     time.Time
+	Summary *treadonme.MessageEndWorkout
+	// Gaps are where the telemetry stalled during the workout.
+	Gaps []treadonme.TelemetryGap `json:",omitempty"`
+	// Errors are the error codes the treadmill reported during the workout.
+	Errors  []treadonme.ErrorEvent `json:",omitempty"`
+	Samples []treadonme.Sample     `json:"-"`
+}
+
+func FromSession(session *treadonme.Session) *Workout {
+	return &Workout{
+		ID:      session.ID,
+		User:    session.User,
+		Model:   session.Model,
+		Units:   session.Units,
+		Start:   session.StartTime(),
+		End:     session.EndTime(),
+		Summary: session.WorkoutSummary(),
+		Gaps:    session.Gaps(),
+		Errors:  session.Errors(),
+		Samples: session.Samples(),
+	}
+}
+
+// Query filters the workouts returned by List, zero values match everything. From is inclusive and To is exclusive.
+type Query struct {
+	From time.Time
+	To   time.Time
+	User string
+}
+
+func (q Query) matches(w *Workout) bool {
+	switch {
+	case !q.From.IsZero() && w.Start.Before(q.From):
+		return false
+	case !q.To.IsZero() && !w.Start.Before(q.To):
+		return false
+	case q.User != "" && q.User != w.User:
+		return false
+	default:
+		return true
+	}
+}
+
+// Store keeps every workout in its own directory under the data directory, the metadata and summary in workout.json
+// and the samples in samples.jsonl.
+type Store struct {
+	dir   string
+	mutex sync.RWMutex
+}
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("problem creating history directory: %w", err)
+	}
+
+	return &Store{dir: dir}, nil
+}
+
+func (s *Store) Save(w *Workout) error {
+	if !validID.MatchString(w.ID) {
+		return fmt.Errorf("%w: %q", ErrInvalidID, w.ID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := filepath.Join(s.dir, w.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("problem creating workout directory: %w", err)
+	}
+
+	if err := writeFileAtomic(filepath.Join(dir, samplesFile), func(f *os.File) error {
+		buf := bufio.NewWriter(f)
+		enc := json.NewEncoder(buf)
+
+		for _, sample := range w.Samples {
+			if err := enc.Encode(sample); err != nil {
+				return err
+			}
+		}
+
+		return buf.Flush()
+	}); err != nil {
+		return fmt.Errorf("problem writing workout samples: %w", err)
+	}
+
+	// The metadata is written last, a workout without it is considered incomplete and is ignored.
+	if err := writeFileAtomic(filepath.Join(dir, workoutFile), func(f *os.File) error {
+		return json.NewEncoder(f).Encode(w)
+	}); err != nil {
+		return fmt.Errorf("problem writing workout: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) SaveSession(session *treadonme.Session) error {
+	return s.Save(FromSession(session))
+}
+
+// List returns the workouts matching the query ordered newest first, without their samples.
+func (s *Store) List(q Query) ([]*Workout, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading history directory: %w", err)
+	}
+
+	workouts := make([]*Workout, 0, len(entries))
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !validID.MatchString(entry.Name()) {
+			continue
+		}
+
+		w, err := s.readWorkout(entry.Name())
+		if errors.Is(err, ErrNotFound) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if q.matches(w) {
+			workouts = append(workouts, w)
+		}
+	}
+
+	sort.Slice(workouts, func(i, j int) bool {
+		return workouts[i].Start.After(workouts[j].Start)
+	})
+
+	return workouts, nil
+}
+
+func (s *Store) Get(id string) (*Workout, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	w, err := s.readWorkout(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, id, samplesFile))
+	if err != nil {
+		return nil, fmt.Errorf("problem opening workout samples: %w", err)
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+
+	for dec.More() {
+		var sample treadonme.Sample
+		if err := dec.Decode(&sample); err != nil {
+			return nil, fmt.Errorf("problem reading workout samples: %w", err)
+		}
+
+		w.Samples = append(w.Samples, sample)
+	}
+
+	return w, nil
+}
+
+func (s *Store) Delete(id string) error {
+	if !validID.MatchString(id) {
+		return fmt.Errorf("%w: %q", ErrInvalidID, id)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	dir := filepath.Join(s.dir, id)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNotFound, id)
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("problem deleting workout: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) readWorkout(id string) (*Workout, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, id, workoutFile))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, id)
+	} else if err != nil {
+		return nil, fmt.Errorf("problem reading workout: %w", err)
+	}
+
+	w := &Workout{}
+	if err := json.Unmarshal(data, w); err != nil {
+		return nil, fmt.Errorf("problem decoding workout %s: %w", id, err)
+	}
+
+	return w, nil
+}
+
+// writeFileAtomic writes to a temporary file and renames it into place so a crash never leaves a partial file.
+func writeFileAtomic(path string, write func(*os.File) error) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
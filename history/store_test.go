@@ -0,0 +1,131 @@
+package history_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/history"
+)
+
+type StoreTestSuite struct {
+	suite.Suite
+
+	store *history.Store
+}
+
+func (s *StoreTestSuite) SetupTest() {
+	store, err := history.Open(s.T().TempDir())
+	s.Require().NoError(err)
+
+	s.store = store
+}
+
+func (s *StoreTestSuite) workout(id, user string, start time.Time) *history.Workout {
+	return &history.Workout{
+		ID:      id,
+		User:    user,
+		Model:   treadonme.DeviceModelF80,
+		Units:   treadonme.UnitsTypeImperial,
+		Start:   start,
+		End:     start.Add(30 * time.Minute),
+		Summary: &treadonme.MessageEndWorkout{Seconds: 1800, Distance: 250},
+		Gaps:    []treadonme.TelemetryGap{{Start: start.Add(time.Minute), End: start.Add(2 * time.Minute)}},
+		Errors:  []treadonme.ErrorEvent{treadonme.NewErrorEvent(treadonme.ErrorCodeSafetyKey, start.Add(time.Minute))},
+		Samples: []treadonme.Sample{
+			{Timestamp: start, Speed: 30, Mode: treadonme.WorkoutModeRunning},
+			{Timestamp: start.Add(time.Second), Elapsed: time.Second, Speed: 31, Mode: treadonme.WorkoutModeRunning},
+		},
+	}
+}
+
+func (s *StoreTestSuite) TestSaveAndGet() {
+	start := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.Require().NoError(s.store.Save(s.workout("abc", "alex", start)))
+
+	w, err := s.store.Get("abc")
+	s.Require().NoError(err)
+	s.Require().Equal("alex", w.User)
+	s.Require().True(start.Equal(w.Start))
+	s.Require().Equal(uint16(250), w.Summary.Distance)
+	s.Require().Len(w.Gaps, 1)
+	s.Require().Len(w.Errors, 1)
+	s.Require().Equal(treadonme.ErrorCodeSafetyKey, w.Errors[0].Code)
+	s.Require().True(start.Add(2 * time.Minute).Equal(w.Gaps[0].End))
+	s.Require().Len(w.Samples, 2)
+	s.Require().Equal(treadonme.Speed(31), w.Samples[1].Speed)
+	s.Require().Equal(time.Second, w.Samples[1].Elapsed)
+}
+
+func (s *StoreTestSuite) TestFromRecordingSession() {
+	session := treadonme.NewSession(&treadonme.MessageDeviceInfo{Model: treadonme.DeviceModelF80})
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		for idx := 0; idx < 100; idx++ {
+			session.HandleMessage(&treadonme.MessageWorkoutData{Speed: 30}, nil)
+		}
+
+		session.HandleMessage(&treadonme.MessageEndWorkout{Seconds: 100}, nil)
+	}()
+
+	// Taking a copy while the session is still recording is safe, the race detector checks it.
+	for idx := 0; idx < 100; idx++ {
+		history.FromSession(session)
+	}
+
+	<-done
+
+	w := history.FromSession(session)
+	s.Require().False(w.Start.IsZero())
+	s.Require().False(w.End.IsZero())
+	s.Require().Equal(uint16(100), w.Summary.Seconds)
+	s.Require().Len(w.Samples, 100)
+}
+
+func (s *StoreTestSuite) TestList() {
+	day := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	s.Require().NoError(s.store.Save(s.workout("one", "alex", day)))
+	s.Require().NoError(s.store.Save(s.workout("two", "sam", day.Add(24*time.Hour))))
+	s.Require().NoError(s.store.Save(s.workout("three", "alex", day.Add(48*time.Hour))))
+
+	all, err := s.store.List(history.Query{})
+	s.Require().NoError(err)
+	s.Require().Len(all, 3)
+	s.Require().Equal("three", all[0].ID)
+	s.Require().Nil(all[0].Samples)
+
+	alex, err := s.store.List(history.Query{User: "alex"})
+	s.Require().NoError(err)
+	s.Require().Len(alex, 2)
+
+	ranged, err := s.store.List(history.Query{From: day.Add(time.Hour), To: day.Add(48 * time.Hour)})
+	s.Require().NoError(err)
+	s.Require().Len(ranged, 1)
+	s.Require().Equal("two", ranged[0].ID)
+}
+
+func (s *StoreTestSuite) TestDelete() {
+	s.Require().NoError(s.store.Save(s.workout("abc", "", time.Now())))
+	s.Require().NoError(s.store.Delete("abc"))
+
+	_, err := s.store.Get("abc")
+	s.Require().ErrorIs(err, history.ErrNotFound)
+	s.Require().ErrorIs(s.store.Delete("abc"), history.ErrNotFound)
+}
+
+func (s *StoreTestSuite) TestInvalidID() {
+	_, err := s.store.Get("../etc")
+	s.Require().ErrorIs(err, history.ErrInvalidID)
+	s.Require().ErrorIs(s.store.Save(s.workout("a/b", "", time.Now())), history.ErrInvalidID)
+}
+
+func TestStoreTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &StoreTestSuite{})
+}
@@ -0,0 +1,354 @@
+package treadonme
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrInvalidHeartRateConfig = fmt.Errorf("invalid heart rate control config")
+
+type HeartRateAdjust byte
+
+const (
+	HeartRateAdjustSpeed HeartRateAdjust = iota
+	HeartRateAdjustIncline
+	HeartRateAdjustBoth
+)
+
+type HeartRateAction byte
+
+const (
+	HeartRateActionHold HeartRateAction = iota
+	HeartRateActionIncrease
+	HeartRateActionDecrease
+	HeartRateActionSignalLost
+)
+
+func (hra HeartRateAction) String() string {
+	switch hra {
+	case HeartRateActionHold:
+		return "Hold"
+	case HeartRateActionIncrease:
+		return "Increase"
+	case HeartRateActionDecrease:
+		return "Decrease"
+	case HeartRateActionSignalLost:
+		return "SignalLost"
+	default:
+		return "Unknown"
+	}
+}
+
+// HeartRateControlConfig configures a HeartRateController. Zero values are replaced with defaults, apart from the zone
+// and MaxSpeed which are required.
+type HeartRateControlConfig struct {
+	// ZoneMin and ZoneMax is the heart rate range (in bpm) to hold.
+	ZoneMin byte
+	ZoneMax byte
+	// Hysteresis is how far (in bpm) the heart rate has to leave the zone before anything is adjusted.
+	Hysteresis byte
+	Adjust     HeartRateAdjust
+	// MinSpeed and MaxSpeed cap the speeds the controller will set, MinSpeed is raised to the treadmill's minimum.
+	MinSpeed Speed
+	MaxSpeed Speed
+	// MaxIncline caps the incline the controller will set, zero uses the treadmill's maximum. It's required when adjusting
+	// the incline of a treadmill that doesn't report its maximum.
+	MaxIncline byte
+	// SafeSpeed is the speed to drop to (if going faster) when the heart rate signal is lost, it defaults to MinSpeed.
+	SafeSpeed Speed
+	// SpeedStep and InclineStep are how much to change the speed and incline by in one adjustment.
+	SpeedStep   Speed
+	InclineStep byte
+	// AdjustInterval is the minimum amount of time between adjustments, heart rate takes a while to respond.
+	AdjustInterval time.Duration
+	// SignalTimeout is how long without a heart rate reading before the signal is considered lost.
+	SignalTimeout time.Duration
+}
+
+func (c *HeartRateControlConfig) setDefaults() {
+	if c.Hysteresis == 0 {
+		c.Hysteresis = 3
+	}
+
+	if c.SafeSpeed == 0 {
+		c.SafeSpeed = c.MinSpeed
+	}
+
+	if c.SpeedStep == 0 {
+		c.SpeedStep = 2
+	}
+
+	if c.InclineStep == 0 {
+		c.InclineStep = 1
+	}
+
+	if c.AdjustInterval == 0 {
+		c.AdjustInterval = 20 * time.Second
+	}
+
+	if c.SignalTimeout == 0 {
+		c.SignalTimeout = 10 * time.Second
+	}
+}
+
+func (c *HeartRateControlConfig) validate() error {
+	switch {
+	case c.ZoneMin == 0 || c.ZoneMax < c.ZoneMin:
+		return fmt.Errorf("%w: zone %d-%d", ErrInvalidHeartRateConfig, c.ZoneMin, c.ZoneMax)
+	case c.MaxSpeed == 0 || c.MaxSpeed < c.MinSpeed:
+		return fmt.Errorf("%w: speed %d-%d", ErrInvalidHeartRateConfig, c.MinSpeed, c.MaxSpeed)
+	case c.SafeSpeed == 0:
+		return fmt.Errorf("%w: no safe speed, the treadmill's minimum speed isn't known", ErrInvalidHeartRateConfig)
+	case c.SafeSpeed < c.MinSpeed || c.SafeSpeed > c.MaxSpeed:
+		return fmt.Errorf("%w: safe speed %d outside of %d-%d", ErrInvalidHeartRateConfig, c.SafeSpeed, c.MinSpeed,
+			c.MaxSpeed)
+	case c.Adjust != HeartRateAdjustSpeed && c.MaxIncline == 0:
+		return fmt.Errorf("%w: adjusting the incline without a max incline", ErrInvalidHeartRateConfig)
+	default:
+		return nil
+	}
+}
+
+// HeartRateControlEvent is emitted every time the controller makes a decision.
+type HeartRateControlEvent struct {
+	Time      time.Time
+	Action    HeartRateAction
+	HeartRate byte
+	Speed     Speed
+	Incline   byte
+}
+
+type HeartRateControlListener func(HeartRateControlEvent)
+
+// HeartRateController adjusts the speed and/or incline of a treadmill to keep the runner's heart rate within a zone.
+// Heart rate readings can come from the treadmill itself (register HandleMessage as a listener) or from anywhere else
+// through UpdateHeartRate.
+//
+// When the heart rate is above the zone the incline is reduced first (when adjusting incline), when it's below the
+// zone the speed is increased first. Adjustments are never made more often than AdjustInterval.
+type HeartRateController struct {
+	// TickInterval is how often Run evaluates the heart rate.
+	TickInterval time.Duration
+
+	equipment Controller
+	config    HeartRateControlConfig
+	clock     Clock
+
+	mutex      sync.Mutex
+	heartRate  byte
+	lastReport time.Time
+	lastAdjust time.Time
+	listeners  []HeartRateControlListener
+}
+
+func NewHeartRateController(equipment Controller, config HeartRateControlConfig) (*HeartRateController, error) {
+	// The treadmill's limits are applied first so the safe speed defaults to a speed the treadmill can run at.
+	if info := equipment.DeviceInfo(); info != nil {
+		if config.MinSpeed < info.MinSpeed {
+			config.MinSpeed = info.MinSpeed
+		}
+
+		if config.MaxSpeed > info.MaxSpeed {
+			config.MaxSpeed = info.MaxSpeed
+		}
+
+		if config.MaxIncline == 0 || config.MaxIncline > info.InclineMax {
+			config.MaxIncline = info.InclineMax
+		}
+	}
+
+	config.setDefaults()
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return &HeartRateController{
+		TickInterval: time.Second,
+		equipment:    equipment,
+		config:       config,
+		clock:        clockOf(equipment),
+	}, nil
+}
+
+// SetClock replaces the clock heart rates are timed and Run ticks with, it defaults to the treadmill's and should be
+// called before running.
+func (hc *HeartRateController) SetClock(clock Clock) {
+	hc.clock = clock
+}
+
+func (hc *HeartRateController) AddListener(listener HeartRateControlListener) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	hc.listeners = append(hc.listeners, listener)
+}
+
+// UpdateHeartRate records a heart rate reading, a reading of zero means there's no signal.
+func (hc *HeartRateController) UpdateHeartRate(heartRate byte, at time.Time) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	hc.heartRate = heartRate
+	if heartRate != 0 {
+		hc.lastReport = at
+	}
+}
+
+// HandleMessage records the heart rate reported by the treadmill.
+func (hc *HeartRateController) HandleMessage(msg Message, err error) {
+	if err != nil {
+		return
+	}
+
+	switch v := msg.(type) {
+	case *MessageHeartRate:
+		hc.UpdateHeartRate(v.HeartRate, hc.clock.Now())
+	case *MessageWorkoutData:
+		hc.UpdateHeartRate(v.HeartRate, hc.clock.Now())
+	}
+}
+
+// Run evaluates the heart rate every TickInterval until the context is cancelled or an adjustment fails.
+func (hc *HeartRateController) Run(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-hc.clock.After(hc.TickInterval):
+			if _, err := hc.Step(ctx, now); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Step evaluates the heart rate at the given time and makes an adjustment if one is needed.
+func (hc *HeartRateController) Step(ctx context.Context, now time.Time) (HeartRateControlEvent, error) {
+	speed, _ := hc.equipment.CurrentSpeed()
+	incline, _ := hc.equipment.CurrentIncline()
+
+	hc.mutex.Lock()
+	event := hc.decide(now, speed, incline)
+	if event.Action != HeartRateActionHold {
+		hc.lastAdjust = now
+	}
+	listeners := hc.listeners
+	hc.mutex.Unlock()
+
+	if event.Speed != speed {
+		if err := hc.equipment.SetTargetSpeed(ctx, event.Speed); err != nil {
+			return event, err
+		}
+	}
+
+	if event.Incline != incline {
+		if err := hc.equipment.SetTargetIncline(ctx, event.Incline); err != nil {
+			return event, err
+		}
+	}
+
+	for _, l := range listeners {
+		l(event)
+	}
+
+	return event, nil
+}
+
+func (hc *HeartRateController) decide(now time.Time, speed Speed, incline byte) HeartRateControlEvent {
+	cfg := hc.config
+	event := HeartRateControlEvent{
+		Time:      now,
+		Action:    HeartRateActionHold,
+		HeartRate: hc.heartRate,
+		Speed:     speed,
+		Incline:   incline,
+	}
+
+	adjustSpeed := cfg.Adjust == HeartRateAdjustSpeed || cfg.Adjust == HeartRateAdjustBoth
+	adjustIncline := cfg.Adjust == HeartRateAdjustIncline || cfg.Adjust == HeartRateAdjustBoth
+
+	// Without a heart rate we can't tell how the runner is doing, back off to something safe straight away.
+	if hc.heartRate == 0 || now.Sub(hc.lastReport) > cfg.SignalTimeout {
+		event.Action = HeartRateActionSignalLost
+		event.HeartRate = 0
+
+		if speed > cfg.SafeSpeed {
+			event.Speed = cfg.SafeSpeed
+		}
+
+		if adjustIncline {
+			event.Incline = 0
+		}
+
+		return event
+	}
+
+	if !hc.lastAdjust.IsZero() && now.Sub(hc.lastAdjust) < cfg.AdjustInterval {
+		return event
+	}
+
+	switch {
+	case int(hc.heartRate) > int(cfg.ZoneMax)+int(cfg.Hysteresis):
+		event.Action = HeartRateActionDecrease
+
+		if adjustIncline && incline > 0 {
+			event.Incline = subtractByte(incline, cfg.InclineStep, 0)
+		} else if adjustSpeed {
+			event.Speed = Speed(subtractByte(byte(speed), byte(cfg.SpeedStep), byte(cfg.MinSpeed)))
+		}
+	case int(hc.heartRate) < int(cfg.ZoneMin)-int(cfg.Hysteresis):
+		event.Action = HeartRateActionIncrease
+
+		if adjustSpeed && speed < cfg.MaxSpeed {
+			event.Speed = Speed(addByte(byte(speed), byte(cfg.SpeedStep), byte(cfg.MaxSpeed)))
+		} else if adjustIncline {
+			event.Incline = addByte(incline, cfg.InclineStep, cfg.MaxIncline)
+		}
+	}
+
+	// Clamp to the caps even if we're holding, the runner may have gone over them from the console. Bringing them back
+	// down is an adjustment like any other.
+	if adjustSpeed && event.Speed > cfg.MaxSpeed {
+		event.Speed = cfg.MaxSpeed
+		event.Action = clampAction(event.Action)
+	}
+
+	if adjustIncline && event.Incline > cfg.MaxIncline {
+		event.Incline = cfg.MaxIncline
+		event.Action = clampAction(event.Action)
+	}
+
+	if event.Speed == speed && event.Incline == incline {
+		event.Action = HeartRateActionHold
+	}
+
+	return event
+}
+
+// clampAction is the action reported when the speed or incline is brought down to its cap.
+func clampAction(action HeartRateAction) HeartRateAction {
+	if action == HeartRateActionHold {
+		return HeartRateActionDecrease
+	}
+
+	return action
+}
+
+func addByte(value, step, max byte) byte {
+	if int(value)+int(step) > int(max) {
+		return max
+	}
+
+	return value + step
+}
+
+func subtractByte(value, step, min byte) byte {
+	if int(value)-int(step) < int(min) {
+		return min
+	}
+
+	return value - step
+}
@@ -0,0 +1,262 @@
+package treadonme_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+// heartRateModel is a simple simulation of a runner, their heart rate moves towards a steady state that depends on the
+// speed and incline with a lag.
+type heartRateModel struct {
+	heartRate float64
+	lag       time.Duration
+}
+
+func (m *heartRateModel) step(dt time.Duration, speed treadonme.Speed, incline byte) byte {
+	steady := 60 + 14*speed.Float() + 3*float64(incline)
+	m.heartRate += (steady - m.heartRate) * dt.Seconds() / m.lag.Seconds()
+
+	return byte(m.heartRate)
+}
+
+type HeartRateControlTestSuite struct {
+	suite.Suite
+}
+
+func (s *HeartRateControlTestSuite) controller(adjust treadonme.HeartRateAdjust) (*fakeController, *treadonme.HeartRateController) {
+	ctrl := &fakeController{
+		info:  &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial, MinSpeed: 5, MaxSpeed: 120, InclineMax: 15},
+		mode:  treadonme.WorkoutModeRunning,
+		speed: 30,
+	}
+
+	hc, err := treadonme.NewHeartRateController(ctrl, treadonme.HeartRateControlConfig{
+		ZoneMin:    140,
+		ZoneMax:    150,
+		Adjust:     adjust,
+		MinSpeed:   20,
+		MaxSpeed:   80,
+		MaxIncline: 5,
+		SafeSpeed:  30,
+	})
+	s.Require().NoError(err)
+
+	return ctrl, hc
+}
+
+// simulate runs the controller against the heart rate model for the given duration, one second at a time.
+func (s *HeartRateControlTestSuite) simulate(
+	ctrl *fakeController, hc *treadonme.HeartRateController, model *heartRateModel, start time.Time, duration time.Duration,
+) time.Time {
+	now := start
+
+	for ; now.Sub(start) < duration; now = now.Add(time.Second) {
+		speed, _ := ctrl.CurrentSpeed()
+		incline, _ := ctrl.CurrentIncline()
+
+		hc.UpdateHeartRate(model.step(time.Second, speed, incline), now)
+
+		_, err := hc.Step(context.Background(), now)
+		s.Require().NoError(err)
+
+		speed, _ = ctrl.CurrentSpeed()
+		s.Require().LessOrEqual(int(speed), 80)
+	}
+
+	return now
+}
+
+func (s *HeartRateControlTestSuite) TestHoldsZoneWithSpeed() {
+	ctrl, hc := s.controller(treadonme.HeartRateAdjustSpeed)
+	model := &heartRateModel{heartRate: 90, lag: 30 * time.Second}
+
+	s.simulate(ctrl, hc, model, time.Unix(0, 0), 30*time.Minute)
+
+	s.Require().InDelta(145, model.heartRate, 8)
+
+	speed, _ := ctrl.CurrentSpeed()
+	s.Require().InDelta(61, int(speed), 6)
+}
+
+func (s *HeartRateControlTestSuite) TestUsesInclineAtSpeedCap() {
+	ctrl, _ := s.controller(treadonme.HeartRateAdjustBoth)
+	model := &heartRateModel{heartRate: 90, lag: 30 * time.Second}
+
+	// The runner can't reach the zone at the device's max speed alone.
+	ctrl.info.MaxSpeed = 50
+	hc, err := treadonme.NewHeartRateController(ctrl, treadonme.HeartRateControlConfig{
+		ZoneMin: 140, ZoneMax: 150, Adjust: treadonme.HeartRateAdjustBoth, MinSpeed: 20, MaxSpeed: 80, MaxIncline: 10,
+		SafeSpeed: 30,
+	})
+	s.Require().NoError(err)
+
+	var increases int
+
+	hc.AddListener(func(event treadonme.HeartRateControlEvent) {
+		if event.Action == treadonme.HeartRateActionIncrease {
+			increases++
+		}
+	})
+
+	s.simulate(ctrl, hc, model, time.Unix(0, 0), 30*time.Minute)
+
+	speed, _ := ctrl.CurrentSpeed()
+	incline, _ := ctrl.CurrentIncline()
+	s.Require().Equal(treadonme.Speed(50), speed)
+	s.Require().Greater(int(incline), 0)
+	s.Require().LessOrEqual(int(incline), 10)
+	s.Require().Greater(increases, 10)
+}
+
+func (s *HeartRateControlTestSuite) TestRateLimited() {
+	ctrl, hc := s.controller(treadonme.HeartRateAdjustSpeed)
+
+	start := time.Unix(0, 0)
+	hc.UpdateHeartRate(100, start)
+
+	event, err := hc.Step(context.Background(), start)
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionIncrease, event.Action)
+	s.Require().Equal(treadonme.Speed(32), ctrl.speed)
+
+	hc.UpdateHeartRate(100, start.Add(5*time.Second))
+
+	event, err = hc.Step(context.Background(), start.Add(5*time.Second))
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionHold, event.Action)
+	s.Require().Equal(treadonme.Speed(32), ctrl.speed)
+}
+
+func (s *HeartRateControlTestSuite) TestHysteresis() {
+	ctrl, hc := s.controller(treadonme.HeartRateAdjustSpeed)
+
+	start := time.Unix(0, 0)
+	hc.UpdateHeartRate(152, start)
+
+	event, err := hc.Step(context.Background(), start)
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionHold, event.Action)
+	s.Require().Equal(treadonme.Speed(30), ctrl.speed)
+}
+
+func (s *HeartRateControlTestSuite) TestSignalLost() {
+	ctrl, hc := s.controller(treadonme.HeartRateAdjustBoth)
+	ctrl.speed, ctrl.incline = 70, 4
+
+	start := time.Unix(0, 0)
+	hc.UpdateHeartRate(145, start)
+
+	event, err := hc.Step(context.Background(), start.Add(30*time.Second))
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionSignalLost, event.Action)
+	s.Require().Equal(treadonme.Speed(30), ctrl.speed)
+	s.Require().Equal(byte(0), ctrl.incline)
+}
+
+func (s *HeartRateControlTestSuite) TestDefaultSafeSpeed() {
+	ctrl := &fakeController{
+		info:  &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial, MinSpeed: 5, MaxSpeed: 120, InclineMax: 15},
+		mode:  treadonme.WorkoutModeRunning,
+		speed: 70,
+	}
+
+	// Neither the min speed nor the safe speed are set, the treadmill's min speed is used for both.
+	hc, err := treadonme.NewHeartRateController(ctrl, treadonme.HeartRateControlConfig{
+		ZoneMin:  140,
+		ZoneMax:  150,
+		Adjust:   treadonme.HeartRateAdjustSpeed,
+		MaxSpeed: 80,
+	})
+	s.Require().NoError(err)
+
+	start := time.Unix(0, 0)
+	hc.UpdateHeartRate(145, start)
+
+	event, err := hc.Step(context.Background(), start.Add(30*time.Second))
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionSignalLost, event.Action)
+	s.Require().Equal(treadonme.Speed(5), ctrl.speed)
+
+	// Without the treadmill's min speed there's nothing to fall back to.
+	_, err = treadonme.NewHeartRateController(&fakeController{}, treadonme.HeartRateControlConfig{
+		ZoneMin:  140,
+		ZoneMax:  150,
+		MaxSpeed: 80,
+	})
+	s.Require().ErrorIs(err, treadonme.ErrInvalidHeartRateConfig)
+}
+
+func (s *HeartRateControlTestSuite) TestClampsToCaps() {
+	ctrl, hc := s.controller(treadonme.HeartRateAdjustBoth)
+	ctrl.speed, ctrl.incline = 90, 8
+
+	start := time.Unix(0, 0)
+	hc.UpdateHeartRate(145, start)
+
+	// The runner went over the caps from the console while in the zone.
+	event, err := hc.Step(context.Background(), start)
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionDecrease, event.Action)
+	s.Require().Equal(treadonme.Speed(80), ctrl.speed)
+	s.Require().Equal(byte(5), ctrl.incline)
+
+	// Clamping counts as an adjustment, the heart rate gets time to respond.
+	hc.UpdateHeartRate(100, start.Add(5*time.Second))
+
+	event, err = hc.Step(context.Background(), start.Add(5*time.Second))
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionHold, event.Action)
+}
+
+func (s *HeartRateControlTestSuite) TestDefaultMaxIncline() {
+	ctrl := &fakeController{
+		info:    &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial, MinSpeed: 5, MaxSpeed: 120, InclineMax: 15},
+		mode:    treadonme.WorkoutModeRunning,
+		speed:   30,
+		incline: 4,
+	}
+
+	hc, err := treadonme.NewHeartRateController(ctrl, treadonme.HeartRateControlConfig{
+		ZoneMin:  140,
+		ZoneMax:  150,
+		Adjust:   treadonme.HeartRateAdjustIncline,
+		MaxSpeed: 80,
+	})
+	s.Require().NoError(err)
+
+	start := time.Unix(0, 0)
+	hc.UpdateHeartRate(145, start)
+
+	// Holding leaves the incline alone rather than clamping it to zero.
+	event, err := hc.Step(context.Background(), start)
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.HeartRateActionHold, event.Action)
+	s.Require().Equal(byte(4), ctrl.incline)
+
+	// Without the treadmill's maximum there's nothing to default to.
+	_, err = treadonme.NewHeartRateController(&fakeController{}, treadonme.HeartRateControlConfig{
+		ZoneMin:  140,
+		ZoneMax:  150,
+		Adjust:   treadonme.HeartRateAdjustIncline,
+		MaxSpeed: 80,
+	})
+	s.Require().ErrorIs(err, treadonme.ErrInvalidHeartRateConfig)
+}
+
+func (s *HeartRateControlTestSuite) TestInvalidConfig() {
+	_, err := treadonme.NewHeartRateController(&fakeController{}, treadonme.HeartRateControlConfig{ZoneMin: 150, ZoneMax: 140})
+	s.Require().ErrorIs(err, treadonme.ErrInvalidHeartRateConfig)
+
+	_, err = treadonme.NewHeartRateController(&fakeController{}, treadonme.HeartRateControlConfig{ZoneMin: 140, ZoneMax: 150})
+	s.Require().ErrorIs(err, treadonme.ErrInvalidHeartRateConfig)
+}
+
+func TestHeartRateControlTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &HeartRateControlTestSuite{})
+}
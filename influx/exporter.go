@@ -0,0 +1,247 @@
+package influx
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+var (
+	ErrWriteFailed = fmt.Errorf("problem writing to influxdb")
+	// ErrRejected is returned when the database refuses a batch, retrying it won't help.
+	ErrRejected = fmt.Errorf("influxdb rejected the batch")
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultFlushInterval = 10 * time.Second
+	// maxErrorBody is how much of an error response is included in the error.
+	maxErrorBody = 512
+)
+
+// Writer writes a batch of line protocol somewhere.
+type Writer interface {
+	Write(ctx context.Context, batch []byte) error
+}
+
+// HTTPWriter writes batches to the InfluxDB HTTP API. URL is the full write URL, for instance
+// http://influxdb:8086/api/v2/write?org=home&bucket=treadonme&precision=ns, and Token is sent as an API token if set.
+type HTTPWriter struct {
+	URL    string
+	Token  string
+	Client *http.Client
+}
+
+func (w *HTTPWriter) Write(ctx context.Context, batch []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(batch))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if w.Token != "" {
+		req.Header.Set("Authorization", "Token "+w.Token)
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrWriteFailed, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+
+	// Anything other than a server error or rate limiting means the batch itself is bad.
+	if resp.StatusCode/100 == 4 && resp.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %s: %s", ErrRejected, resp.Status, bytes.TrimSpace(body))
+	}
+
+	return fmt.Errorf("%w: %s: %s", ErrWriteFailed, resp.Status, bytes.TrimSpace(body))
+}
+
+// FileWriter appends batches to a file.
+type FileWriter struct {
+	Path string
+}
+
+func (w *FileWriter) Write(_ context.Context, batch []byte) error {
+	f, err := os.OpenFile(w.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrWriteFailed, err)
+	}
+
+	if _, err := f.Write(batch); err != nil {
+		_ = f.Close()
+
+		return fmt.Errorf("%w: %s", ErrWriteFailed, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w: %s", ErrWriteFailed, err)
+	}
+
+	return nil
+}
+
+type ExporterConfig struct {
+	// BatchSize is how many points are buffered before they're flushed, defaults to 100.
+	BatchSize int
+	// FlushInterval is the longest points are buffered for, defaults to ten seconds.
+	FlushInterval time.Duration
+}
+
+// Exporter buffers points in batches and writes them with a Writer. Every batch goes through the queue before it's
+// written so nothing is lost if the database is down or the process restarts, queued batches are retried in order on
+// every flush.
+//
+// Call Exporter.Track with every new session to export its samples.
+type Exporter struct {
+	writer Writer
+	queue  *Queue
+	config ExporterConfig
+
+	mutex   sync.Mutex
+	pending []byte
+	points  int
+	full    chan struct{}
+
+	// writeMutex makes sure batches are written in order.
+	writeMutex sync.Mutex
+}
+
+func NewExporter(writer Writer, queue *Queue, config ExporterConfig) *Exporter {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+
+	return &Exporter{
+		writer: writer,
+		queue:  queue,
+		config: config,
+		full:   make(chan struct{}, 1),
+	}
+}
+
+// Track exports every sample recorded by the session.
+func (e *Exporter) Track(session *treadonme.Session) {
+	session.AddSampleListener(func(sample treadonme.Sample) {
+		e.Add(SamplePoint(session, sample))
+	})
+}
+
+func (e *Exporter) Add(point *Point) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	e.pending = point.AppendLine(e.pending)
+	e.points++
+
+	if e.points >= e.config.BatchSize {
+		select {
+		case e.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Run flushes the buffered points every flush interval, or sooner if a batch fills up, until the context is cancelled.
+// Anything still buffered when it returns is queued to be written next time.
+func (e *Exporter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+
+	// Write anything left in the queue from before a restart.
+	if err := e.Flush(ctx); err != nil {
+		log.Printf("problem flushing influx samples: %s", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := e.enqueue(); err != nil {
+				log.Printf("problem queueing influx samples: %s", err)
+			}
+
+			return ctx.Err()
+		case <-ticker.C:
+		case <-e.full:
+		}
+
+		if err := e.Flush(ctx); err != nil {
+			log.Printf("problem flushing influx samples: %s", err)
+		}
+	}
+}
+
+// Flush queues the buffered points and writes everything in the queue. If a write fails the batch stays at the front
+// of the queue to be retried on the next flush.
+func (e *Exporter) Flush(ctx context.Context) error {
+	if err := e.enqueue(); err != nil {
+		return err
+	}
+
+	e.writeMutex.Lock()
+	defer e.writeMutex.Unlock()
+
+	for {
+		batch, err := e.queue.Peek()
+		if errors.Is(err, ErrQueueEmpty) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if err := e.writer.Write(ctx, batch); errors.Is(err, ErrRejected) {
+			// A rejected batch would block the queue forever.
+			log.Printf("dropping influx batch: %s", err)
+		} else if err != nil {
+			return err
+		}
+
+		if err := e.queue.Pop(); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *Exporter) enqueue() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.pending) == 0 {
+		return nil
+	}
+
+	if err := e.queue.Push(e.pending); err != nil {
+		return err
+	}
+
+	e.pending = nil
+	e.points = 0
+
+	return nil
+}
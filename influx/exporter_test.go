@@ -0,0 +1,200 @@
+package influx_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/influx"
+)
+
+type ExporterTestSuite struct {
+	suite.Suite
+
+	dir string
+}
+
+func (s *ExporterTestSuite) SetupTest() {
+	s.dir = s.T().TempDir()
+}
+
+func (s *ExporterTestSuite) openQueue(maxBytes int64) *influx.Queue {
+	q, err := influx.OpenQueue(filepath.Join(s.dir, "queue"), maxBytes)
+	s.Require().NoError(err)
+
+	return q
+}
+
+func point(n int64) *influx.Point {
+	return &influx.Point{
+		Measurement: "m",
+		Fields:      map[string]interface{}{"n": n},
+		Time:        time.Unix(0, n),
+	}
+}
+
+// database is a stand-in for the InfluxDB write endpoint that can be taken down.
+type database struct {
+	mutex  sync.Mutex
+	down   bool
+	status int
+	body   string
+	token  string
+}
+
+func (d *database) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+
+		return
+	}
+
+	if d.status != 0 {
+		w.WriteHeader(d.status)
+
+		return
+	}
+
+	data, _ := ioutil.ReadAll(r.Body)
+	d.body += string(data)
+	d.token = r.Header.Get("Authorization")
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (d *database) set(fn func(d *database)) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	fn(d)
+}
+
+func (d *database) received() string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.body
+}
+
+func (s *ExporterTestSuite) TestOutage() {
+	db := &database{}
+	server := httptest.NewServer(db)
+	defer server.Close()
+
+	q := s.openQueue(1 << 20)
+	exporter := influx.NewExporter(&influx.HTTPWriter{URL: server.URL, Token: "secret"}, q, influx.ExporterConfig{})
+
+	db.set(func(d *database) { d.down = true })
+
+	exporter.Add(point(1))
+	s.Require().ErrorIs(exporter.Flush(context.Background()), influx.ErrWriteFailed)
+	exporter.Add(point(2))
+	s.Require().ErrorIs(exporter.Flush(context.Background()), influx.ErrWriteFailed)
+	s.Require().Equal(2, q.Len())
+	s.Require().Empty(db.received())
+
+	// The queue survives a restart.
+	q = s.openQueue(1 << 20)
+	exporter = influx.NewExporter(&influx.HTTPWriter{URL: server.URL, Token: "secret"}, q, influx.ExporterConfig{})
+	s.Require().Equal(2, q.Len())
+
+	db.set(func(d *database) { d.down = false })
+
+	exporter.Add(point(3))
+	s.Require().NoError(exporter.Flush(context.Background()))
+	s.Require().Equal("m n=1i 1\nm n=2i 2\nm n=3i 3\n", db.received())
+	s.Require().Zero(q.Len())
+	s.Require().Zero(q.Size())
+
+	db.set(func(d *database) { s.Require().Equal("Token secret", d.token) })
+}
+
+func (s *ExporterTestSuite) TestRejected() {
+	db := &database{status: http.StatusBadRequest}
+	server := httptest.NewServer(db)
+	defer server.Close()
+
+	q := s.openQueue(1 << 20)
+	exporter := influx.NewExporter(&influx.HTTPWriter{URL: server.URL}, q, influx.ExporterConfig{})
+
+	// Bad batches are dropped rather than blocking the queue.
+	exporter.Add(point(1))
+	s.Require().NoError(exporter.Flush(context.Background()))
+	s.Require().Zero(q.Len())
+}
+
+func (s *ExporterTestSuite) TestQueueBound() {
+	q := s.openQueue(20)
+
+	for _, batch := range []string{"first-batch\n", "second\n", "third\n"} {
+		s.Require().NoError(q.Push([]byte(batch)))
+	}
+
+	// The oldest batch is dropped to make room.
+	s.Require().Equal(2, q.Len())
+	s.Require().Equal(int64(13), q.Size())
+
+	batch, err := q.Peek()
+	s.Require().NoError(err)
+	s.Require().Equal("second\n", string(batch))
+	s.Require().NoError(q.Pop())
+	s.Require().NoError(q.Pop())
+
+	_, err = q.Peek()
+	s.Require().ErrorIs(err, influx.ErrQueueEmpty)
+	s.Require().ErrorIs(q.Pop(), influx.ErrQueueEmpty)
+}
+
+func (s *ExporterTestSuite) TestRunBatches() {
+	path := filepath.Join(s.dir, "samples.lp")
+	exporter := influx.NewExporter(&influx.FileWriter{Path: path}, s.openQueue(1<<20), influx.ExporterConfig{
+		BatchSize:     2,
+		FlushInterval: time.Hour,
+	})
+
+	session := treadonme.NewSession(&treadonme.MessageDeviceInfo{Model: treadonme.DeviceModelF80})
+	exporter.Track(session)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+
+	go func() {
+		done <- exporter.Run(ctx)
+	}()
+
+	session.HandleMessage(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeRunning}, nil)
+	session.HandleMessage(&treadonme.MessageWorkoutData{Speed: 30}, nil)
+	session.HandleMessage(&treadonme.MessageWorkoutData{Speed: 31}, nil)
+
+	// A full batch is written without waiting for the flush interval.
+	s.Require().Eventually(func() bool {
+		data, _ := ioutil.ReadFile(path)
+
+		return len(data) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	cancel()
+	s.Require().ErrorIs(<-done, context.Canceled)
+
+	data, err := ioutil.ReadFile(path)
+	s.Require().NoError(err)
+	s.Require().Contains(string(data), "speed=3 ")
+	s.Require().Contains(string(data), "speed=3.1 ")
+	s.Require().Contains(string(data), "workout="+session.ID)
+}
+
+func TestExporterTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &ExporterTestSuite{})
+}
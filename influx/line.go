@@ -0,0 +1,139 @@
+// Package influx exports workout samples as InfluxDB line protocol, either to the InfluxDB HTTP API or to a file.
+package influx
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// Measurement is the measurement samples are written to.
+const Measurement = "treadonme_sample"
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	tagEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
+// Point is a single line of line protocol. Field values can be any integer, float, bool or string, anything else is
+// written as a string.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      map[string]interface{}
+	Time        time.Time
+}
+
+// AppendLine appends the point as a line of line protocol, tags and fields are sorted so the output is stable.
+func (p *Point) AppendLine(buf []byte) []byte {
+	buf = append(buf, measurementEscaper.Replace(p.Measurement)...)
+
+	for _, key := range sortedKeys(p.Tags) {
+		// Empty tag values aren't allowed.
+		if p.Tags[key] == "" {
+			continue
+		}
+
+		buf = append(buf, ',')
+		buf = append(buf, tagEscaper.Replace(key)...)
+		buf = append(buf, '=')
+		buf = append(buf, tagEscaper.Replace(p.Tags[key])...)
+	}
+
+	fieldKeys := make([]string, 0, len(p.Fields))
+	for key := range p.Fields {
+		fieldKeys = append(fieldKeys, key)
+	}
+
+	sort.Strings(fieldKeys)
+
+	for idx, key := range fieldKeys {
+		if idx == 0 {
+			buf = append(buf, ' ')
+		} else {
+			buf = append(buf, ',')
+		}
+
+		buf = append(buf, tagEscaper.Replace(key)...)
+		buf = append(buf, '=')
+		buf = appendField(buf, p.Fields[key])
+	}
+
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, p.Time.UnixNano(), 10)
+
+	return append(buf, '\n')
+}
+
+func appendField(buf []byte, value interface{}) []byte {
+	rv := reflect.ValueOf(value)
+
+	switch rv.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return strconv.AppendFloat(buf, rv.Float(), 'f', -1, rv.Type().Bits())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return append(strconv.AppendInt(buf, rv.Int(), 10), 'i')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		// Unsigned fields aren't supported everywhere, so they're only used when the value doesn't fit an integer.
+		v := rv.Uint()
+		if v > math.MaxInt64 {
+			return append(strconv.AppendUint(buf, v, 10), 'u')
+		}
+
+		return append(strconv.AppendInt(buf, int64(v), 10), 'i')
+	case reflect.Bool:
+		return strconv.AppendBool(buf, rv.Bool())
+	case reflect.String:
+		return appendString(buf, rv.String())
+	default:
+		return appendString(buf, fmt.Sprint(value))
+	}
+}
+
+func appendString(buf []byte, value string) []byte {
+	return append(append(append(buf, '"'), stringEscaper.Replace(value)...), '"')
+}
+
+// SamplePoint converts a sample from a session into a point tagged with the session's device model, user and workout
+// id. Speeds and distances are in the session's units.
+func SamplePoint(session *treadonme.Session, sample treadonme.Sample) *Point {
+	return &Point{
+		Measurement: Measurement,
+		Tags: map[string]string{
+			"model":   session.Model.String(),
+			"user":    session.User,
+			"workout": session.ID,
+			"units":   session.Units.String(),
+		},
+		Fields: map[string]interface{}{
+			"elapsed":           sample.Elapsed.Seconds(),
+			"speed":             sample.Speed.Float(),
+			"incline":           int64(sample.Incline),
+			"heart_rate":        int64(sample.HeartRate),
+			"heart_rate_source": sample.HeartRateSource.String(),
+			// Distances are transmitted multiplied by one hundred.
+			"distance": float64(sample.Distance) / 100,
+			"calories": int64(sample.Calories),
+			"mode":     sample.Mode.String(),
+		},
+		Time: sample.Timestamp,
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,77 @@
+package influx_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/influx"
+)
+
+type LineTestSuite struct {
+	suite.Suite
+}
+
+func (s *LineTestSuite) TestSamplePoint() {
+	session := treadonme.NewSession(&treadonme.MessageDeviceInfo{
+		Model: treadonme.DeviceModelF80,
+		Units: treadonme.UnitsTypeImperial,
+	})
+	session.ID = "abc123"
+	session.User = "alex"
+
+	point := influx.SamplePoint(session, treadonme.Sample{
+		Timestamp:       time.Unix(1651406400, 500),
+		Elapsed:         90 * time.Second,
+		Distance:        125,
+		Calories:        42,
+		Speed:           65,
+		Incline:         3,
+		HeartRate:       130,
+		HeartRateSource: treadonme.HeartRateSourceExternal,
+		Mode:            treadonme.WorkoutModeRunning,
+	})
+
+	s.Require().Equal("treadonme_sample,model=F80,units=Imperial,user=alex,workout=abc123 "+
+		`calories=42i,distance=1.25,elapsed=90,heart_rate=130i,heart_rate_source="External",incline=3i,`+
+		`mode="Running",speed=6.5 1651406400000000500`+"\n", string(point.AppendLine(nil)))
+}
+
+func (s *LineTestSuite) TestEscaping() {
+	point := &influx.Point{
+		Measurement: "my measurement,x",
+		Tags:        map[string]string{"user name": "a=b,c", "empty": ""},
+		Fields:      map[string]interface{}{"note": `say "hi" \o/`, "ok": true},
+		Time:        time.Unix(0, 1),
+	}
+
+	s.Require().Equal(`my\ measurement\,x,user\ name=a\=b\,c note="say \"hi\" \\o/",ok=true 1`+"\n",
+		string(point.AppendLine(nil)))
+}
+
+func (s *LineTestSuite) TestFieldTypes() {
+	point := &influx.Point{
+		Measurement: "m",
+		Fields: map[string]interface{}{
+			"a": 1,
+			"b": uint8(2),
+			"c": float32(1.5),
+			"d": time.Second,
+			"e": uint64(math.MaxUint64),
+			"f": []int{1, 2},
+			"g": nil,
+		},
+		Time: time.Unix(0, 1),
+	}
+
+	s.Require().Equal(`m a=1i,b=2i,c=1.5,d=1000000000i,e=18446744073709551615u,f="[1 2]",g="<nil>" 1`+"\n",
+		string(point.AppendLine(nil)))
+}
+
+func TestLineTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &LineTestSuite{})
+}
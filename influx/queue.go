@@ -0,0 +1,186 @@
+package influx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var ErrQueueEmpty = fmt.Errorf("queue is empty")
+
+const batchExtension = ".lp"
+
+// Queue is a bounded on-disk queue of batches waiting to be written. Every batch is kept in its own file so a crash
+// never loses more than the batch being written. Once the queue is full the oldest batches are dropped to make room.
+type Queue struct {
+	dir      string
+	maxBytes int64
+
+	mutex   sync.Mutex
+	batches []queuedBatch
+	size    int64
+	nextSeq uint64
+}
+
+type queuedBatch struct {
+	seq  uint64
+	size int64
+}
+
+// OpenQueue opens the queue in dir, picking up any batches left over from a previous run.
+func OpenQueue(dir string, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("problem creating queue directory: %w", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading queue directory: %w", err)
+	}
+
+	q := &Queue{dir: dir, maxBytes: maxBytes}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, batchExtension) {
+			continue
+		}
+
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, batchExtension), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		q.batches = append(q.batches, queuedBatch{seq: seq, size: entry.Size()})
+		q.size += entry.Size()
+
+		if seq >= q.nextSeq {
+			q.nextSeq = seq + 1
+		}
+	}
+
+	sort.Slice(q.batches, func(i, j int) bool {
+		return q.batches[i].seq < q.batches[j].seq
+	})
+
+	return q, nil
+}
+
+// Push adds a batch to the end of the queue, dropping the oldest batches if there isn't enough room.
+func (q *Queue) Push(batch []byte) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	size := int64(len(batch))
+
+	for len(q.batches) > 0 && q.size+size > q.maxBytes {
+		dropped := q.batches[0]
+		log.Printf("influx queue is full, dropping %d bytes of samples", dropped.size)
+
+		if err := q.remove(); err != nil {
+			return err
+		}
+	}
+
+	seq := q.nextSeq
+	if err := writeFileAtomic(q.path(seq), batch); err != nil {
+		return fmt.Errorf("problem writing queued batch: %w", err)
+	}
+
+	q.nextSeq++
+	q.batches = append(q.batches, queuedBatch{seq: seq, size: size})
+	q.size += size
+
+	return nil
+}
+
+// Peek returns the oldest batch without removing it.
+func (q *Queue) Peek() ([]byte, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.batches) == 0 {
+		return nil, ErrQueueEmpty
+	}
+
+	batch, err := ioutil.ReadFile(q.path(q.batches[0].seq))
+	if err != nil {
+		return nil, fmt.Errorf("problem reading queued batch: %w", err)
+	}
+
+	return batch, nil
+}
+
+// Pop removes the oldest batch, call it once the batch returned by Peek has been written.
+func (q *Queue) Pop() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if len(q.batches) == 0 {
+		return ErrQueueEmpty
+	}
+
+	return q.remove()
+}
+
+// Len returns the number of batches in the queue.
+func (q *Queue) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return len(q.batches)
+}
+
+// Size returns the number of bytes in the queue.
+func (q *Queue) Size() int64 {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	return q.size
+}
+
+func (q *Queue) remove() error {
+	oldest := q.batches[0]
+
+	if err := os.Remove(q.path(oldest.seq)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("problem removing queued batch: %w", err)
+	}
+
+	q.batches = q.batches[1:]
+	q.size -= oldest.size
+
+	return nil
+}
+
+func (q *Queue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, batchExtension))
+}
+
+// writeFileAtomic writes to a temporary file and renames it into place so a crash never leaves a partial batch.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
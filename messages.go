@@ -461,7 +461,7 @@ func (mi *MessageMaxIncline) String() string {
 }
 
 type MessageErrorCode struct {
-	Code byte
+	Code ErrorCode
 }
 
 func (e *MessageErrorCode) MessageType() MessageType {
@@ -469,7 +469,7 @@ func (e *MessageErrorCode) MessageType() MessageType {
 }
 
 func (e *MessageErrorCode) MarshalBinary() ([]byte, error) {
-	return []byte{byte(e.MessageType()), e.Code}, nil
+	return []byte{byte(e.MessageType()), byte(e.Code)}, nil
 }
 
 func (e *MessageErrorCode) UnmarshalBinary(data []byte) error {
@@ -477,7 +477,7 @@ func (e *MessageErrorCode) UnmarshalBinary(data []byte) error {
 		return err
 	}
 
-	e.Code = data[1]
+	e.Code = ErrorCode(data[1])
 
 	return nil
 }
@@ -487,7 +487,7 @@ func (e *MessageErrorCode) ExpectedLength() int {
 }
 
 func (e *MessageErrorCode) String() string {
-	return fmt.Sprintf("ErrorCode[Code=%d]", e.Code)
+	return fmt.Sprintf("ErrorCode[Code=%d (%s)]", byte(e.Code), e.Code)
 }
 
 type MessageSpeed struct {
@@ -872,6 +872,14 @@ func (e *MessageProgramGraphics) UnmarshalBinary(data []byte) error {
 	return nil
 }
 
+// Levels returns the level of every segment in the program graph.
+func (e *MessageProgramGraphics) Levels() []byte {
+	levels := make([]byte, len(e.Graph))
+	copy(levels, e.Graph[:])
+
+	return levels
+}
+
 func (e *MessageProgramGraphics) ExpectedLength() int {
 	return 19
 }
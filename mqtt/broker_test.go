@@ -0,0 +1,215 @@
+package mqtt_test
+
+import (
+	"bufio"
+	"net"
+	"sync"
+
+	"github.com/swedishborgie/treadonme/mqtt"
+)
+
+// broker is an in-process stand-in for an MQTT broker, it supports just enough for the client: credentials, retained
+// messages and wildcard subscriptions at QoS 0.
+type broker struct {
+	listener net.Listener
+	username string
+	password string
+
+	mutex    sync.Mutex
+	conns    map[net.Conn][]string
+	retained map[string][]byte
+	connects []*mqtt.Connect
+}
+
+func newBroker(username, password string) (*broker, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &broker{
+		listener: listener,
+		username: username,
+		password: password,
+		conns:    map[net.Conn][]string{},
+		retained: map[string][]byte{},
+	}
+
+	go b.accept()
+
+	return b, nil
+}
+
+func (b *broker) addr() string {
+	return b.listener.Addr().String()
+}
+
+func (b *broker) close() {
+	_ = b.listener.Close()
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for conn := range b.conns {
+		_ = conn.Close()
+	}
+}
+
+// dropClients closes every client connection as if the broker had restarted.
+func (b *broker) dropClients() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for conn := range b.conns {
+		_ = conn.Close()
+		delete(b.conns, conn)
+	}
+}
+
+func (b *broker) clients() []*mqtt.Connect {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	return append([]*mqtt.Connect(nil), b.connects...)
+}
+
+func (b *broker) retainedMessage(topic string) ([]byte, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	payload, ok := b.retained[topic]
+
+	return payload, ok
+}
+
+func (b *broker) accept() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go b.serve(conn)
+	}
+}
+
+func (b *broker) serve(conn net.Conn) {
+	defer func() {
+		b.mutex.Lock()
+		delete(b.conns, conn)
+		b.mutex.Unlock()
+
+		_ = conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+
+	p, err := mqtt.ReadPacket(reader)
+	if err != nil {
+		return
+	}
+
+	connect, err := mqtt.ParseConnect(p)
+	if err != nil {
+		return
+	}
+
+	if connect.Username != b.username || connect.Password != b.password {
+		_ = mqtt.WritePacket(conn, mqtt.ConnackPacket(mqtt.ConnectBadCredentials))
+
+		return
+	}
+
+	b.mutex.Lock()
+	b.conns[conn] = nil
+	b.connects = append(b.connects, connect)
+	err = mqtt.WritePacket(conn, mqtt.ConnackPacket(mqtt.ConnectAccepted))
+	b.mutex.Unlock()
+
+	if err != nil {
+		return
+	}
+
+	for {
+		p, err := mqtt.ReadPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch p.Type {
+		case mqtt.PacketSubscribe:
+			if !b.subscribe(conn, p) {
+				return
+			}
+		case mqtt.PacketPublish:
+			b.publish(p)
+		case mqtt.PacketPingreq:
+			b.write(conn, &mqtt.Packet{Type: mqtt.PacketPingresp})
+		case mqtt.PacketDisconnect:
+			return
+		}
+	}
+}
+
+func (b *broker) subscribe(conn net.Conn, p *mqtt.Packet) bool {
+	sub, err := mqtt.ParseSubscribe(p)
+	if err != nil {
+		return false
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.conns[conn] = append(b.conns[conn], sub.Topics...)
+
+	if err := mqtt.WritePacket(conn, mqtt.SubackPacket(sub.PacketID, len(sub.Topics))); err != nil {
+		return false
+	}
+
+	for topic, payload := range b.retained {
+		for _, filter := range sub.Topics {
+			if mqtt.Match(filter, topic) {
+				pb := &mqtt.Publish{Topic: topic, Payload: payload, Retain: true}
+				_ = mqtt.WritePacket(conn, pb.Packet())
+
+				break
+			}
+		}
+	}
+
+	return true
+}
+
+func (b *broker) publish(p *mqtt.Packet) {
+	pb, err := mqtt.ParsePublish(p)
+	if err != nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if pb.Retain {
+		b.retained[pb.Topic] = pb.Payload
+	}
+
+	// Messages are forwarded without the retain flag, it's only set on messages sent when subscribing.
+	forward := (&mqtt.Publish{Topic: pb.Topic, Payload: pb.Payload}).Packet()
+
+	for conn, filters := range b.conns {
+		for _, filter := range filters {
+			if mqtt.Match(filter, pb.Topic) {
+				_ = mqtt.WritePacket(conn, forward)
+
+				break
+			}
+		}
+	}
+}
+
+func (b *broker) write(conn net.Conn, p *mqtt.Packet) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	_ = mqtt.WritePacket(conn, p)
+}
@@ -0,0 +1,322 @@
+package mqtt
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConnectRefused = fmt.Errorf("mqtt broker refused the connection")
+	ErrClosed         = fmt.Errorf("mqtt connection closed")
+	ErrTimeout        = fmt.Errorf("mqtt broker didn't respond")
+)
+
+// responseTimeout is how long to wait for the broker to respond to a connect or subscribe.
+const responseTimeout = 10 * time.Second
+
+type Config struct {
+	// Broker is the host:port of the broker.
+	Broker   string
+	Username string
+	Password string
+	// ClientID defaults to a random one.
+	ClientID string
+	// KeepAlive is how often the broker is pinged, it defaults to a minute.
+	KeepAlive time.Duration
+}
+
+type MessageHandler func(topic string, payload []byte)
+
+type subscription struct {
+	filter  string
+	handler MessageHandler
+}
+
+// Client is a connection to an MQTT broker. Everything is sent at QoS 0 and the client doesn't reconnect by itself,
+// check Done to find out when the connection has been lost.
+type Client struct {
+	conn net.Conn
+
+	writeMutex sync.Mutex
+
+	mutex         sync.Mutex
+	subscriptions []subscription
+	subacks       map[uint16]chan struct{}
+	nextPacketID  uint16
+	err           error
+
+	done chan struct{}
+}
+
+func Dial(ctx context.Context, config Config) (*Client, error) {
+	if config.ClientID == "" {
+		config.ClientID = newClientID()
+	}
+
+	if config.KeepAlive == 0 {
+		config.KeepAlive = time.Minute
+	}
+
+	dialer := &net.Dialer{}
+
+	conn, err := dialer.DialContext(ctx, "tcp", config.Broker)
+	if err != nil {
+		return nil, fmt.Errorf("problem connecting to mqtt broker: %w", err)
+	}
+
+	c := &Client{
+		conn:    conn,
+		subacks: map[uint16]chan struct{}{},
+		done:    make(chan struct{}),
+	}
+
+	reader := bufio.NewReader(conn)
+
+	if err := c.connect(reader, config); err != nil {
+		if closeErr := conn.Close(); closeErr != nil {
+			log.Printf("problem closing mqtt connection after failed connect: %s", closeErr)
+		}
+
+		return nil, err
+	}
+
+	go c.readLoop(reader)
+	go c.keepAlive(config.KeepAlive)
+
+	return c, nil
+}
+
+func (c *Client) connect(reader *bufio.Reader, config Config) error {
+	connect := &Connect{
+		ClientID:  config.ClientID,
+		Username:  config.Username,
+		Password:  config.Password,
+		KeepAlive: uint16(config.KeepAlive / time.Second),
+	}
+
+	if err := c.write(connect.Packet()); err != nil {
+		return err
+	}
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(responseTimeout)); err != nil {
+		return err
+	}
+
+	p, err := ReadPacket(reader)
+	if err != nil {
+		return fmt.Errorf("problem reading connack: %w", err)
+	} else if p.Type != PacketConnack {
+		return fmt.Errorf("%w: expected %s, got %s", ErrUnexpectedType, PacketConnack, p.Type)
+	} else if len(p.Body) != 2 {
+		return fmt.Errorf("%w: connack", ErrMalformedPacket)
+	} else if code := ConnectReturnCode(p.Body[1]); code != ConnectAccepted {
+		return fmt.Errorf("%w: return code %d", ErrConnectRefused, code)
+	}
+
+	return c.conn.SetReadDeadline(time.Time{})
+}
+
+// Publish sends a message to the broker, retained messages are kept by the broker and sent to new subscribers.
+func (c *Client) Publish(topic string, payload []byte, retain bool) error {
+	return c.write((&Publish{Topic: topic, Payload: payload, Retain: retain}).Packet())
+}
+
+// Subscribe registers a handler for a topic filter (which can have wildcards) and waits for the broker to acknowledge
+// it. Handlers are called from the read loop so they should return quickly.
+func (c *Client) Subscribe(ctx context.Context, filter string, handler MessageHandler) error {
+	c.mutex.Lock()
+	c.nextPacketID++
+	if c.nextPacketID == 0 {
+		c.nextPacketID++
+	}
+
+	packetID := c.nextPacketID
+	ack := make(chan struct{})
+	c.subacks[packetID] = ack
+	c.subscriptions = append(c.subscriptions, subscription{filter: filter, handler: handler})
+	c.mutex.Unlock()
+
+	defer func() {
+		c.mutex.Lock()
+		delete(c.subacks, packetID)
+		c.mutex.Unlock()
+	}()
+
+	if err := c.write((&Subscribe{PacketID: packetID, Topics: []string{filter}}).Packet()); err != nil {
+		return err
+	}
+
+	timeout := time.NewTimer(responseTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-ack:
+		return nil
+	case <-c.done:
+		return c.Err()
+	case <-timeout.C:
+		return fmt.Errorf("%w: subscribing to %s", ErrTimeout, filter)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done is closed when the connection is lost or closed.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection was lost, nil while it's still connected.
+func (c *Client) Err() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.err
+}
+
+func (c *Client) Close() error {
+	if err := c.write(&Packet{Type: PacketDisconnect}); err != nil {
+		log.Printf("problem sending mqtt disconnect: %s", err)
+	}
+
+	c.shutdown(ErrClosed)
+
+	return nil
+}
+
+func (c *Client) write(p *Packet) error {
+	select {
+	case <-c.done:
+		return c.Err()
+	default:
+	}
+
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	if err := WritePacket(c.conn, p); err != nil {
+		c.shutdown(err)
+
+		return fmt.Errorf("problem writing mqtt %s: %w", p.Type, err)
+	}
+
+	return nil
+}
+
+func (c *Client) readLoop(reader *bufio.Reader) {
+	for {
+		p, err := ReadPacket(reader)
+		if err != nil {
+			c.shutdown(err)
+
+			return
+		}
+
+		switch p.Type {
+		case PacketPublish:
+			c.dispatch(p)
+		case PacketSuback:
+			if len(p.Body) < 2 {
+				continue
+			}
+
+			c.mutex.Lock()
+			if ack, ok := c.subacks[uint16(p.Body[0])<<8|uint16(p.Body[1])]; ok {
+				close(ack)
+			}
+			c.mutex.Unlock()
+		case PacketPingresp:
+		default:
+			log.Printf("ignoring unexpected mqtt %s", p.Type)
+		}
+	}
+}
+
+func (c *Client) dispatch(p *Packet) {
+	pb, err := ParsePublish(p)
+	if err != nil {
+		log.Printf("problem parsing mqtt publish: %s", err)
+
+		return
+	}
+
+	c.mutex.Lock()
+	subscriptions := c.subscriptions
+	c.mutex.Unlock()
+
+	for _, sub := range subscriptions {
+		if Match(sub.filter, pb.Topic) {
+			sub.handler(pb.Topic, pb.Payload)
+		}
+	}
+}
+
+func (c *Client) keepAlive(interval time.Duration) {
+	// Ping a little more often than required so the broker never gives up on us.
+	ticker := time.NewTicker(interval * 3 / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			if err := c.write(&Packet{Type: PacketPingreq}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) shutdown(err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.done)
+
+	if closeErr := c.conn.Close(); closeErr != nil {
+		log.Printf("problem closing mqtt connection: %s", closeErr)
+	}
+}
+
+// Match reports whether a topic matches a topic filter, "+" matches a single level and "#" matches any number of
+// levels at the end of the filter.
+func Match(filter, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for idx, level := range filterLevels {
+		switch {
+		case level == "#":
+			return true
+		case idx >= len(topicLevels):
+			return false
+		case level != "+" && level != topicLevels[idx]:
+			return false
+		}
+	}
+
+	return len(filterLevels) == len(topicLevels)
+}
+
+func newClientID() string {
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return fmt.Sprintf("treadonme-%d", time.Now().UnixNano())
+	}
+
+	return "treadonme-" + hex.EncodeToString(id)
+}
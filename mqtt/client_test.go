@@ -0,0 +1,189 @@
+package mqtt_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme/mqtt"
+)
+
+const receiveTimeout = 5 * time.Second
+
+type ClientTestSuite struct {
+	suite.Suite
+	broker *broker
+}
+
+type message struct {
+	topic   string
+	payload string
+}
+
+func (s *ClientTestSuite) SetupTest() {
+	b, err := newBroker("user", "secret")
+	s.Require().NoError(err)
+
+	s.broker = b
+}
+
+func (s *ClientTestSuite) TearDownTest() {
+	s.broker.close()
+}
+
+func (s *ClientTestSuite) dial() *mqtt.Client {
+	client, err := mqtt.Dial(context.Background(), mqtt.Config{
+		Broker:   s.broker.addr(),
+		Username: "user",
+		Password: "secret",
+	})
+	s.Require().NoError(err)
+
+	return client
+}
+
+// subscribe subscribes to a filter and returns a channel the received messages are sent to.
+func (s *ClientTestSuite) subscribe(client *mqtt.Client, filter string) <-chan message {
+	messages := make(chan message, 100)
+
+	s.Require().NoError(client.Subscribe(context.Background(), filter, func(topic string, payload []byte) {
+		messages <- message{topic: topic, payload: string(payload)}
+	}))
+
+	return messages
+}
+
+func (s *ClientTestSuite) receive(messages <-chan message) message {
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(receiveTimeout):
+		s.FailNow("timed out waiting for message")
+
+		return message{}
+	}
+}
+
+func (s *ClientTestSuite) TestConnect() {
+	client, err := mqtt.Dial(context.Background(), mqtt.Config{
+		Broker:    s.broker.addr(),
+		Username:  "user",
+		Password:  "secret",
+		ClientID:  "treadmill",
+		KeepAlive: 30 * time.Second,
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(client.Close())
+	s.Require().ErrorIs(client.Err(), mqtt.ErrClosed)
+
+	clients := s.broker.clients()
+	s.Require().Len(clients, 1)
+	s.Require().Equal(&mqtt.Connect{ClientID: "treadmill", Username: "user", Password: "secret", KeepAlive: 30},
+		clients[0])
+}
+
+func (s *ClientTestSuite) TestBadCredentials() {
+	_, err := mqtt.Dial(context.Background(), mqtt.Config{Broker: s.broker.addr(), Username: "user"})
+	s.Require().ErrorIs(err, mqtt.ErrConnectRefused)
+}
+
+func (s *ClientTestSuite) TestPublishSubscribe() {
+	publisher, subscriber := s.dial(), s.dial()
+	defer publisher.Close()
+	defer subscriber.Close()
+
+	messages := s.subscribe(subscriber, "treadonme/+/state")
+
+	s.Require().NoError(publisher.Publish("treadonme/other", []byte("ignored"), false))
+	s.Require().NoError(publisher.Publish("treadonme/left/state", []byte("running"), false))
+	s.Require().Equal(message{topic: "treadonme/left/state", payload: "running"}, s.receive(messages))
+}
+
+func (s *ClientTestSuite) TestRetained() {
+	publisher := s.dial()
+	defer publisher.Close()
+
+	s.Require().NoError(publisher.Publish("treadonme/state", []byte("idle"), true))
+
+	// Wait for the broker to have the message before subscribing, the publish doesn't wait for an acknowledgement.
+	s.Require().Eventually(func() bool {
+		_, ok := s.broker.retainedMessage("treadonme/state")
+
+		return ok
+	}, receiveTimeout, 10*time.Millisecond)
+
+	subscriber := s.dial()
+	defer subscriber.Close()
+
+	s.Require().Equal(message{topic: "treadonme/state", payload: "idle"}, s.receive(s.subscribe(subscriber, "#")))
+}
+
+func (s *ClientTestSuite) TestConnectionLost() {
+	client := s.dial()
+
+	s.broker.dropClients()
+
+	select {
+	case <-client.Done():
+	case <-time.After(receiveTimeout):
+		s.FailNow("connection loss wasn't noticed")
+	}
+
+	s.Require().Error(client.Err())
+	s.Require().Error(client.Publish("treadonme/state", nil, false))
+}
+
+func (s *ClientTestSuite) TestMatch() {
+	for _, tc := range []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"treadonme/state", "treadonme/state", true},
+		{"treadonme/state", "treadonme/set", false},
+		{"treadonme/+", "treadonme/set", true},
+		{"treadonme/+", "treadonme/set/now", false},
+		{"treadonme/#", "treadonme/set/now", true},
+		{"treadonme/#", "treadonme", true},
+		{"#", "treadonme/state", true},
+		{"+/+/config", "homeassistant/sensor/config", true},
+		{"treadonme/state/extra", "treadonme/state", false},
+	} {
+		s.Require().Equal(tc.match, mqtt.Match(tc.filter, tc.topic), "%s %s", tc.filter, tc.topic)
+	}
+}
+
+func (s *ClientTestSuite) TestPacketRoundTrip() {
+	// Big enough for a multi-byte remaining length.
+	pb := &mqtt.Publish{Topic: "treadonme/state", Payload: bytes.Repeat([]byte{'x'}, 300), Retain: true}
+
+	buf := &bytes.Buffer{}
+	s.Require().NoError(mqtt.WritePacket(buf, pb.Packet()))
+	s.Require().Equal([]byte{0x31, 0xbd, 0x02}, buf.Bytes()[:3])
+
+	p, err := mqtt.ReadPacket(bufio.NewReader(buf))
+	s.Require().NoError(err)
+
+	parsed, err := mqtt.ParsePublish(p)
+	s.Require().NoError(err)
+	s.Require().Equal(pb, parsed)
+}
+
+func (s *ClientTestSuite) TestMalformed() {
+	_, err := mqtt.ReadPacket(bufio.NewReader(bytes.NewReader([]byte{0x30, 0xff, 0xff, 0xff, 0xff})))
+	s.Require().ErrorIs(err, mqtt.ErrMalformedPacket)
+
+	_, err = mqtt.ParsePublish(&mqtt.Packet{Type: mqtt.PacketPublish, Body: []byte{0, 5, 't'}})
+	s.Require().ErrorIs(err, mqtt.ErrMalformedPacket)
+
+	_, err = mqtt.ParseConnect(&mqtt.Packet{Type: mqtt.PacketPublish})
+	s.Require().ErrorIs(err, mqtt.ErrUnexpectedType)
+}
+
+func TestClientTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &ClientTestSuite{})
+}
@@ -0,0 +1,322 @@
+// Package mqtt is a minimal MQTT 3.1.1 client, just enough to publish telemetry and receive commands at QoS 0.
+package mqtt
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrMalformedPacket = fmt.Errorf("malformed mqtt packet")
+	ErrUnexpectedType  = fmt.Errorf("unexpected mqtt packet type")
+)
+
+type PacketType byte
+
+const (
+	PacketConnect    PacketType = 1
+	PacketConnack    PacketType = 2
+	PacketPublish    PacketType = 3
+	PacketSubscribe  PacketType = 8
+	PacketSuback     PacketType = 9
+	PacketPingreq    PacketType = 12
+	PacketPingresp   PacketType = 13
+	PacketDisconnect PacketType = 14
+)
+
+// maxRemainingBytes is the most bytes the remaining length can be encoded in.
+const maxRemainingBytes = 4
+
+func (pt PacketType) String() string {
+	switch pt {
+	case PacketConnect:
+		return "CONNECT"
+	case PacketConnack:
+		return "CONNACK"
+	case PacketPublish:
+		return "PUBLISH"
+	case PacketSubscribe:
+		return "SUBSCRIBE"
+	case PacketSuback:
+		return "SUBACK"
+	case PacketPingreq:
+		return "PINGREQ"
+	case PacketPingresp:
+		return "PINGRESP"
+	case PacketDisconnect:
+		return "DISCONNECT"
+	default:
+		return fmt.Sprintf("Unknown(%d)", byte(pt))
+	}
+}
+
+// Packet is a raw control packet: the type and flags from the fixed header and everything after the remaining length.
+type Packet struct {
+	Type  PacketType
+	Flags byte
+	Body  []byte
+}
+
+func ReadPacket(r *bufio.Reader) (*Packet, error) {
+	header, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	length, multiplier := 0, 1
+
+	for idx := 0; ; idx++ {
+		if idx == maxRemainingBytes {
+			return nil, fmt.Errorf("%w: remaining length too long", ErrMalformedPacket)
+		}
+
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		length += int(b&0x7f) * multiplier
+		multiplier *= 128
+
+		if b&0x80 == 0 {
+			break
+		}
+	}
+
+	p := &Packet{Type: PacketType(header >> 4), Flags: header & 0x0f, Body: make([]byte, length)}
+	if _, err := io.ReadFull(r, p.Body); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func WritePacket(w io.Writer, p *Packet) error {
+	buf := []byte{byte(p.Type)<<4 | p.Flags}
+
+	length := len(p.Body)
+	for {
+		b := byte(length % 128)
+		length /= 128
+
+		if length > 0 {
+			b |= 0x80
+		}
+
+		buf = append(buf, b)
+
+		if length == 0 {
+			break
+		}
+	}
+
+	_, err := w.Write(append(buf, p.Body...))
+
+	return err
+}
+
+const (
+	connectFlagCleanSession = 0x02
+	connectFlagPassword     = 0x40
+	connectFlagUsername     = 0x80
+	protocolLevel           = 4
+)
+
+type Connect struct {
+	ClientID  string
+	Username  string
+	Password  string
+	KeepAlive uint16
+}
+
+func (c *Connect) Packet() *Packet {
+	flags := byte(connectFlagCleanSession)
+	if c.Username != "" {
+		flags |= connectFlagUsername
+	}
+
+	if c.Password != "" {
+		flags |= connectFlagPassword
+	}
+
+	body := appendString(nil, "MQTT")
+	body = append(body, protocolLevel, flags)
+	body = appendUint16(body, c.KeepAlive)
+	body = appendString(body, c.ClientID)
+
+	if c.Username != "" {
+		body = appendString(body, c.Username)
+	}
+
+	if c.Password != "" {
+		body = appendString(body, c.Password)
+	}
+
+	return &Packet{Type: PacketConnect, Body: body}
+}
+
+func ParseConnect(p *Packet) (*Connect, error) {
+	if p.Type != PacketConnect {
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedType, p.Type)
+	}
+
+	r := &bodyReader{body: p.Body}
+
+	if name := r.string(); name != "MQTT" {
+		return nil, fmt.Errorf("%w: protocol %q", ErrMalformedPacket, name)
+	}
+
+	r.byte() // Protocol level.
+
+	flags := r.byte()
+	c := &Connect{KeepAlive: r.uint16(), ClientID: r.string()}
+
+	if flags&connectFlagUsername != 0 {
+		c.Username = r.string()
+	}
+
+	if flags&connectFlagPassword != 0 {
+		c.Password = r.string()
+	}
+
+	return c, r.err()
+}
+
+// ConnectReturnCode is the result of a connect, sent back in the CONNACK.
+type ConnectReturnCode byte
+
+const (
+	ConnectAccepted          ConnectReturnCode = 0
+	ConnectServerUnavailable ConnectReturnCode = 3
+	ConnectBadCredentials    ConnectReturnCode = 4
+	ConnectNotAuthorized     ConnectReturnCode = 5
+)
+
+func ConnackPacket(code ConnectReturnCode) *Packet {
+	return &Packet{Type: PacketConnack, Body: []byte{0, byte(code)}}
+}
+
+type Publish struct {
+	Topic   string
+	Payload []byte
+	Retain  bool
+}
+
+// Packet encodes the publish at QoS 0, the only QoS supported.
+func (pb *Publish) Packet() *Packet {
+	var flags byte
+	if pb.Retain {
+		flags = 0x01
+	}
+
+	return &Packet{Type: PacketPublish, Flags: flags, Body: append(appendString(nil, pb.Topic), pb.Payload...)}
+}
+
+func ParsePublish(p *Packet) (*Publish, error) {
+	if p.Type != PacketPublish {
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedType, p.Type)
+	}
+
+	r := &bodyReader{body: p.Body}
+	pb := &Publish{Topic: r.string(), Retain: p.Flags&0x01 != 0}
+
+	// Packets above QoS 0 have a packet identifier before the payload.
+	if p.Flags&0x06 != 0 {
+		r.uint16()
+	}
+
+	if err := r.err(); err != nil {
+		return nil, err
+	}
+
+	pb.Payload = append([]byte(nil), p.Body[r.offset:]...)
+
+	return pb, nil
+}
+
+type Subscribe struct {
+	PacketID uint16
+	Topics   []string
+}
+
+// Packet encodes the subscription requesting QoS 0 for every topic.
+func (s *Subscribe) Packet() *Packet {
+	body := appendUint16(nil, s.PacketID)
+	for _, topic := range s.Topics {
+		body = append(appendString(body, topic), 0)
+	}
+
+	return &Packet{Type: PacketSubscribe, Flags: 0x02, Body: body}
+}
+
+func ParseSubscribe(p *Packet) (*Subscribe, error) {
+	if p.Type != PacketSubscribe {
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedType, p.Type)
+	}
+
+	r := &bodyReader{body: p.Body}
+	s := &Subscribe{PacketID: r.uint16()}
+
+	for r.offset < len(r.body) && r.err() == nil {
+		s.Topics = append(s.Topics, r.string())
+		r.byte() // Requested QoS.
+	}
+
+	return s, r.err()
+}
+
+func SubackPacket(packetID uint16, topics int) *Packet {
+	// Every subscription is granted at QoS 0.
+	return &Packet{Type: PacketSuback, Body: append(appendUint16(nil, packetID), make([]byte, topics)...)}
+}
+
+func appendString(buf []byte, s string) []byte {
+	return append(appendUint16(buf, uint16(len(s))), s...)
+}
+
+func appendUint16(buf []byte, v uint16) []byte {
+	return append(buf, byte(v>>8), byte(v))
+}
+
+// bodyReader reads fields from a packet body, reading past the end is only reported once everything's been read.
+type bodyReader struct {
+	body      []byte
+	offset    int
+	truncated bool
+}
+
+func (r *bodyReader) next(n int) []byte {
+	if r.offset+n > len(r.body) {
+		r.truncated = true
+		r.offset = len(r.body)
+
+		return make([]byte, n)
+	}
+
+	b := r.body[r.offset : r.offset+n]
+	r.offset += n
+
+	return b
+}
+
+func (r *bodyReader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *bodyReader) uint16() uint16 {
+	return binary.BigEndian.Uint16(r.next(2))
+}
+
+func (r *bodyReader) string() string {
+	return string(r.next(int(r.uint16())))
+}
+
+func (r *bodyReader) err() error {
+	if r.truncated {
+		return fmt.Errorf("%w: truncated", ErrMalformedPacket)
+	}
+
+	return nil
+}
@@ -0,0 +1,345 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// reconnectDelay is how long to wait before reconnecting to the broker after the connection is lost.
+const reconnectDelay = 10 * time.Second
+
+const (
+	availabilityOnline  = "online"
+	availabilityOffline = "offline"
+)
+
+type PublisherConfig struct {
+	Client Config
+	// Prefix is the topic the state is published under, commands are read from <prefix>/set.
+	Prefix string
+	// DiscoveryPrefix is the Home Assistant discovery prefix, discovery is disabled if it's empty.
+	DiscoveryPrefix string
+	// NodeID identifies the treadmill in discovery topics and unique ids.
+	NodeID string
+	// Name is the name of the device in Home Assistant.
+	Name string
+}
+
+// CommandHandler runs a command received on the set topic ("start" or "stop" for instance).
+type CommandHandler func(command string) error
+
+// Publisher publishes workout state to an MQTT broker and runs commands it receives, it keeps reconnecting to the
+// broker until Run's context is cancelled.
+//
+// Register Publisher.HandleSample as a sample listener on a session to publish its telemetry.
+type Publisher struct {
+	config  PublisherConfig
+	handler CommandHandler
+
+	mutex  sync.Mutex
+	client *Client
+	units  *treadonme.UnitsType
+	state  *State
+}
+
+// State is the payload published to <prefix>/state.
+type State struct {
+	Mode      string  `json:"mode"`
+	Running   string  `json:"running"`
+	Speed     float64 `json:"speed"`
+	Incline   byte    `json:"incline"`
+	HeartRate byte    `json:"heart_rate"`
+	Distance  float64 `json:"distance"`
+	Calories  uint16  `json:"calories"`
+}
+
+func NewPublisher(config PublisherConfig, handler CommandHandler) *Publisher {
+	if config.NodeID == "" {
+		config.NodeID = "treadonme"
+	}
+
+	if config.Name == "" {
+		config.Name = "Treadmill"
+	}
+
+	return &Publisher{
+		config:  config,
+		handler: handler,
+		state:   idleState(),
+	}
+}
+
+func (p *Publisher) Run(ctx context.Context) error {
+	for {
+		if err := p.connect(ctx); err != nil {
+			log.Printf("problem connecting to mqtt broker %s: %s", p.config.Client.Broker, err)
+		}
+
+		p.mutex.Lock()
+		client := p.client
+		p.mutex.Unlock()
+
+		if client != nil {
+			select {
+			case <-client.Done():
+				log.Printf("lost connection to mqtt broker %s: %s", p.config.Client.Broker, client.Err())
+			case <-ctx.Done():
+				p.disconnect(client)
+
+				return ctx.Err()
+			}
+
+			p.mutex.Lock()
+			p.client = nil
+			p.mutex.Unlock()
+		}
+
+		timer := time.NewTimer(reconnectDelay)
+
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *Publisher) connect(ctx context.Context) error {
+	client, err := Dial(ctx, p.config.Client)
+	if err != nil {
+		return err
+	}
+
+	if err := client.Subscribe(ctx, p.topic("set"), p.handleCommand); err != nil {
+		if closeErr := client.Close(); closeErr != nil {
+			log.Printf("problem closing mqtt connection after failed subscribe: %s", closeErr)
+		}
+
+		return err
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.client = client
+
+	if err := p.publishDiscovery(); err != nil {
+		return err
+	}
+
+	if err := p.publishState(); err != nil {
+		return err
+	}
+
+	return client.Publish(p.topic("availability"), []byte(availabilityOnline), true)
+}
+
+func (p *Publisher) disconnect(client *Client) {
+	if err := client.Publish(p.topic("availability"), []byte(availabilityOffline), true); err != nil {
+		log.Printf("problem publishing mqtt availability: %s", err)
+	}
+
+	if err := client.Close(); err != nil {
+		log.Printf("problem closing mqtt connection: %s", err)
+	}
+
+	p.mutex.Lock()
+	p.client = nil
+	p.mutex.Unlock()
+}
+
+// SetDeviceInfo publishes the discovery configs for the treadmill, they're only published once the units are known.
+func (p *Publisher) SetDeviceInfo(info *treadonme.MessageDeviceInfo) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	units := info.Units
+	p.units = &units
+
+	if err := p.publishDiscovery(); err != nil {
+		log.Printf("problem publishing mqtt discovery: %s", err)
+	}
+}
+
+func (p *Publisher) HandleSample(sample treadonme.Sample) {
+	running := "OFF"
+	if sample.Mode == treadonme.WorkoutModeRunning {
+		running = "ON"
+	}
+
+	p.setState(&State{
+		Mode:      sample.Mode.String(),
+		Running:   running,
+		Speed:     sample.Speed.Float(),
+		Incline:   sample.Incline,
+		HeartRate: sample.HeartRate,
+		// Distances are transmitted multiplied by one hundred.
+		Distance: float64(sample.Distance) / 100,
+		Calories: sample.Calories,
+	})
+}
+
+// Idle publishes an idle state, call it once the workout is over.
+func (p *Publisher) Idle() {
+	p.setState(idleState())
+}
+
+func (p *Publisher) setState(state *State) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.state = state
+
+	if err := p.publishState(); err != nil {
+		log.Printf("problem publishing mqtt state: %s", err)
+	}
+}
+
+func (p *Publisher) publishState() error {
+	if p.client == nil {
+		return nil
+	}
+
+	payload, err := json.Marshal(p.state)
+	if err != nil {
+		return err
+	}
+
+	return p.client.Publish(p.topic("state"), payload, true)
+}
+
+func (p *Publisher) publishDiscovery() error {
+	if p.client == nil || p.units == nil || p.config.DiscoveryPrefix == "" {
+		return nil
+	}
+
+	for _, entity := range p.entities(*p.units) {
+		payload, err := json.Marshal(entity.config)
+		if err != nil {
+			return err
+		}
+
+		topic := strings.Join([]string{p.config.DiscoveryPrefix, entity.component, p.config.NodeID, entity.objectID,
+			"config"}, "/")
+
+		if err := p.client.Publish(topic, payload, true); err != nil {
+			return fmt.Errorf("problem publishing discovery for %s: %w", entity.objectID, err)
+		}
+	}
+
+	return nil
+}
+
+func (p *Publisher) handleCommand(_ string, payload []byte) {
+	command := strings.ToLower(strings.TrimSpace(string(payload)))
+
+	// Starting a workout can take a while, don't hold up the read loop.
+	go func() {
+		if err := p.handler(command); err != nil {
+			log.Printf("problem running mqtt %s command: %s", command, err)
+		}
+	}()
+}
+
+func (p *Publisher) topic(name string) string {
+	return p.config.Prefix + "/" + name
+}
+
+type discoveryEntity struct {
+	component string
+	objectID  string
+	config    *discoveryConfig
+}
+
+type discoveryConfig struct {
+	Name              string           `json:"name"`
+	UniqueID          string           `json:"unique_id"`
+	StateTopic        string           `json:"state_topic,omitempty"`
+	CommandTopic      string           `json:"command_topic,omitempty"`
+	PayloadPress      string           `json:"payload_press,omitempty"`
+	AvailabilityTopic string           `json:"availability_topic"`
+	ValueTemplate     string           `json:"value_template,omitempty"`
+	UnitOfMeasurement string           `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string           `json:"device_class,omitempty"`
+	StateClass        string           `json:"state_class,omitempty"`
+	Icon              string           `json:"icon,omitempty"`
+	Device            *discoveryDevice `json:"device"`
+}
+
+type discoveryDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+func (p *Publisher) entities(units treadonme.UnitsType) []*discoveryEntity {
+	speedUnit, distanceUnit := "km/h", "km"
+	if units == treadonme.UnitsTypeImperial {
+		speedUnit, distanceUnit = "mph", "mi"
+	}
+
+	device := &discoveryDevice{
+		Identifiers:  []string{p.config.NodeID},
+		Name:         p.config.Name,
+		Manufacturer: "treadonme",
+	}
+
+	sensor := func(objectID, name, unit, deviceClass, icon string) *discoveryEntity {
+		return &discoveryEntity{component: "sensor", objectID: objectID, config: &discoveryConfig{
+			Name:              name,
+			UniqueID:          p.config.NodeID + "_" + objectID,
+			StateTopic:        p.topic("state"),
+			AvailabilityTopic: p.topic("availability"),
+			ValueTemplate:     "{{ value_json." + objectID + " }}",
+			UnitOfMeasurement: unit,
+			DeviceClass:       deviceClass,
+			StateClass:        "measurement",
+			Icon:              icon,
+			Device:            device,
+		}}
+	}
+
+	button := func(command, name, icon string) *discoveryEntity {
+		return &discoveryEntity{component: "button", objectID: command, config: &discoveryConfig{
+			Name:              name,
+			UniqueID:          p.config.NodeID + "_" + command,
+			CommandTopic:      p.topic("set"),
+			PayloadPress:      command,
+			AvailabilityTopic: p.topic("availability"),
+			Icon:              icon,
+			Device:            device,
+		}}
+	}
+
+	return []*discoveryEntity{
+		sensor("speed", "Speed", speedUnit, "speed", ""),
+		sensor("incline", "Incline", "", "", "mdi:slope-uphill"),
+		sensor("heart_rate", "Heart Rate", "bpm", "", "mdi:heart-pulse"),
+		sensor("distance", "Distance", distanceUnit, "distance", ""),
+		sensor("calories", "Calories", "kcal", "", "mdi:fire"),
+		{component: "binary_sensor", objectID: "running", config: &discoveryConfig{
+			Name:              "Running",
+			UniqueID:          p.config.NodeID + "_running",
+			StateTopic:        p.topic("state"),
+			AvailabilityTopic: p.topic("availability"),
+			ValueTemplate:     "{{ value_json.running }}",
+			DeviceClass:       "running",
+			Device:            device,
+		}},
+		button("start", "Start Workout", "mdi:play"),
+		button("stop", "Stop Workout", "mdi:stop"),
+	}
+}
+
+func idleState() *State {
+	return &State{Mode: treadonme.WorkoutModeIdle.String(), Running: "OFF"}
+}
@@ -0,0 +1,175 @@
+package mqtt_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/mqtt"
+)
+
+type PublisherTestSuite struct {
+	suite.Suite
+	broker    *broker
+	publisher *mqtt.Publisher
+	commands  chan string
+	cancel    context.CancelFunc
+	done      chan error
+}
+
+func (s *PublisherTestSuite) SetupTest() {
+	b, err := newBroker("", "")
+	s.Require().NoError(err)
+
+	s.broker = b
+	s.commands = make(chan string, 10)
+	s.publisher = mqtt.NewPublisher(mqtt.PublisherConfig{
+		Client:          mqtt.Config{Broker: b.addr()},
+		Prefix:          "treadonme",
+		DiscoveryPrefix: "homeassistant",
+		NodeID:          "sole",
+	}, func(command string) error {
+		s.commands <- command
+
+		return nil
+	})
+
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	s.done = make(chan error, 1)
+
+	go func() {
+		s.done <- s.publisher.Run(ctx)
+	}()
+
+	s.waitForRetained("treadonme/availability")
+}
+
+func (s *PublisherTestSuite) TearDownTest() {
+	s.cancel()
+	s.Require().ErrorIs(<-s.done, context.Canceled)
+	s.broker.close()
+}
+
+func (s *PublisherTestSuite) waitForRetained(topic string) []byte {
+	var payload []byte
+
+	s.Require().Eventually(func() bool {
+		var ok bool
+		payload, ok = s.broker.retainedMessage(topic)
+
+		return ok
+	}, receiveTimeout, 10*time.Millisecond, topic)
+
+	return payload
+}
+
+func (s *PublisherTestSuite) TestIdleState() {
+	s.Require().Equal("online", string(s.waitForRetained("treadonme/availability")))
+	s.Require().JSONEq(`{"mode":"Idle","running":"OFF","speed":0,"incline":0,"heart_rate":0,"distance":0,"calories":0}`,
+		string(s.waitForRetained("treadonme/state")))
+
+	// Discovery waits until the treadmill's units are known.
+	_, ok := s.broker.retainedMessage("homeassistant/sensor/sole/speed/config")
+	s.Require().False(ok)
+}
+
+func (s *PublisherTestSuite) TestState() {
+	s.publisher.HandleSample(treadonme.Sample{
+		Mode: treadonme.WorkoutModeRunning, Speed: 65, Incline: 3, HeartRate: 130, Distance: 250, Calories: 42,
+	})
+
+	s.Require().Eventually(func() bool {
+		payload, _ := s.broker.retainedMessage("treadonme/state")
+
+		return string(payload) ==
+			`{"mode":"Running","running":"ON","speed":6.5,"incline":3,"heart_rate":130,"distance":2.5,"calories":42}`
+	}, receiveTimeout, 10*time.Millisecond)
+
+	s.publisher.Idle()
+
+	s.Require().Eventually(func() bool {
+		payload, _ := s.broker.retainedMessage("treadonme/state")
+
+		return string(payload) ==
+			`{"mode":"Idle","running":"OFF","speed":0,"incline":0,"heart_rate":0,"distance":0,"calories":0}`
+	}, receiveTimeout, 10*time.Millisecond)
+}
+
+func (s *PublisherTestSuite) TestDiscovery() {
+	s.publisher.SetDeviceInfo(&treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial})
+
+	config := map[string]interface{}{}
+	s.Require().NoError(json.Unmarshal(s.waitForRetained("homeassistant/sensor/sole/speed/config"), &config))
+	s.Require().Equal("sole_speed", config["unique_id"])
+	s.Require().Equal("treadonme/state", config["state_topic"])
+	s.Require().Equal("treadonme/availability", config["availability_topic"])
+	s.Require().Equal("{{ value_json.speed }}", config["value_template"])
+	s.Require().Equal("mph", config["unit_of_measurement"])
+	s.Require().Equal([]interface{}{"sole"}, config["device"].(map[string]interface{})["identifiers"])
+
+	s.Require().NoError(json.Unmarshal(s.waitForRetained("homeassistant/sensor/sole/distance/config"), &config))
+	s.Require().Equal("mi", config["unit_of_measurement"])
+
+	for _, object := range []string{"incline", "heart_rate", "calories"} {
+		s.waitForRetained("homeassistant/sensor/sole/" + object + "/config")
+	}
+
+	running := map[string]interface{}{}
+	s.Require().NoError(json.Unmarshal(s.waitForRetained("homeassistant/binary_sensor/sole/running/config"), &running))
+	s.Require().Equal("{{ value_json.running }}", running["value_template"])
+
+	start := map[string]interface{}{}
+	s.Require().NoError(json.Unmarshal(s.waitForRetained("homeassistant/button/sole/start/config"), &start))
+	s.Require().Equal("treadonme/set", start["command_topic"])
+	s.Require().Equal("start", start["payload_press"])
+	s.waitForRetained("homeassistant/button/sole/stop/config")
+}
+
+func (s *PublisherTestSuite) TestCommands() {
+	client, err := mqtt.Dial(context.Background(), mqtt.Config{Broker: s.broker.addr()})
+	s.Require().NoError(err)
+
+	defer client.Close()
+
+	for _, command := range []string{"start", " STOP\n"} {
+		s.Require().NoError(client.Publish("treadonme/set", []byte(command), false))
+	}
+
+	// Commands run concurrently so they can arrive in either order.
+	received := map[string]bool{}
+
+	for len(received) < 2 {
+		select {
+		case command := <-s.commands:
+			received[command] = true
+		case <-time.After(receiveTimeout):
+			s.FailNow("timed out waiting for command")
+		}
+	}
+
+	s.Require().Equal(map[string]bool{"start": true, "stop": true}, received)
+}
+
+func (s *PublisherTestSuite) TestOfflineOnShutdown() {
+	s.cancel()
+	s.Require().ErrorIs(<-s.done, context.Canceled)
+
+	s.Require().Eventually(func() bool {
+		payload, _ := s.broker.retainedMessage("treadonme/availability")
+
+		return string(payload) == "offline"
+	}, receiveTimeout, 10*time.Millisecond)
+
+	// TearDownTest waits for Run to return again.
+	s.done <- context.Canceled
+}
+
+func TestPublisherTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &PublisherTestSuite{})
+}
@@ -0,0 +1,381 @@
+package treadonme
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ErrInvalidPlan    = fmt.Errorf("invalid workout plan")
+	ErrPlanOverridden = fmt.Errorf("workout plan was overridden")
+	// ErrPlanSpeedOutOfRange is returned for plan speeds over 25.5 once converted to the treadmill's units.
+	ErrPlanSpeedOutOfRange = fmt.Errorf("%w: speed out of range", ErrInvalidPlan)
+)
+
+// Plan is a structured workout defined as a list of steps, steps can be grouped and repeated. Plans are usually
+// written in YAML or JSON:
+//
+//	name: Intervals
+//	units: imperial
+//	steps:
+//	  - name: warmup
+//	    duration: 5m
+//	    speed: 3.0
+//	  - repeat: 8
+//	    steps:
+//	      - {name: fast, duration: 1m, speed: 7.5, incline: 2}
+//	      - {name: slow, duration: 1m, speed: 4.0, incline: 0}
+//	  - name: cooldown
+//	    duration: 5m
+//	    speed: 2.5
+type Plan struct {
+	Name string `yaml:"name"`
+	// Units the speeds in the plan are given in, defaults to the units of the treadmill.
+	Units string     `yaml:"units"`
+	Steps []PlanStep `yaml:"steps"`
+}
+
+// PlanStep is either a single step with a duration and an optional speed and incline (left unchanged when omitted),
+// or a group of steps that is repeated.
+type PlanStep struct {
+	Name     string        `yaml:"name"`
+	Duration time.Duration `yaml:"duration"`
+	Speed    *float64      `yaml:"speed"`
+	Incline  *byte         `yaml:"incline"`
+	Repeat   int           `yaml:"repeat"`
+	Steps    []PlanStep    `yaml:"steps"`
+}
+
+// PlanSegment is a single step of a plan after repeats have been expanded, with speeds in the treadmill's units.
+type PlanSegment struct {
+	Name     string
+	Duration time.Duration
+	Speed    *Speed
+	Incline  *byte
+}
+
+// ParsePlan parses a plan in either YAML or JSON (which is also valid YAML).
+func ParsePlan(data []byte) (*Plan, error) {
+	plan := &Plan{}
+	if err := yaml.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidPlan, err)
+	}
+
+	if err := plan.Validate(); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
+
+func (p *Plan) Validate() error {
+	if p.Units != "" {
+		if _, err := ParseUnitsType(p.Units); err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPlan, err)
+		}
+	}
+
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("%w: no steps", ErrInvalidPlan)
+	}
+
+	return validateSteps(p.Steps, "steps")
+}
+
+func validateSteps(steps []PlanStep, path string) error {
+	for idx, step := range steps {
+		stepPath := fmt.Sprintf("%s[%d]", path, idx)
+
+		switch {
+		case len(step.Steps) > 0:
+			if step.Duration != 0 || step.Speed != nil || step.Incline != nil {
+				return fmt.Errorf("%w: %s: a group of steps can't have a duration, speed or incline", ErrInvalidPlan, stepPath)
+			} else if step.Repeat < 0 {
+				return fmt.Errorf("%w: %s: repeat can't be negative", ErrInvalidPlan, stepPath)
+			} else if err := validateSteps(step.Steps, stepPath+".steps"); err != nil {
+				return err
+			}
+		case step.Repeat != 0:
+			return fmt.Errorf("%w: %s: only a group of steps can be repeated", ErrInvalidPlan, stepPath)
+		case step.Duration <= 0:
+			return fmt.Errorf("%w: %s: a duration is required", ErrInvalidPlan, stepPath)
+		case step.Speed != nil && *step.Speed <= 0:
+			return fmt.Errorf("%w: %s: speed must be positive", ErrInvalidPlan, stepPath)
+		}
+	}
+
+	return nil
+}
+
+// Segments expands the plan into the segments that will be executed, speeds are converted into the given units.
+func (p *Plan) Segments(units UnitsType) ([]PlanSegment, error) {
+	from := units
+
+	if p.Units != "" {
+		var err error
+		if from, err = ParseUnitsType(p.Units); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidPlan, err)
+		}
+	}
+
+	return appendSegments(nil, p.Steps, "", from, units)
+}
+
+func (p *Plan) Duration() time.Duration {
+	return stepsDuration(p.Steps)
+}
+
+func stepsDuration(steps []PlanStep) time.Duration {
+	var total time.Duration
+
+	for _, step := range steps {
+		if len(step.Steps) == 0 {
+			total += step.Duration
+
+			continue
+		}
+
+		repeat := step.Repeat
+		if repeat == 0 {
+			repeat = 1
+		}
+
+		total += time.Duration(repeat) * stepsDuration(step.Steps)
+	}
+
+	return total
+}
+
+func appendSegments(
+	segments []PlanSegment, steps []PlanStep, prefix string, from, to UnitsType,
+) ([]PlanSegment, error) {
+	for _, step := range steps {
+		if len(step.Steps) == 0 {
+			seg := PlanSegment{Name: joinName(prefix, step.Name), Duration: step.Duration, Incline: step.Incline}
+
+			if step.Speed != nil {
+				// Speeds are transmitted multiplied by ten.
+				converted := math.Round(from.Convert(*step.Speed, to) * 10)
+				if converted > math.MaxUint8 {
+					return nil, fmt.Errorf("%w: %q: %.1f is too fast to send to the treadmill",
+						ErrPlanSpeedOutOfRange, seg.Name, *step.Speed)
+				}
+
+				speed := Speed(converted)
+				seg.Speed = &speed
+			}
+
+			segments = append(segments, seg)
+
+			continue
+		}
+
+		repeat := step.Repeat
+		if repeat == 0 {
+			repeat = 1
+		}
+
+		for idx := 0; idx < repeat; idx++ {
+			name := joinName(prefix, step.Name)
+			if repeat > 1 {
+				name = joinName(name, fmt.Sprintf("%d/%d", idx+1, repeat))
+			}
+
+			var err error
+			if segments, err = appendSegments(segments, step.Steps, name, from, to); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return segments, nil
+}
+
+func joinName(prefix, name string) string {
+	switch {
+	case prefix == "":
+		return name
+	case name == "":
+		return prefix
+	default:
+		return prefix + " " + name
+	}
+}
+
+type PlanEventType byte
+
+const (
+	PlanEventStepStarted PlanEventType = iota
+	PlanEventCountdown
+	PlanEventFinished
+	PlanEventAborted
+)
+
+func (pet PlanEventType) String() string {
+	switch pet {
+	case PlanEventStepStarted:
+		return "StepStarted"
+	case PlanEventCountdown:
+		return "Countdown"
+	case PlanEventFinished:
+		return "Finished"
+	case PlanEventAborted:
+		return "Aborted"
+	default:
+		return "Unknown"
+	}
+}
+
+// PlanEvent is emitted by the plan executor when a step starts, periodically with the time remaining in the step and
+// once the plan is finished or aborted.
+type PlanEvent struct {
+	Type      PlanEventType
+	Step      int
+	Steps     int
+	Segment   PlanSegment
+	Remaining time.Duration
+	Err       error
+}
+
+type PlanListener func(PlanEvent)
+
+// PlanExecutor drives a treadmill through a plan from the host. If the runner overrides the plan from the console
+// (changes the speed or incline, pauses or stops) the executor stops driving the treadmill and leaves it as it is.
+type PlanExecutor struct {
+	// TickInterval is how often countdown events are emitted and the treadmill is checked for overrides.
+	TickInterval time.Duration
+
+	equipment Controller
+	plan      *Plan
+	clock     Clock
+
+	mutex     sync.Mutex
+	listeners []PlanListener
+}
+
+func NewPlanExecutor(equipment Controller, plan *Plan) *PlanExecutor {
+	return &PlanExecutor{
+		TickInterval: time.Second,
+		equipment:    equipment,
+		plan:         plan,
+		clock:        clockOf(equipment),
+	}
+}
+
+// SetClock replaces the clock segments are timed with, it defaults to the treadmill's and should be called before
+// running.
+func (pe *PlanExecutor) SetClock(clock Clock) {
+	pe.clock = clock
+}
+
+func (pe *PlanExecutor) AddListener(listener PlanListener) {
+	pe.mutex.Lock()
+	defer pe.mutex.Unlock()
+
+	pe.listeners = append(pe.listeners, listener)
+}
+
+// Run executes the plan, it blocks until the plan is finished, the context is cancelled or the plan is overridden.
+func (pe *PlanExecutor) Run(ctx context.Context) error {
+	info := pe.equipment.DeviceInfo()
+	if info == nil {
+		return fmt.Errorf("%w: device info hasn't been reported", ErrUnknownCurrentValue)
+	}
+
+	segments, err := pe.plan.Segments(info.Units)
+	if err != nil {
+		return err
+	}
+
+	for idx, seg := range segments {
+		if err := pe.runSegment(ctx, idx, len(segments), seg); err != nil {
+			pe.emit(PlanEvent{Type: PlanEventAborted, Step: idx, Steps: len(segments), Segment: seg, Err: err})
+
+			return err
+		}
+	}
+
+	pe.emit(PlanEvent{Type: PlanEventFinished, Step: len(segments), Steps: len(segments)})
+
+	return nil
+}
+
+func (pe *PlanExecutor) runSegment(ctx context.Context, idx, total int, seg PlanSegment) error {
+	// The time it takes to step to the new targets counts towards the segment.
+	deadline := pe.clock.Now().Add(seg.Duration)
+
+	pe.emit(PlanEvent{Type: PlanEventStepStarted, Step: idx, Steps: total, Segment: seg, Remaining: seg.Duration})
+
+	if seg.Speed != nil {
+		if err := pe.equipment.SetTargetSpeed(ctx, *seg.Speed); err != nil {
+			return overridden(err)
+		}
+	}
+
+	if seg.Incline != nil {
+		if err := pe.equipment.SetTargetIncline(ctx, *seg.Incline); err != nil {
+			return overridden(err)
+		}
+	}
+
+	for {
+		remaining := deadline.Sub(pe.clock.Now())
+		if remaining <= 0 {
+			return nil
+		}
+
+		if err := pe.checkOverride(seg); err != nil {
+			return err
+		}
+
+		pe.emit(PlanEvent{Type: PlanEventCountdown, Step: idx, Steps: total, Segment: seg, Remaining: remaining})
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-pe.clock.After(pe.TickInterval):
+		}
+	}
+}
+
+func (pe *PlanExecutor) checkOverride(seg PlanSegment) error {
+	switch mode := pe.equipment.CurrentMode(); mode {
+	case WorkoutModeIdle, WorkoutModePause, WorkoutModeDone:
+		return fmt.Errorf("%w: workout mode changed to %s", ErrPlanOverridden, mode)
+	}
+
+	if speed, ok := pe.equipment.CurrentSpeed(); ok && seg.Speed != nil && speed != *seg.Speed {
+		return fmt.Errorf("%w: speed changed to %d, expected %d", ErrPlanOverridden, speed, *seg.Speed)
+	}
+
+	if incline, ok := pe.equipment.CurrentIncline(); ok && seg.Incline != nil && incline != *seg.Incline {
+		return fmt.Errorf("%w: incline changed to %d, expected %d", ErrPlanOverridden, incline, *seg.Incline)
+	}
+
+	return nil
+}
+
+func (pe *PlanExecutor) emit(event PlanEvent) {
+	pe.mutex.Lock()
+	listeners := pe.listeners
+	pe.mutex.Unlock()
+
+	for _, l := range listeners {
+		l(event)
+	}
+}
+
+// overridden wraps errors caused by the runner fighting the plan so callers can tell them apart.
+func overridden(err error) error {
+	if errors.Is(err, ErrTargetInterrupted) {
+		return fmt.Errorf("%w: %s", ErrPlanOverridden, err)
+	}
+
+	return err
+}
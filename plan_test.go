@@ -0,0 +1,223 @@
+package treadonme_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+type fakeController struct {
+	mutex   sync.Mutex
+	info    *treadonme.MessageDeviceInfo
+	mode    treadonme.WorkoutMode
+	speed   treadonme.Speed
+	incline byte
+	speeds  []treadonme.Speed
+}
+
+func (fc *fakeController) DeviceInfo() *treadonme.MessageDeviceInfo {
+	return fc.info
+}
+
+func (fc *fakeController) CurrentMode() treadonme.WorkoutMode {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.mode
+}
+
+func (fc *fakeController) CurrentSpeed() (treadonme.Speed, bool) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.speed, true
+}
+
+func (fc *fakeController) CurrentIncline() (byte, bool) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	return fc.incline, true
+}
+
+func (fc *fakeController) SetTargetSpeed(_ context.Context, speed treadonme.Speed) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.speed = speed
+	fc.speeds = append(fc.speeds, speed)
+
+	return nil
+}
+
+func (fc *fakeController) SetTargetIncline(_ context.Context, incline byte) error {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.incline = incline
+
+	return nil
+}
+
+func (fc *fakeController) setSpeed(speed treadonme.Speed) {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+
+	fc.speed = speed
+}
+
+type PlanTestSuite struct {
+	suite.Suite
+}
+
+const intervalPlan = `
+name: Intervals
+units: imperial
+steps:
+  - name: warmup
+    duration: 5m
+    speed: 3.0
+  - repeat: 8
+    steps:
+      - {name: fast, duration: 1m, speed: 7.5, incline: 2}
+      - {name: slow, duration: 1m, speed: 4.0, incline: 0}
+  - name: cooldown
+    duration: 5m
+`
+
+func (s *PlanTestSuite) TestParseYAML() {
+	plan, err := treadonme.ParsePlan([]byte(intervalPlan))
+	s.Require().NoError(err)
+	s.Require().Equal("Intervals", plan.Name)
+	s.Require().Equal(26*time.Minute, plan.Duration())
+
+	segments, err := plan.Segments(treadonme.UnitsTypeImperial)
+	s.Require().NoError(err)
+	s.Require().Len(segments, 18)
+	s.Require().Equal("warmup", segments[0].Name)
+	s.Require().Equal(treadonme.Speed(30), *segments[0].Speed)
+	s.Require().Nil(segments[0].Incline)
+	s.Require().Equal("1/8 fast", segments[1].Name)
+	s.Require().Equal(treadonme.Speed(75), *segments[1].Speed)
+	s.Require().Equal(byte(2), *segments[1].Incline)
+	s.Require().Equal("8/8 slow", segments[16].Name)
+	s.Require().Nil(segments[17].Speed)
+}
+
+func (s *PlanTestSuite) TestParseJSONMetric() {
+	plan, err := treadonme.ParsePlan([]byte(`{"units": "metric", "steps": [{"duration": "30s", "speed": 10}]}`))
+	s.Require().NoError(err)
+
+	segments, err := plan.Segments(treadonme.UnitsTypeImperial)
+	s.Require().NoError(err)
+	s.Require().Len(segments, 1)
+	s.Require().Equal(30*time.Second, segments[0].Duration)
+	s.Require().Equal(treadonme.Speed(62), *segments[0].Speed)
+}
+
+func (s *PlanTestSuite) TestParseInvalid() {
+	for _, plan := range []string{
+		`steps: []`,
+		`steps: [{speed: 3}]`,
+		`steps: [{duration: 1m, repeat: 2}]`,
+		`steps: [{duration: 1m, speed: -1}]`,
+		`steps: [{duration: 1m, steps: [{duration: 1m}]}]`,
+		`{units: furlongs, steps: [{duration: 1m}]}`,
+	} {
+		_, err := treadonme.ParsePlan([]byte(plan))
+		s.Require().ErrorIs(err, treadonme.ErrInvalidPlan, plan)
+	}
+}
+
+func (s *PlanTestSuite) TestSpeedOutOfRange() {
+	plan, err := treadonme.ParsePlan([]byte(`{units: imperial, steps: [{duration: 1m, speed: 20}]}`))
+	s.Require().NoError(err)
+	s.Require().Equal(time.Minute, plan.Duration())
+
+	// 20 mph is 32.2 km/h, which would otherwise wrap around to 6.6 km/h.
+	_, err = plan.Segments(treadonme.UnitsTypeImperial)
+	s.Require().NoError(err)
+	_, err = plan.Segments(treadonme.UnitsTypeMetric)
+	s.Require().ErrorIs(err, treadonme.ErrPlanSpeedOutOfRange)
+	s.Require().ErrorIs(err, treadonme.ErrInvalidPlan)
+}
+
+func (s *PlanTestSuite) TestRun() {
+	plan, err := treadonme.ParsePlan([]byte(`steps: [{repeat: 2, steps: [{duration: 30ms, speed: 5}, {duration: 30ms, speed: 3}]}]`))
+	s.Require().NoError(err)
+
+	ctrl := &fakeController{
+		info: &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial},
+		mode: treadonme.WorkoutModeRunning,
+	}
+
+	var events []treadonme.PlanEvent
+
+	executor := treadonme.NewPlanExecutor(ctrl, plan)
+	executor.TickInterval = 5 * time.Millisecond
+	executor.AddListener(func(event treadonme.PlanEvent) {
+		events = append(events, event)
+	})
+
+	s.Require().NoError(executor.Run(context.Background()))
+	s.Require().Equal([]treadonme.Speed{50, 30, 50, 30}, ctrl.speeds)
+
+	started := 0
+
+	for _, event := range events {
+		if event.Type == treadonme.PlanEventStepStarted {
+			s.Require().Equal(started, event.Step)
+			s.Require().Equal(4, event.Steps)
+			started++
+		}
+	}
+
+	s.Require().Equal(4, started)
+	s.Require().Equal(treadonme.PlanEventFinished, events[len(events)-1].Type)
+}
+
+func (s *PlanTestSuite) TestRunOverridden() {
+	plan, err := treadonme.ParsePlan([]byte(`steps: [{duration: 1h, speed: 5}]`))
+	s.Require().NoError(err)
+
+	ctrl := &fakeController{
+		info: &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial},
+		mode: treadonme.WorkoutModeRunning,
+	}
+
+	executor := treadonme.NewPlanExecutor(ctrl, plan)
+	executor.TickInterval = 5 * time.Millisecond
+	executor.AddListener(func(event treadonme.PlanEvent) {
+		// Simulate the runner turning the speed down from the console.
+		if event.Type == treadonme.PlanEventCountdown {
+			ctrl.setSpeed(40)
+		}
+	})
+
+	s.Require().ErrorIs(executor.Run(context.Background()), treadonme.ErrPlanOverridden)
+}
+
+func (s *PlanTestSuite) TestRunCancelled() {
+	plan, err := treadonme.ParsePlan([]byte(`steps: [{duration: 1h, speed: 5}]`))
+	s.Require().NoError(err)
+
+	ctrl := &fakeController{
+		info: &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial},
+		mode: treadonme.WorkoutModeRunning,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	s.Require().ErrorIs(treadonme.NewPlanExecutor(ctrl, plan).Run(ctx), context.DeadlineExceeded)
+}
+
+func TestPlanTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &PlanTestSuite{})
+}
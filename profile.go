@@ -0,0 +1,95 @@
+package treadonme
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidProfile = fmt.Errorf("invalid user profile")
+
+// DefaultUserProfile is sent when starting a workout if no other profile has been set.
+var DefaultUserProfile = MessageUserProfile{Sex: SexTypeMale, Age: 30, Weight: 155, Height: 72}
+
+const (
+	centimetersPerInch = 2.54
+	kilogramsPerPound  = 0.45359237
+)
+
+var validProfileName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Profile is a runner. The treadmill uses the sex, age, weight and height to estimate the calories burned. Weights and
+// heights are in the profile's preferred units: kilograms and centimeters for metric, pounds and inches for imperial.
+type Profile struct {
+	Name   string    `json:"name"`
+	Sex    SexType   `json:"sex"`
+	Age    byte      `json:"age"`
+	Weight float64   `json:"weight"`
+	Height float64   `json:"height"`
+	Units  UnitsType `json:"units"`
+}
+
+func (p *Profile) Validate() error {
+	switch {
+	case !validProfileName.MatchString(p.Name):
+		return fmt.Errorf("%w: name %q must only contain letters, numbers, dashes and underscores", ErrInvalidProfile,
+			p.Name)
+	case p.Sex != SexTypeMale && p.Sex != SexTypeFemale:
+		return fmt.Errorf("%w: unknown sex %d", ErrInvalidProfile, p.Sex)
+	case p.Units != UnitsTypeMetric && p.Units != UnitsTypeImperial:
+		return fmt.Errorf("%w: unknown units %d", ErrInvalidProfile, p.Units)
+	case p.Age == 0:
+		return fmt.Errorf("%w: age is required", ErrInvalidProfile)
+	case p.Weight <= 0 || p.Height <= 0:
+		return fmt.Errorf("%w: weight and height are required", ErrInvalidProfile)
+	}
+
+	// Heights are sent in a single byte so 255cm is the tallest that fits.
+	if p.convertHeight(UnitsTypeMetric) > math.MaxUint8 {
+		return fmt.Errorf("%w: height is too large", ErrInvalidProfile)
+	}
+
+	return nil
+}
+
+// UserProfile converts the profile into the message sent to the treadmill, in the treadmill's units.
+func (p *Profile) UserProfile(units UnitsType) *MessageUserProfile {
+	weight := p.Weight
+	if p.Units != units {
+		if units == UnitsTypeMetric {
+			weight *= kilogramsPerPound
+		} else {
+			weight /= kilogramsPerPound
+		}
+	}
+
+	return &MessageUserProfile{
+		Sex:    p.Sex,
+		Age:    p.Age,
+		Weight: Weight(math.Min(math.Round(weight), math.MaxUint16)),
+		Height: Height(math.Min(math.Round(p.convertHeight(units)), math.MaxUint8)),
+	}
+}
+
+func (p *Profile) convertHeight(units UnitsType) float64 {
+	switch {
+	case p.Units == UnitsTypeImperial && units == UnitsTypeMetric:
+		return p.Height * centimetersPerInch
+	case p.Units == UnitsTypeMetric && units == UnitsTypeImperial:
+		return p.Height / centimetersPerInch
+	default:
+		return p.Height
+	}
+}
+
+func ParseSexType(sex string) (SexType, error) {
+	switch strings.ToLower(sex) {
+	case "male":
+		return SexTypeMale, nil
+	case "female":
+		return SexTypeFemale, nil
+	default:
+		return 0, fmt.Errorf("%w: unknown sex %q", ErrInvalidProfile, sex)
+	}
+}
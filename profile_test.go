@@ -0,0 +1,77 @@
+package treadonme_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+type ProfileTestSuite struct {
+	suite.Suite
+}
+
+func (s *ProfileTestSuite) TestUserProfile() {
+	metric := &treadonme.Profile{
+		Name:   "sam",
+		Sex:    treadonme.SexTypeFemale,
+		Age:    35,
+		Weight: 60,
+		Height: 170,
+		Units:  treadonme.UnitsTypeMetric,
+	}
+	s.Require().NoError(metric.Validate())
+
+	s.Require().Equal(&treadonme.MessageUserProfile{Sex: treadonme.SexTypeFemale, Age: 35, Weight: 60, Height: 170},
+		metric.UserProfile(treadonme.UnitsTypeMetric))
+	s.Require().Equal(&treadonme.MessageUserProfile{Sex: treadonme.SexTypeFemale, Age: 35, Weight: 132, Height: 67},
+		metric.UserProfile(treadonme.UnitsTypeImperial))
+
+	imperial := &treadonme.Profile{
+		Name:   "alex",
+		Sex:    treadonme.SexTypeMale,
+		Age:    30,
+		Weight: 155,
+		Height: 72,
+		Units:  treadonme.UnitsTypeImperial,
+	}
+	s.Require().Equal(&treadonme.DefaultUserProfile, imperial.UserProfile(treadonme.UnitsTypeImperial))
+	s.Require().Equal(&treadonme.MessageUserProfile{Sex: treadonme.SexTypeMale, Age: 30, Weight: 70, Height: 183},
+		imperial.UserProfile(treadonme.UnitsTypeMetric))
+}
+
+func (s *ProfileTestSuite) TestValidate() {
+	valid := func() *treadonme.Profile {
+		return &treadonme.Profile{
+			Name: "sam", Sex: treadonme.SexTypeMale, Age: 30, Weight: 80, Height: 180, Units: treadonme.UnitsTypeMetric,
+		}
+	}
+
+	for name, modify := range map[string]func(p *treadonme.Profile){
+		"name":   func(p *treadonme.Profile) { p.Name = "sam smith" },
+		"sex":    func(p *treadonme.Profile) { p.Sex = 0 },
+		"units":  func(p *treadonme.Profile) { p.Units = 5 },
+		"age":    func(p *treadonme.Profile) { p.Age = 0 },
+		"weight": func(p *treadonme.Profile) { p.Weight = 0 },
+		"height": func(p *treadonme.Profile) { p.Height = 300 },
+	} {
+		p := valid()
+		modify(p)
+		s.Require().ErrorIs(p.Validate(), treadonme.ErrInvalidProfile, name)
+	}
+}
+
+func (s *ProfileTestSuite) TestParseSexType() {
+	sex, err := treadonme.ParseSexType("Female")
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.SexTypeFemale, sex)
+
+	_, err = treadonme.ParseSexType("other")
+	s.Require().ErrorIs(err, treadonme.ErrInvalidProfile)
+}
+
+func TestProfileTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &ProfileTestSuite{})
+}
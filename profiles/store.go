@@ -0,0 +1,185 @@
+// Package profiles persists the profiles of the people using the treadmill.
+package profiles
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+var (
+	ErrNotFound = fmt.Errorf("profile not found")
+	ErrExists   = fmt.Errorf("profile already exists")
+)
+
+// Store keeps every profile in a single JSON file.
+type Store struct {
+	path     string
+	mutex    sync.RWMutex
+	profiles map[string]*treadonme.Profile
+}
+
+func Open(path string) (*Store, error) {
+	s := &Store{path: path, profiles: map[string]*treadonme.Profile{}}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("problem reading profiles: %w", err)
+	}
+
+	var profiles []*treadonme.Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("problem decoding profiles: %w", err)
+	}
+
+	for _, p := range profiles {
+		s.profiles[p.Name] = p
+	}
+
+	return s, nil
+}
+
+// List returns every profile ordered by name.
+func (s *Store) List() []*treadonme.Profile {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return s.sorted()
+}
+
+func (s *Store) Get(name string) (*treadonme.Profile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	p, ok := s.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	profile := *p
+
+	return &profile, nil
+}
+
+func (s *Store) Create(p *treadonme.Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.profiles[p.Name]; ok {
+		return fmt.Errorf("%w: %s", ErrExists, p.Name)
+	}
+
+	return s.put(p)
+}
+
+func (s *Store) Update(p *treadonme.Profile) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.profiles[p.Name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, p.Name)
+	}
+
+	return s.put(p)
+}
+
+func (s *Store) Delete(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	p, ok := s.profiles[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+
+	delete(s.profiles, name)
+
+	if err := s.save(); err != nil {
+		s.profiles[name] = p
+
+		return err
+	}
+
+	return nil
+}
+
+// put stores a copy of the profile and saves, the change is undone if it can't be saved.
+func (s *Store) put(p *treadonme.Profile) error {
+	previous, existed := s.profiles[p.Name]
+
+	profile := *p
+	s.profiles[p.Name] = &profile
+
+	if err := s.save(); err != nil {
+		if existed {
+			s.profiles[p.Name] = previous
+		} else {
+			delete(s.profiles, p.Name)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) save() error {
+	data, err := json.MarshalIndent(s.sorted(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("problem saving profiles: %w", err)
+	}
+
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+
+		return fmt.Errorf("problem saving profiles: %w", err)
+	}
+
+	if err := f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+
+		return fmt.Errorf("problem saving profiles: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return fmt.Errorf("problem saving profiles: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Store) sorted() []*treadonme.Profile {
+	profiles := make([]*treadonme.Profile, 0, len(s.profiles))
+	for _, p := range s.profiles {
+		profile := *p
+		profiles = append(profiles, &profile)
+	}
+
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].Name < profiles[j].Name
+	})
+
+	return profiles
+}
@@ -0,0 +1,101 @@
+package profiles_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/profiles"
+)
+
+type StoreTestSuite struct {
+	suite.Suite
+
+	path  string
+	store *profiles.Store
+}
+
+func (s *StoreTestSuite) SetupTest() {
+	s.path = filepath.Join(s.T().TempDir(), "profiles.json")
+
+	store, err := profiles.Open(s.path)
+	s.Require().NoError(err)
+
+	s.store = store
+}
+
+func profile(name string) *treadonme.Profile {
+	return &treadonme.Profile{
+		Name:   name,
+		Sex:    treadonme.SexTypeFemale,
+		Age:    35,
+		Weight: 60,
+		Height: 170,
+		Units:  treadonme.UnitsTypeMetric,
+	}
+}
+
+func (s *StoreTestSuite) TestCRUD() {
+	s.Require().NoError(s.store.Create(profile("sam")))
+	s.Require().NoError(s.store.Create(profile("alex")))
+	s.Require().ErrorIs(s.store.Create(profile("sam")), profiles.ErrExists)
+
+	updated := profile("sam")
+	updated.Weight = 62
+	s.Require().NoError(s.store.Update(updated))
+	s.Require().ErrorIs(s.store.Update(profile("nobody")), profiles.ErrNotFound)
+
+	p, err := s.store.Get("sam")
+	s.Require().NoError(err)
+	s.Require().Equal(updated, p)
+
+	list := s.store.List()
+	s.Require().Len(list, 2)
+	s.Require().Equal("alex", list[0].Name)
+	s.Require().Equal("sam", list[1].Name)
+
+	s.Require().NoError(s.store.Delete("alex"))
+	s.Require().ErrorIs(s.store.Delete("alex"), profiles.ErrNotFound)
+
+	_, err = s.store.Get("alex")
+	s.Require().ErrorIs(err, profiles.ErrNotFound)
+}
+
+func (s *StoreTestSuite) TestPersisted() {
+	s.Require().NoError(s.store.Create(profile("sam")))
+
+	reopened, err := profiles.Open(s.path)
+	s.Require().NoError(err)
+
+	p, err := reopened.Get("sam")
+	s.Require().NoError(err)
+	s.Require().Equal(profile("sam"), p)
+}
+
+func (s *StoreTestSuite) TestInvalid() {
+	invalid := profile("sam")
+	invalid.Age = 0
+	s.Require().ErrorIs(s.store.Create(invalid), treadonme.ErrInvalidProfile)
+	s.Require().Empty(s.store.List())
+}
+
+func (s *StoreTestSuite) TestCopies() {
+	s.Require().NoError(s.store.Create(profile("sam")))
+
+	p, err := s.store.Get("sam")
+	s.Require().NoError(err)
+
+	// Changing a returned profile doesn't change the stored one.
+	p.Age = 99
+
+	p, err = s.store.Get("sam")
+	s.Require().NoError(err)
+	s.Require().Equal(byte(35), p.Age)
+}
+
+func TestStoreTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &StoreTestSuite{})
+}
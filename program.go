@@ -0,0 +1,135 @@
+package treadonme
+
+import (
+	"sync"
+	"time"
+)
+
+// ProgramProfile is the shape of the program being run along with where the runner currently is in it.
+type ProgramProfile struct {
+	// Levels is the relative intensity of every segment of the program as drawn on the console.
+	Levels []byte
+	// Segment is the (zero based) index of the segment currently being run.
+	Segment int
+	// Row is the level of the current segment reported by the console.
+	Row byte
+	// SegmentRemaining is the time left in the current segment, zero if it can't be worked out.
+	SegmentRemaining time.Duration
+}
+
+type ProgramProfileListener func(ProgramProfile)
+
+// ProgramTracker decodes program graphics and workout data into a ProgramProfile. Register
+// ProgramTracker.HandleMessage as a listener on a treadmill.
+//
+// The workout data reports the time remaining in the workout, the segments split the workout time evenly so the
+// total time is needed to work out the time remaining in a segment. It's taken from the first workout data message
+// rounded up to the minute since workouts are always set in whole minutes.
+type ProgramTracker struct {
+	mutex     sync.Mutex
+	levels    []byte
+	total     time.Duration
+	profile   *ProgramProfile
+	listeners []ProgramProfileListener
+}
+
+func NewProgramTracker() *ProgramTracker {
+	return &ProgramTracker{}
+}
+
+func (pt *ProgramTracker) AddListener(listener ProgramProfileListener) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	pt.listeners = append(pt.listeners, listener)
+}
+
+// Profile returns the current program profile, false is returned if the treadmill hasn't sent the program graphics.
+func (pt *ProgramTracker) Profile() (ProgramProfile, bool) {
+	pt.mutex.Lock()
+	defer pt.mutex.Unlock()
+
+	if pt.profile == nil {
+		return ProgramProfile{}, false
+	}
+
+	return pt.copyProfile(), true
+}
+
+func (pt *ProgramTracker) HandleMessage(msg Message, err error) {
+	if err != nil {
+		return
+	}
+
+	pt.mutex.Lock()
+
+	switch v := msg.(type) {
+	case *MessageProgramGraphics:
+		pt.levels = v.Levels()
+
+		if pt.profile == nil {
+			pt.profile = &ProgramProfile{}
+		}
+
+		pt.profile.Levels = pt.levels
+	case *MessageWorkoutData:
+		if pt.profile == nil {
+			pt.mutex.Unlock()
+
+			return
+		}
+
+		pt.update(v)
+	default:
+		pt.mutex.Unlock()
+
+		return
+	}
+
+	profile := pt.copyProfile()
+	listeners := pt.listeners
+	pt.mutex.Unlock()
+
+	for _, l := range listeners {
+		l(profile)
+	}
+}
+
+func (pt *ProgramTracker) update(data *MessageWorkoutData) {
+	remaining := time.Duration(data.Minute)*time.Minute + time.Duration(data.Second)*time.Second
+	if pt.total == 0 || remaining > pt.total {
+		pt.total = (remaining + time.Minute - 1).Truncate(time.Minute)
+	}
+
+	segments := len(pt.levels)
+	segmentLength := pt.total / time.Duration(segments)
+
+	// The column is reported one based, fall back to working it out from the time if it's missing.
+	segment := int(data.ProgramColumn) - 1
+	if segment < 0 && segmentLength > 0 {
+		segment = int((pt.total - remaining) / segmentLength)
+	}
+
+	if segment < 0 {
+		segment = 0
+	} else if segment >= segments {
+		segment = segments - 1
+	}
+
+	pt.profile.Segment = segment
+	pt.profile.Row = data.ProgramRow
+	pt.profile.SegmentRemaining = 0
+
+	// Segments are laid out from the start of the workout so the ones after the current one account for the rest of
+	// the remaining time.
+	if after := time.Duration(segments-segment-1) * segmentLength; remaining > after {
+		pt.profile.SegmentRemaining = remaining - after
+	}
+}
+
+func (pt *ProgramTracker) copyProfile() ProgramProfile {
+	profile := *pt.profile
+	profile.Levels = append([]byte(nil), pt.profile.Levels...)
+
+	return profile
+}
@@ -0,0 +1,78 @@
+package treadonme_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+type ProgramTestSuite struct {
+	suite.Suite
+}
+
+func (s *ProgramTestSuite) graphics() *treadonme.MessageProgramGraphics {
+	graphics := &treadonme.MessageProgramGraphics{}
+	for idx := range graphics.Graph {
+		graphics.Graph[idx] = byte(idx%4 + 1)
+	}
+
+	return graphics
+}
+
+func (s *ProgramTestSuite) TestNoGraphics() {
+	tracker := treadonme.NewProgramTracker()
+	tracker.HandleMessage(&treadonme.MessageWorkoutData{Minute: 17, Second: 59, ProgramColumn: 1}, nil)
+
+	_, ok := tracker.Profile()
+	s.Require().False(ok)
+}
+
+func (s *ProgramTestSuite) TestSegmentPosition() {
+	tracker := treadonme.NewProgramTracker()
+
+	var updates []treadonme.ProgramProfile
+
+	tracker.AddListener(func(profile treadonme.ProgramProfile) {
+		updates = append(updates, profile)
+	})
+
+	tracker.HandleMessage(s.graphics(), nil)
+
+	// An 18 minute workout has one minute segments.
+	tracker.HandleMessage(&treadonme.MessageWorkoutData{Minute: 17, Second: 59, ProgramColumn: 1, ProgramRow: 1}, nil)
+
+	profile, ok := tracker.Profile()
+	s.Require().True(ok)
+	s.Require().Len(profile.Levels, 18)
+	s.Require().Equal(byte(2), profile.Levels[1])
+	s.Require().Equal(0, profile.Segment)
+	s.Require().Equal(59*time.Second, profile.SegmentRemaining)
+
+	tracker.HandleMessage(&treadonme.MessageWorkoutData{Minute: 14, Second: 30, ProgramColumn: 4, ProgramRow: 4}, nil)
+
+	profile, _ = tracker.Profile()
+	s.Require().Equal(3, profile.Segment)
+	s.Require().Equal(byte(4), profile.Row)
+	s.Require().Equal(30*time.Second, profile.SegmentRemaining)
+	s.Require().Len(updates, 3)
+}
+
+func (s *ProgramTestSuite) TestSegmentFromTime() {
+	tracker := treadonme.NewProgramTracker()
+	tracker.HandleMessage(s.graphics(), nil)
+	tracker.HandleMessage(&treadonme.MessageWorkoutData{Minute: 35, Second: 59}, nil)
+	tracker.HandleMessage(&treadonme.MessageWorkoutData{Minute: 30, Second: 0}, nil)
+
+	// A 36 minute workout has two minute segments.
+	profile, _ := tracker.Profile()
+	s.Require().Equal(3, profile.Segment)
+	s.Require().Equal(2*time.Minute, profile.SegmentRemaining)
+}
+
+func TestProgramTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &ProgramTestSuite{})
+}
@@ -0,0 +1,579 @@
+package treadonme
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+var ErrSafetyViolation = fmt.Errorf("blocked by safety policy")
+
+// SafetyAction is a state change requested by a remote caller.
+type SafetyAction byte
+
+const (
+	SafetyActionStart SafetyAction = iota + 1
+	SafetyActionStop
+	SafetyActionPause
+	SafetyActionResume
+	SafetyActionLevelUp
+	SafetyActionLevelDown
+	SafetyActionSetSpeed
+	SafetyActionSetIncline
+	SafetyActionUploadProgram
+)
+
+func (sa SafetyAction) String() string {
+	switch sa {
+	case SafetyActionStart:
+		return "Start"
+	case SafetyActionStop:
+		return "Stop"
+	case SafetyActionPause:
+		return "Pause"
+	case SafetyActionResume:
+		return "Resume"
+	case SafetyActionLevelUp:
+		return "LevelUp"
+	case SafetyActionLevelDown:
+		return "LevelDown"
+	case SafetyActionSetSpeed:
+		return "SetSpeed"
+	case SafetyActionSetIncline:
+		return "SetIncline"
+	case SafetyActionUploadProgram:
+		return "UploadProgram"
+	default:
+		return "Unknown"
+	}
+}
+
+// The rules a SafetyViolation can break.
+const (
+	SafetyRuleMaxSpeed    = "max_speed"
+	SafetyRuleMaxIncline  = "max_incline"
+	SafetyRuleSpeedRate   = "speed_rate"
+	SafetyRuleInclineRate = "incline_rate"
+	SafetyRulePaused      = "paused"
+	SafetyRuleSequence    = "sequence"
+)
+
+// SafetyViolation is returned instead of sending an action that breaks the safety policy, it wraps
+// ErrSafetyViolation.
+type SafetyViolation struct {
+	Rule   string
+	Action SafetyAction
+	Reason string
+}
+
+func (sv *SafetyViolation) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrSafetyViolation, sv.Action, sv.Reason)
+}
+
+func (sv *SafetyViolation) Unwrap() error {
+	return ErrSafetyViolation
+}
+
+// DefaultBlockedSequences are the sequences blocked when a policy doesn't set its own: starting a new workout right
+// after stopping, before the belt has come to a stop, and speeding up straight after resuming, before the runner has
+// found their footing.
+var DefaultBlockedSequences = [][]SafetyAction{
+	{SafetyActionStop, SafetyActionStart},
+	{SafetyActionResume, SafetyActionLevelUp},
+	{SafetyActionResume, SafetyActionSetSpeed},
+}
+
+// SafetyPolicy limits what remote callers can do. Stopping and pausing are always allowed and speeds and inclines can
+// always be lowered, only increases are limited, blocked sequences included.
+type SafetyPolicy struct {
+	// MaxSpeed and MaxIncline are the ceilings, zero uses the treadmill's own.
+	MaxSpeed   Speed
+	MaxIncline byte
+	// MaxSpeedChange and MaxInclineChange cap how much the speed and incline can be raised within RateWindow, zero
+	// doesn't limit them.
+	MaxSpeedChange   Speed
+	MaxInclineChange byte
+	// RateWindow defaults to 10 seconds.
+	RateWindow time.Duration
+	// BlockedSequences are actions that can't follow each other within SequenceWindow, nil uses
+	// DefaultBlockedSequences and an empty slice doesn't block any.
+	BlockedSequences [][]SafetyAction
+	// SequenceWindow defaults to 5 seconds.
+	SequenceWindow time.Duration
+}
+
+func (p *SafetyPolicy) setDefaults() {
+	if p.RateWindow == 0 {
+		p.RateWindow = 10 * time.Second
+	}
+
+	if p.BlockedSequences == nil {
+		p.BlockedSequences = DefaultBlockedSequences
+	}
+
+	if p.SequenceWindow == 0 {
+		p.SequenceWindow = 5 * time.Second
+	}
+}
+
+// AuditEntry records a remote state change, whether or not it was allowed.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	Source  string    `json:"source"`
+	Action  string    `json:"action"`
+	Value   int       `json:"value,omitempty"`
+	Allowed bool      `json:"allowed"`
+	Rule    string    `json:"rule,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type AuditListener func(entry *AuditEntry)
+
+// AuditLog writes audit entries as JSON, one per line.
+type AuditLog struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+func NewAuditLog(w io.Writer) *AuditLog {
+	return &AuditLog{w: w}
+}
+
+func (al *AuditLog) Record(entry *AuditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("problem encoding audit entry: %s", err)
+
+		return
+	}
+
+	al.mutex.Lock()
+	defer al.mutex.Unlock()
+
+	if _, err := al.w.Write(append(data, '\n')); err != nil {
+		log.Printf("problem writing audit entry: %s", err)
+	}
+}
+
+// guardAction and guardIncrease are what the guard remembers of an action, seq identifies the action they came from.
+type guardAction struct {
+	seq    uint64
+	at     time.Time
+	action SafetyAction
+}
+
+type guardIncrease struct {
+	seq     uint64
+	at      time.Time
+	speed   int
+	incline int
+}
+
+// Guard enforces a safety policy on the actions sent to a treadmill. It keeps the recent actions so it should be
+// shared by everything controlling the same treadmill.
+type Guard struct {
+	policy    SafetyPolicy
+	mutex     sync.Mutex
+	seq       uint64
+	actions   []guardAction
+	increases []guardIncrease
+	listeners []AuditListener
+}
+
+func NewGuard(policy SafetyPolicy) *Guard {
+	policy.setDefaults()
+
+	return &Guard{policy: policy}
+}
+
+func (g *Guard) AddAuditListener(listener AuditListener) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	g.listeners = append(g.listeners, listener)
+}
+
+// Check returns a SafetyViolation if the action isn't allowed, otherwise it's remembered as having been sent. The
+// value is the target speed or incline for SafetyActionSetSpeed and SafetyActionSetIncline.
+func (g *Guard) Check(ctrl Controller, action SafetyAction, value int, now time.Time) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	increase, err := g.check(ctrl, action, value, now)
+	if err != nil {
+		return err
+	}
+
+	g.record(action, increase)
+
+	return nil
+}
+
+// check returns a SafetyViolation if the action isn't allowed, otherwise how much it raises the speed and incline.
+func (g *Guard) check(ctrl Controller, action SafetyAction, value int, now time.Time) (guardIncrease, error) {
+	g.prune(now)
+
+	increase := guardIncrease{at: now}
+
+	if action == SafetyActionStop || action == SafetyActionPause {
+		return increase, nil
+	}
+
+	speedUp, inclineUp, err := g.checkLimits(ctrl, action, value)
+	if err == nil && raises(ctrl, action, value) {
+		err = g.checkSequence(action)
+	}
+
+	if err != nil {
+		return increase, err
+	}
+
+	increase.speed, increase.incline = speedUp, inclineUp
+
+	return increase, nil
+}
+
+// record remembers an action for the rate and sequence limits, returning the sequence number to forget it by.
+func (g *Guard) record(action SafetyAction, increase guardIncrease) uint64 {
+	g.seq++
+	increase.seq = g.seq
+
+	if increase.speed > 0 || increase.incline > 0 {
+		g.increases = append(g.increases, increase)
+	}
+
+	g.actions = append(g.actions, guardAction{seq: g.seq, at: increase.at, action: action})
+
+	return g.seq
+}
+
+// forget removes a recorded action that turned out not to be sent.
+func (g *Guard) forget(seq uint64) {
+	actions := g.actions[:0]
+
+	for _, a := range g.actions {
+		if a.seq != seq {
+			actions = append(actions, a)
+		}
+	}
+
+	g.actions = actions
+
+	increases := g.increases[:0]
+
+	for _, inc := range g.increases {
+		if inc.seq != seq {
+			increases = append(increases, inc)
+		}
+	}
+
+	g.increases = increases
+}
+
+// checkLimits checks the action against the ceilings and rates, returning how much it raises the speed and incline.
+func (g *Guard) checkLimits(ctrl Controller, action SafetyAction, value int) (int, int, error) {
+	maxSpeed, maxIncline := g.limits(ctrl.DeviceInfo())
+	speed, speedKnown := ctrl.CurrentSpeed()
+	incline, inclineKnown := ctrl.CurrentIncline()
+
+	violation := func(rule, format string, args ...interface{}) (int, int, error) {
+		return 0, 0, &SafetyViolation{Rule: rule, Action: action, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	speedUp, inclineUp := 0, 0
+
+	switch action {
+	case SafetyActionSetSpeed:
+		if value > int(maxSpeed) {
+			return violation(SafetyRuleMaxSpeed, "speed %.1f is above the max of %.1f", Speed(value).Float(),
+				maxSpeed.Float())
+		} else if speedKnown && value > int(speed) {
+			speedUp = value - int(speed)
+		}
+	case SafetyActionSetIncline:
+		if value > int(maxIncline) {
+			return violation(SafetyRuleMaxIncline, "incline %d is above the max of %d", value, maxIncline)
+		} else if inclineKnown && value > int(incline) {
+			inclineUp = value - int(incline)
+		}
+	case SafetyActionLevelUp:
+		if speedKnown && speed >= maxSpeed {
+			return violation(SafetyRuleMaxSpeed, "speed %.1f is already at the max of %.1f", speed.Float(),
+				maxSpeed.Float())
+		}
+
+		speedUp = 1
+	case SafetyActionResume:
+		// The treadmill resumes at the speed it was paused at.
+		if speedKnown && speed > maxSpeed {
+			return violation(SafetyRuleMaxSpeed, "speed %.1f is above the max of %.1f", speed.Float(), maxSpeed.Float())
+		}
+	}
+
+	if (speedUp > 0 || inclineUp > 0) && ctrl.CurrentMode() == WorkoutModePause {
+		return violation(SafetyRulePaused, "can't speed up or raise the incline while paused")
+	}
+
+	recentSpeed, recentIncline := 0, 0
+	for _, inc := range g.increases {
+		recentSpeed += inc.speed
+		recentIncline += inc.incline
+	}
+
+	if limit := int(g.policy.MaxSpeedChange); limit > 0 && speedUp > 0 && recentSpeed+speedUp > limit {
+		return violation(SafetyRuleSpeedRate, "speed can only be raised by %.1f every %s", g.policy.MaxSpeedChange.Float(),
+			g.policy.RateWindow)
+	}
+
+	if limit := int(g.policy.MaxInclineChange); limit > 0 && inclineUp > 0 && recentIncline+inclineUp > limit {
+		return violation(SafetyRuleInclineRate, "incline can only be raised by %d every %s", limit, g.policy.RateWindow)
+	}
+
+	return speedUp, inclineUp, nil
+}
+
+// raises returns whether the action could speed up or raise the incline, only those are blocked by sequences. Setting
+// a speed or incline is assumed to raise it if the current one isn't known.
+func raises(ctrl Controller, action SafetyAction, value int) bool {
+	switch action {
+	case SafetyActionSetSpeed:
+		speed, ok := ctrl.CurrentSpeed()
+
+		return !ok || value > int(speed)
+	case SafetyActionSetIncline:
+		incline, ok := ctrl.CurrentIncline()
+
+		return !ok || value > int(incline)
+	case SafetyActionStop, SafetyActionPause, SafetyActionLevelDown:
+		return false
+	default:
+		return true
+	}
+}
+
+func (g *Guard) checkSequence(action SafetyAction) error {
+	for _, seq := range g.policy.BlockedSequences {
+		if len(seq) == 0 || seq[len(seq)-1] != action || len(seq)-1 > len(g.actions) {
+			continue
+		}
+
+		recent := g.actions[len(g.actions)-len(seq)+1:]
+		matched := true
+
+		for idx, prev := range recent {
+			if prev.action != seq[idx] {
+				matched = false
+
+				break
+			}
+		}
+
+		if matched {
+			return &SafetyViolation{
+				Rule:   SafetyRuleSequence,
+				Action: action,
+				Reason: fmt.Sprintf("can't follow %s within %s", seq[0], g.policy.SequenceWindow),
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkProgram checks every segment of a user program against the ceilings, the treadmill runs them without asking
+// once the program is started.
+func (g *Guard) checkProgram(ctrl Controller, program *UserProgram) error {
+	maxSpeed, maxIncline := g.limits(ctrl.DeviceInfo())
+
+	for idx, seg := range program.Segments {
+		if seg.Speed > maxSpeed {
+			return &SafetyViolation{
+				Rule:   SafetyRuleMaxSpeed,
+				Action: SafetyActionUploadProgram,
+				Reason: fmt.Sprintf("segment %d speed %.1f is above the max of %.1f", idx, seg.Speed.Float(),
+					maxSpeed.Float()),
+			}
+		} else if seg.Incline > maxIncline {
+			return &SafetyViolation{
+				Rule:   SafetyRuleMaxIncline,
+				Action: SafetyActionUploadProgram,
+				Reason: fmt.Sprintf("segment %d incline %d is above the max of %d", idx, seg.Incline, maxIncline),
+			}
+		}
+	}
+
+	return nil
+}
+
+func (g *Guard) limits(info *MessageDeviceInfo) (Speed, byte) {
+	maxSpeed, maxIncline := g.policy.MaxSpeed, g.policy.MaxIncline
+
+	if info != nil {
+		if maxSpeed == 0 || maxSpeed > info.MaxSpeed {
+			maxSpeed = info.MaxSpeed
+		}
+
+		if maxIncline == 0 || maxIncline > info.InclineMax {
+			maxIncline = info.InclineMax
+		}
+	}
+
+	if maxSpeed == 0 {
+		maxSpeed = Speed(255)
+	}
+
+	if maxIncline == 0 {
+		maxIncline = 255
+	}
+
+	return maxSpeed, maxIncline
+}
+
+// prune forgets actions that have left the sequence and rate windows.
+func (g *Guard) prune(now time.Time) {
+	idx := 0
+	for idx < len(g.actions) && now.Sub(g.actions[idx].at) > g.policy.SequenceWindow {
+		idx++
+	}
+
+	g.actions = g.actions[idx:]
+
+	idx = 0
+	for idx < len(g.increases) && now.Sub(g.increases[idx].at) > g.policy.RateWindow {
+		idx++
+	}
+
+	g.increases = g.increases[idx:]
+}
+
+func (g *Guard) audit(entry *AuditEntry) {
+	g.mutex.Lock()
+	listeners := g.listeners
+	g.mutex.Unlock()
+
+	for _, listener := range listeners {
+		listener(entry)
+	}
+}
+
+// Equipment wraps equipment so every action sent through it is checked by the guard and recorded in the audit trail
+// as coming from source.
+func (g *Guard) Equipment(equipment Equipment, source string) *GuardedEquipment {
+	return &GuardedEquipment{Equipment: equipment, guard: g, source: source}
+}
+
+// GuardedEquipment is equipment whose actions are checked by a Guard.
+type GuardedEquipment struct {
+	Equipment
+	guard  *Guard
+	source string
+}
+
+var _ Equipment = (*GuardedEquipment)(nil)
+
+// Perform runs fn if the guard allows the action and records the outcome in the audit trail. The action counts towards
+// the rate and sequence limits while fn runs, so anything checked in the meantime sees it, and is forgotten again if
+// it couldn't be sent.
+func (ge *GuardedEquipment) Perform(action SafetyAction, value int, fn func() error) error {
+	now := time.Now()
+
+	ge.guard.mutex.Lock()
+	increase, err := ge.guard.check(ge.Equipment, action, value, now)
+
+	var seq uint64
+	if err == nil {
+		seq = ge.guard.record(action, increase)
+	}
+	ge.guard.mutex.Unlock()
+
+	if err == nil {
+		if err = fn(); err != nil {
+			ge.guard.mutex.Lock()
+			ge.guard.forget(seq)
+			ge.guard.mutex.Unlock()
+		}
+	}
+
+	ge.audit(now, action, value, err)
+
+	return err
+}
+
+// PerformUpload runs upload if every segment of the user program is within the policy's ceilings and records the
+// outcome in the audit trail. Uploading doesn't count towards the rate and sequence limits, nothing changes until the
+// program is started.
+func (ge *GuardedEquipment) PerformUpload(program *UserProgram, upload func() error) error {
+	now := time.Now()
+
+	err := ge.guard.checkProgram(ge.Equipment, program)
+	if err == nil {
+		err = upload()
+	}
+
+	ge.audit(now, SafetyActionUploadProgram, int(program.Slot), err)
+
+	return err
+}
+
+// audit records the outcome of an action in the audit trail.
+func (ge *GuardedEquipment) audit(now time.Time, action SafetyAction, value int, err error) {
+	entry := &AuditEntry{
+		Time:    now,
+		Source:  ge.source,
+		Action:  action.String(),
+		Value:   value,
+		Allowed: !errors.Is(err, ErrSafetyViolation),
+	}
+
+	var violation *SafetyViolation
+	if errors.As(err, &violation) {
+		entry.Rule = violation.Rule
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	ge.guard.audit(entry)
+}
+
+func (ge *GuardedEquipment) Start() error {
+	return ge.Perform(SafetyActionStart, 0, ge.Equipment.Start)
+}
+
+func (ge *GuardedEquipment) Stop() error {
+	return ge.Perform(SafetyActionStop, 0, ge.Equipment.Stop)
+}
+
+func (ge *GuardedEquipment) Pause() error {
+	return ge.Perform(SafetyActionPause, 0, ge.Equipment.Pause)
+}
+
+func (ge *GuardedEquipment) Resume() error {
+	return ge.Perform(SafetyActionResume, 0, ge.Equipment.Resume)
+}
+
+func (ge *GuardedEquipment) LevelUp() error {
+	return ge.Perform(SafetyActionLevelUp, 0, ge.Equipment.LevelUp)
+}
+
+func (ge *GuardedEquipment) LevelDown() error {
+	return ge.Perform(SafetyActionLevelDown, 0, ge.Equipment.LevelDown)
+}
+
+func (ge *GuardedEquipment) SetTargetSpeed(ctx context.Context, speed Speed) error {
+	return ge.Perform(SafetyActionSetSpeed, int(speed), func() error {
+		return ge.Equipment.SetTargetSpeed(ctx, speed)
+	})
+}
+
+func (ge *GuardedEquipment) SetTargetIncline(ctx context.Context, incline byte) error {
+	return ge.Perform(SafetyActionSetIncline, int(incline), func() error {
+		return ge.Equipment.SetTargetIncline(ctx, incline)
+	})
+}
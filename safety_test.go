@@ -0,0 +1,264 @@
+package treadonme_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+// fakeEquipment is a fakeController that accepts every command.
+type fakeEquipment struct {
+	*fakeController
+}
+
+func (fe *fakeEquipment) Connect(context.Context) error                        { return nil }
+func (fe *fakeEquipment) Close() error                                         { return nil }
+func (fe *fakeEquipment) AddListener(treadonme.MessageListener)                {}
+func (fe *fakeEquipment) GetDeviceInfo() (*treadonme.MessageDeviceInfo, error) { return fe.info, nil }
+func (fe *fakeEquipment) Start() error                                         { return nil }
+func (fe *fakeEquipment) Stop() error                                          { return nil }
+func (fe *fakeEquipment) Pause() error                                         { return nil }
+func (fe *fakeEquipment) Resume() error                                        { return nil }
+func (fe *fakeEquipment) LevelUp() error                                       { return nil }
+func (fe *fakeEquipment) LevelDown() error                                     { return nil }
+
+type SafetyTestSuite struct {
+	suite.Suite
+}
+
+func (s *SafetyTestSuite) controller() *fakeController {
+	return &fakeController{
+		info:  &treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial, MinSpeed: 5, MaxSpeed: 120, InclineMax: 15},
+		mode:  treadonme.WorkoutModeRunning,
+		speed: 30,
+	}
+}
+
+func (s *SafetyTestSuite) requireViolation(err error, rule string) {
+	s.Require().ErrorIs(err, treadonme.ErrSafetyViolation)
+
+	violation := &treadonme.SafetyViolation{}
+	s.Require().ErrorAs(err, &violation)
+	s.Require().Equal(rule, violation.Rule)
+}
+
+func (s *SafetyTestSuite) TestCeilings() {
+	ctrl := s.controller()
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{MaxSpeed: 60, MaxIncline: 10})
+	now := time.Now()
+
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 60, now))
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 61, now), treadonme.SafetyRuleMaxSpeed)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetIncline, 10, now))
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetIncline, 11, now), treadonme.SafetyRuleMaxIncline)
+
+	ctrl.speed = 60
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionLevelUp, 0, now), treadonme.SafetyRuleMaxSpeed)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionLevelDown, 0, now))
+
+	// The treadmill's own limits apply when they're lower than the policy's.
+	guard = treadonme.NewGuard(treadonme.SafetyPolicy{MaxIncline: 20})
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetIncline, 16, now), treadonme.SafetyRuleMaxIncline)
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 121, now), treadonme.SafetyRuleMaxSpeed)
+}
+
+func (s *SafetyTestSuite) TestRateOfChange() {
+	ctrl := s.controller()
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{MaxSpeedChange: 10, MaxInclineChange: 2})
+	now := time.Now()
+
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 35, now))
+	ctrl.speed = 35
+
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 41, now.Add(time.Second)),
+		treadonme.SafetyRuleSpeedRate)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 40, now.Add(time.Second)))
+	ctrl.speed = 40
+
+	// Slowing down is never limited.
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 20, now.Add(2*time.Second)))
+	ctrl.speed = 20
+
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionLevelUp, 0, now.Add(3*time.Second)),
+		treadonme.SafetyRuleSpeedRate)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionLevelUp, 0, now.Add(11*time.Second)))
+
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetIncline, 3, now), treadonme.SafetyRuleInclineRate)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetIncline, 2, now))
+}
+
+func (s *SafetyTestSuite) TestPaused() {
+	ctrl := s.controller()
+	ctrl.mode = treadonme.WorkoutModePause
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{})
+	now := time.Now()
+
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionLevelUp, 0, now), treadonme.SafetyRulePaused)
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetIncline, 5, now), treadonme.SafetyRulePaused)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 20, now))
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionStop, 0, now))
+}
+
+func (s *SafetyTestSuite) TestSequences() {
+	ctrl := s.controller()
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{})
+	now := time.Now()
+
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionStop, 0, now))
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionStart, 0, now.Add(time.Second)),
+		treadonme.SafetyRuleSequence)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionStart, 0, now.Add(6*time.Second)))
+
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionResume, 0, now.Add(10*time.Second)))
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionLevelUp, 0, now.Add(11*time.Second)),
+		treadonme.SafetyRuleSequence)
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionLevelDown, 0, now.Add(11*time.Second)))
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionLevelUp, 0, now.Add(12*time.Second)))
+
+	// Only speeding up is blocked after resuming, slowing down isn't.
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionResume, 0, now.Add(20*time.Second)))
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 20, now.Add(21*time.Second)))
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionResume, 0, now.Add(30*time.Second)))
+	s.requireViolation(guard.Check(ctrl, treadonme.SafetyActionSetSpeed, 40, now.Add(31*time.Second)),
+		treadonme.SafetyRuleSequence)
+
+	// Stopping is never blocked.
+	guard = treadonme.NewGuard(treadonme.SafetyPolicy{
+		BlockedSequences: [][]treadonme.SafetyAction{{treadonme.SafetyActionStart, treadonme.SafetyActionStop}},
+	})
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionStart, 0, now))
+	s.Require().NoError(guard.Check(ctrl, treadonme.SafetyActionStop, 0, now))
+}
+
+func (s *SafetyTestSuite) TestFailedActionsNotCounted() {
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{MaxSpeedChange: 5})
+	tm := guard.Equipment(&fakeEquipment{fakeController: s.controller()}, "api")
+
+	sent := func() error { return nil }
+	failed := func() error { return errRadio }
+
+	s.Require().ErrorIs(tm.Perform(treadonme.SafetyActionSetSpeed, 35, failed), errRadio)
+	s.Require().NoError(tm.Perform(treadonme.SafetyActionSetSpeed, 35, sent))
+	s.requireViolation(tm.Perform(treadonme.SafetyActionSetSpeed, 31, sent), treadonme.SafetyRuleSpeedRate)
+
+	s.Require().ErrorIs(tm.Perform(treadonme.SafetyActionStop, 0, failed), errRadio)
+	s.Require().NoError(tm.Perform(treadonme.SafetyActionStart, 0, sent))
+}
+
+// TestConcurrentActions checks an action counts towards the limits as soon as it's allowed, not once it's been sent.
+func (s *SafetyTestSuite) TestConcurrentActions() {
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{MaxSpeedChange: 5})
+	api := guard.Equipment(&fakeEquipment{fakeController: s.controller()}, "api")
+	websocket := guard.Equipment(&fakeEquipment{fakeController: s.controller()}, "websocket")
+
+	perform := func(tm *treadonme.GuardedEquipment, action treadonme.SafetyAction, value int) (chan struct{}, chan error) {
+		sending, release, done := make(chan struct{}), make(chan struct{}), make(chan error, 1)
+
+		go func() {
+			done <- tm.Perform(action, value, func() error {
+				close(sending)
+				<-release
+
+				return nil
+			})
+		}()
+
+		<-sending
+
+		return release, done
+	}
+
+	// A start while the stop is still waiting for the treadmill is blocked.
+	release, done := perform(api, treadonme.SafetyActionStop, 0)
+	s.requireViolation(websocket.Perform(treadonme.SafetyActionStart, 0, func() error { return nil }),
+		treadonme.SafetyRuleSequence)
+	close(release)
+	s.Require().NoError(<-done)
+
+	// As is a second speed up that would go over the rate with the first.
+	release, done = perform(api, treadonme.SafetyActionSetSpeed, 35)
+	s.requireViolation(websocket.Perform(treadonme.SafetyActionSetSpeed, 35, func() error { return nil }),
+		treadonme.SafetyRuleSpeedRate)
+	close(release)
+	s.Require().NoError(<-done)
+}
+
+func (s *SafetyTestSuite) TestUserProgram() {
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{MaxSpeed: 60, MaxIncline: 5})
+
+	var entries []*treadonme.AuditEntry
+	guard.AddAuditListener(func(entry *treadonme.AuditEntry) {
+		entries = append(entries, entry)
+	})
+
+	tm := guard.Equipment(&fakeEquipment{fakeController: s.controller()}, "api")
+
+	uploads := 0
+	upload := func() error {
+		uploads++
+
+		return nil
+	}
+
+	program := func(speed treadonme.Speed, incline byte) *treadonme.UserProgram {
+		return &treadonme.UserProgram{Slot: treadonme.ProgramUser1, Segments: []treadonme.UserProgramSegment{
+			{Speed: 30, Incline: 0},
+			{Speed: speed, Incline: incline},
+		}}
+	}
+
+	s.requireViolation(tm.PerformUpload(program(80, 0), upload), treadonme.SafetyRuleMaxSpeed)
+	s.requireViolation(tm.PerformUpload(program(40, 8), upload), treadonme.SafetyRuleMaxIncline)
+	s.Require().NoError(tm.PerformUpload(program(60, 5), upload))
+	s.Require().Equal(1, uploads)
+
+	s.Require().Len(entries, 3)
+	s.Require().Equal("UploadProgram", entries[0].Action)
+	s.Require().Equal(int(treadonme.ProgramUser1), entries[0].Value)
+	s.Require().False(entries[0].Allowed)
+	s.Require().True(entries[2].Allowed)
+}
+
+func (s *SafetyTestSuite) TestAuditLog() {
+	buf := &bytes.Buffer{}
+	auditLog := treadonme.NewAuditLog(buf)
+
+	guard := treadonme.NewGuard(treadonme.SafetyPolicy{MaxSpeed: 60})
+	guard.AddAuditListener(auditLog.Record)
+
+	var entries []*treadonme.AuditEntry
+	guard.AddAuditListener(func(entry *treadonme.AuditEntry) {
+		entries = append(entries, entry)
+	})
+
+	tm := guard.Equipment(&fakeEquipment{fakeController: s.controller()}, "api")
+	s.Require().NoError(tm.Pause())
+	s.requireViolation(tm.SetTargetSpeed(context.Background(), 80), treadonme.SafetyRuleMaxSpeed)
+
+	s.Require().Len(entries, 2)
+	s.Require().Equal("api", entries[0].Source)
+	s.Require().Equal("Pause", entries[0].Action)
+	s.Require().True(entries[0].Allowed)
+	s.Require().Equal("SetSpeed", entries[1].Action)
+	s.Require().Equal(80, entries[1].Value)
+	s.Require().False(entries[1].Allowed)
+	s.Require().Equal(treadonme.SafetyRuleMaxSpeed, entries[1].Rule)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	s.Require().Len(lines, 2)
+
+	logged := &treadonme.AuditEntry{}
+	s.Require().NoError(json.Unmarshal(lines[1], logged))
+	s.Require().Equal(entries[1].Error, logged.Error)
+}
+
+func TestSafetyTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &SafetyTestSuite{})
+}
@@ -0,0 +1,275 @@
+package treadonme
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Sample is a snapshot of the workout telemetry, one is recorded every time the treadmill sends workout data (about
+// once a second).
+type Sample struct {
+	Timestamp time.Time
+	Elapsed   time.Duration
+	Distance  uint16
+	Calories  uint16
+	Speed     Speed
+	Incline   byte
+	HeartRate byte
+	// HeartRateSource is where HeartRate came from, an external monitor is preferred while it's reporting.
+	HeartRateSource HeartRateSource
+	// RRIntervals are the beat to beat intervals reported by an external monitor since the previous sample.
+	RRIntervals   []time.Duration
+	ProgramRow    byte
+	ProgramColumn byte
+	Mode          WorkoutMode
+}
+
+type SampleListener func(Sample)
+
+// TelemetryGap is a span of a workout where the treadmill's telemetry stalled and nothing was recorded. The end is
+// zero if the telemetry never recovered.
+type TelemetryGap struct {
+	Start time.Time
+	End   time.Time
+}
+
+// externalHeartRateTimeout is how long a reading from an external heart rate monitor is preferred over the treadmill's
+// own reading.
+const externalHeartRateTimeout = 5 * time.Second
+
+// Session records the telemetry of a single workout. Register Session.HandleMessage as a listener on a treadmill to
+// start recording, and Session.HandleHeartRate on a heart rate monitor to record from an external monitor. Start, End
+// and Summary are filled in as the workout is recorded, read them with StartTime, EndTime and WorkoutSummary while it's
+// in progress.
+type Session struct {
+	ID      string
+	User    string
+	Model   DeviceModel
+	Units   UnitsType
+	Start   time.Time
+	End     time.Time
+	Summary *MessageEndWorkout
+
+	mutex     sync.Mutex
+	mode      WorkoutMode
+	samples   []Sample
+	gaps      []TelemetryGap
+	errors    []ErrorEvent
+	listeners []SampleListener
+	now       func() time.Time
+
+	externalHeartRate   *HeartRateMeasurement
+	externalHeartRateAt time.Time
+	rrIntervals         []time.Duration
+}
+
+func NewSession(info *MessageDeviceInfo) *Session {
+	return &Session{
+		ID:    newSessionID(),
+		Model: info.Model,
+		Units: info.Units,
+		mode:  WorkoutModeIdle,
+		now:   time.Now,
+	}
+}
+
+func (s *Session) HandleMessage(msg Message, err error) {
+	if err != nil {
+		return
+	}
+
+	switch v := msg.(type) {
+	case *MessageWorkoutMode:
+		s.mutex.Lock()
+		s.mode = v.Mode
+		s.mutex.Unlock()
+	case *MessageWorkoutData:
+		s.record(v)
+	case *MessageErrorCode:
+		s.mutex.Lock()
+		s.errors = append(s.errors, NewErrorEvent(v.Code, s.now()))
+		s.mutex.Unlock()
+	case *MessageEndWorkout:
+		s.mutex.Lock()
+		s.Summary = v
+		s.End = s.now()
+		s.mutex.Unlock()
+	}
+}
+
+// HandleTelemetry records the gaps reported by a treadmill's telemetry watchdog.
+func (s *Session) HandleTelemetry(event TelemetryEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	switch event.Type {
+	case TelemetryEventStalled:
+		s.gaps = append(s.gaps, TelemetryGap{Start: event.Since})
+	case TelemetryEventRecovered:
+		if len(s.gaps) > 0 && s.gaps[len(s.gaps)-1].End.IsZero() {
+			s.gaps[len(s.gaps)-1].End = event.At
+		}
+	}
+}
+
+// HandleHeartRate records a measurement from an external heart rate monitor, it's used in place of the treadmill's
+// heart rate in the samples recorded while it's fresh.
+func (s *Session) HandleHeartRate(m *HeartRateMeasurement, err error) {
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Without skin contact the monitor is just sending noise.
+	if m.SensorContact == SensorContactNotDetected || m.HeartRate == 0 {
+		return
+	}
+
+	s.externalHeartRate = m
+	s.externalHeartRateAt = s.now()
+	s.rrIntervals = append(s.rrIntervals, m.RRIntervals...)
+}
+
+func (s *Session) AddSampleListener(listener SampleListener) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.listeners = append(s.listeners, listener)
+}
+
+func (s *Session) Samples() []Sample {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	samples := make([]Sample, len(s.samples))
+	copy(samples, s.samples)
+
+	return samples
+}
+
+func (s *Session) Gaps() []TelemetryGap {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	gaps := make([]TelemetryGap, len(s.gaps))
+	copy(gaps, s.gaps)
+
+	return gaps
+}
+
+// Errors returns the error codes the treadmill reported during the workout.
+func (s *Session) Errors() []ErrorEvent {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	events := make([]ErrorEvent, len(s.errors))
+	copy(events, s.errors)
+
+	return events
+}
+
+func (s *Session) Mode() WorkoutMode {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.mode
+}
+
+// LastSample returns the most recently recorded sample, false is returned if nothing has been recorded yet.
+func (s *Session) LastSample() (Sample, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if len(s.samples) == 0 {
+		return Sample{}, false
+	}
+
+	return s.samples[len(s.samples)-1], true
+}
+
+// StartTime returns when the first sample was recorded, it's zero until then.
+func (s *Session) StartTime() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.Start
+}
+
+// EndTime returns when the treadmill reported the end of the workout, it's zero until then.
+func (s *Session) EndTime() time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.End
+}
+
+// WorkoutSummary returns the summary the treadmill sent at the end of the workout, it's nil until then.
+func (s *Session) WorkoutSummary() *MessageEndWorkout {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.Summary
+}
+
+func (s *Session) Ended() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return !s.End.IsZero()
+}
+
+func (s *Session) record(data *MessageWorkoutData) {
+	s.mutex.Lock()
+
+	now := s.now()
+	if s.Start.IsZero() {
+		s.Start = now
+	}
+
+	sample := Sample{
+		Timestamp:     now,
+		Elapsed:       now.Sub(s.Start),
+		Distance:      data.Distance,
+		Calories:      data.Calories,
+		Speed:         data.Speed,
+		Incline:       data.Incline,
+		HeartRate:     data.HeartRate,
+		ProgramRow:    data.ProgramRow,
+		ProgramColumn: data.ProgramColumn,
+		Mode:          s.mode,
+	}
+
+	if s.externalHeartRate != nil && now.Sub(s.externalHeartRateAt) <= externalHeartRateTimeout {
+		sample.HeartRate = 255
+		if s.externalHeartRate.HeartRate < 255 {
+			sample.HeartRate = byte(s.externalHeartRate.HeartRate)
+		}
+
+		sample.HeartRateSource = HeartRateSourceExternal
+		sample.RRIntervals = s.rrIntervals
+	}
+
+	s.rrIntervals = nil
+
+	s.samples = append(s.samples, sample)
+	listeners := s.listeners
+	s.mutex.Unlock()
+
+	// Notify outside of the lock so listeners are free to call back into the session.
+	for _, l := range listeners {
+		l(sample)
+	}
+}
+
+func newSessionID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().Format("20060102150405")
+	}
+
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,173 @@
+package treadonme
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"time"
+)
+
+var ErrInvalidStartOptions = fmt.Errorf("invalid start options")
+
+const (
+	// reconnectDelay is how long the treadmill takes to get ready for the workout after it's been started.
+	reconnectDelay    = 5 * time.Second
+	reconnectAttempts = 5
+)
+
+// StartOptions configure the workout started by StartWorkout, the zero value starts a manual workout without any
+// targets like Start does.
+type StartOptions struct {
+	// Profile is the user the treadmill estimates the calories burned for, it defaults to the profile set with
+	// SetStartProfile.
+	Profile *MessageUserProfile
+	// Program defaults to ProgramManual.
+	Program Program
+	// TargetTime is the length of the workout, it's rounded down to whole minutes.
+	TargetTime time.Duration
+	// TargetCalories ends the workout once they've been burned. Only one of TargetTime and TargetCalories can be set.
+	TargetCalories uint16
+	// MaxIncline caps the incline of the workout, it isn't sent if it's zero.
+	MaxIncline byte
+}
+
+// Validate checks the options can be used with a treadmill.
+func (o *StartOptions) Validate(info *MessageDeviceInfo) error {
+	if o.Program != 0 && !knownProgram(o.Program) {
+		return fmt.Errorf("%w: unknown program 0x%04x", ErrInvalidStartOptions, uint16(o.Program))
+	}
+
+	if o.Profile != nil {
+		if o.Profile.Sex != SexTypeMale && o.Profile.Sex != SexTypeFemale {
+			return fmt.Errorf("%w: profile has an unknown sex %d", ErrInvalidStartOptions, o.Profile.Sex)
+		} else if o.Profile.Age == 0 || o.Profile.Weight == 0 || o.Profile.Height == 0 {
+			return fmt.Errorf("%w: profile needs an age, weight and height", ErrInvalidStartOptions)
+		}
+	}
+
+	switch {
+	case o.TargetTime < 0 || o.TargetTime.Minutes() > math.MaxUint8:
+		return fmt.Errorf("%w: target time %s is out of range", ErrInvalidStartOptions, o.TargetTime)
+	case o.TargetTime > 0 && o.TargetTime < time.Minute:
+		return fmt.Errorf("%w: target time must be at least a minute", ErrInvalidStartOptions)
+	case o.TargetTime > 0 && o.TargetCalories > 0:
+		return fmt.Errorf("%w: only one of a target time or calories can be set", ErrInvalidStartOptions)
+	case info != nil && o.MaxIncline > info.InclineMax:
+		return fmt.Errorf("%w: max incline %d is above the treadmill's max of %d", ErrInvalidStartOptions,
+			o.MaxIncline, info.InclineMax)
+	}
+
+	return nil
+}
+
+// Messages returns the messages that set up the workout in the order the console expects them, ending with the
+// message that starts the workout.
+func (o *StartOptions) Messages() []Message {
+	profile := o.Profile
+	if profile == nil {
+		profile = &DefaultUserProfile
+	}
+
+	program := o.Program
+	if program == 0 {
+		program = ProgramManual
+	}
+
+	msgs := []Message{
+		profile,
+		&MessageProgram{Program: program},
+		&MessageWorkoutTarget{Time: byte(o.TargetTime / time.Minute), Calories: o.TargetCalories},
+	}
+
+	if o.MaxIncline > 0 {
+		msgs = append(msgs, &MessageMaxIncline{MaxIncline: o.MaxIncline})
+	}
+
+	return append(msgs, &MessageSetWorkoutMode{Mode: WorkoutModeStart})
+}
+
+// StartWorkout sets up and starts a workout. Once started the treadmill drops the connection while it gets ready, the
+// connection is re-established before returning.
+func (t *Treadmill) StartWorkout(ctx context.Context, opts StartOptions) error {
+	info := t.DeviceInfo()
+	if info == nil {
+		var err error
+		if info, err = t.GetDeviceInfo(); err != nil {
+			return err
+		}
+	}
+
+	if opts.Profile == nil {
+		profile := t.profile
+		opts.Profile = &profile
+	}
+
+	if err := opts.Validate(info); err != nil {
+		return err
+	}
+
+	for _, msg := range opts.Messages() {
+		expect := MessageTypeACK
+		if msg.MessageType() == MessageTypeSetWorkoutMode {
+			expect = MessageTypeSetWorkoutMode
+		}
+
+		if _, err := t.writeWithResponse(msg, expect); err != nil {
+			return err
+		}
+	}
+
+	if err := t.disconnect(); err != nil {
+		return err
+	}
+
+	select {
+	case <-t.clock.After(reconnectDelay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	return t.connectAgain(ctx)
+}
+
+// reconnect drops the connection and connects again, it's used by the telemetry watchdog.
+func (t *Treadmill) reconnect(ctx context.Context) error {
+	if err := t.disconnect(); err != nil {
+		log.Printf("problem disconnecting before reconnecting: %s", err)
+	}
+
+	return t.connectAgain(ctx)
+}
+
+// connectAgain re-establishes a dropped connection, the treadmill won't send anything until it's been asked for its
+// device info again.
+func (t *Treadmill) connectAgain(ctx context.Context) error {
+	var lastErr error
+
+	for idx := 0; idx < reconnectAttempts; idx++ {
+		t.counters.reconnect()
+
+		if lastErr = t.Connect(ctx); lastErr == nil {
+			log.Printf("reconnected to treadmill")
+
+			_, err := t.GetDeviceInfo()
+
+			return err
+		} else if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	return lastErr
+}
+
+func knownProgram(program Program) bool {
+	for _, p := range programs {
+		if p == program {
+			return true
+		}
+	}
+
+	return false
+}
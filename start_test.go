@@ -0,0 +1,72 @@
+package treadonme_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+type StartTestSuite struct {
+	suite.Suite
+}
+
+func (s *StartTestSuite) TestDefaultMessages() {
+	opts := &treadonme.StartOptions{}
+	s.Require().NoError(opts.Validate(&treadonme.MessageDeviceInfo{InclineMax: 15}))
+
+	s.Require().Equal([]treadonme.Message{
+		&treadonme.DefaultUserProfile,
+		&treadonme.MessageProgram{Program: treadonme.ProgramManual},
+		&treadonme.MessageWorkoutTarget{},
+		&treadonme.MessageSetWorkoutMode{Mode: treadonme.WorkoutModeStart},
+	}, opts.Messages())
+}
+
+func (s *StartTestSuite) TestTargetMessages() {
+	profile := &treadonme.MessageUserProfile{Sex: treadonme.SexTypeFemale, Age: 35, Weight: 60, Height: 170}
+	opts := &treadonme.StartOptions{
+		Profile:    profile,
+		Program:    treadonme.ProgramHill,
+		TargetTime: 30*time.Minute + 20*time.Second,
+		MaxIncline: 10,
+	}
+	s.Require().NoError(opts.Validate(&treadonme.MessageDeviceInfo{InclineMax: 15}))
+
+	s.Require().Equal([]treadonme.Message{
+		profile,
+		&treadonme.MessageProgram{Program: treadonme.ProgramHill},
+		&treadonme.MessageWorkoutTarget{Time: 30},
+		&treadonme.MessageMaxIncline{MaxIncline: 10},
+		&treadonme.MessageSetWorkoutMode{Mode: treadonme.WorkoutModeStart},
+	}, opts.Messages())
+
+	calories := &treadonme.StartOptions{TargetCalories: 300}
+	s.Require().NoError(calories.Validate(nil))
+	s.Require().Equal(&treadonme.MessageWorkoutTarget{Calories: 300}, calories.Messages()[2])
+}
+
+func (s *StartTestSuite) TestValidate() {
+	info := &treadonme.MessageDeviceInfo{InclineMax: 15}
+
+	for name, opts := range map[string]treadonme.StartOptions{
+		"program":      {Program: 0x1234},
+		"sex":          {Profile: &treadonme.MessageUserProfile{Age: 30, Weight: 150, Height: 70}},
+		"age":          {Profile: &treadonme.MessageUserProfile{Sex: treadonme.SexTypeMale, Weight: 150, Height: 70}},
+		"long":         {TargetTime: 256 * time.Minute},
+		"short":        {TargetTime: 30 * time.Second},
+		"negative":     {TargetTime: -time.Minute},
+		"both targets": {TargetTime: 20 * time.Minute, TargetCalories: 200},
+		"incline":      {MaxIncline: 16},
+	} {
+		opts := opts
+		s.Require().ErrorIs(opts.Validate(info), treadonme.ErrInvalidStartOptions, name)
+	}
+}
+
+func TestStartTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &StartTestSuite{})
+}
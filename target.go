@@ -0,0 +1,126 @@
+package treadonme
+
+import (
+	"context"
+	"fmt"
+)
+
+var (
+	ErrTargetOutOfRange    = fmt.Errorf("target is outside of the range supported by the treadmill")
+	ErrTargetInterrupted   = fmt.Errorf("treadmill stopped moving towards the target")
+	ErrUnknownCurrentValue = fmt.Errorf("current value hasn't been reported by the treadmill")
+)
+
+// Controller is implemented by equipment that can be driven towards a target speed and incline by the host.
+type Controller interface {
+	DeviceInfo() *MessageDeviceInfo
+	CurrentMode() WorkoutMode
+	CurrentSpeed() (Speed, bool)
+	CurrentIncline() (byte, bool)
+	SetTargetSpeed(ctx context.Context, speed Speed) error
+	SetTargetIncline(ctx context.Context, incline byte) error
+}
+
+// maxStalledSteps is how many steps in a row can fail to change the reported value before giving up.
+const maxStalledSteps = 3
+
+// SetTargetSpeed steps the speed of the current workout towards the target one level at a time, waiting for the
+// treadmill to report the new speed after every step. It gives up if the context is cancelled, a step isn't confirmed
+// or the speed moves away from the target (for instance when someone is using the console at the same time).
+func (t *Treadmill) SetTargetSpeed(ctx context.Context, speed Speed) error {
+	if info := t.DeviceInfo(); info != nil && (speed < info.MinSpeed || speed > info.MaxSpeed) {
+		return fmt.Errorf("%w: speed %d, supported %d-%d", ErrTargetOutOfRange, speed, info.MinSpeed, info.MaxSpeed)
+	}
+
+	return t.stepTo(ctx, int(speed),
+		func() (int, bool) {
+			current, ok := t.CurrentSpeed()
+
+			return int(current), ok
+		},
+		func(current, direction int) Message {
+			if direction > 0 {
+				return &MessageCommand{Command: CommandTypeLevelUp}
+			}
+
+			return &MessageCommand{Command: CommandTypeLevelDown}
+		},
+		MessageTypeSpeed,
+	)
+}
+
+// SetTargetIncline steps the incline of the current workout towards the target one level at a time, waiting for the
+// treadmill to report the new incline after every step. It gives up under the same conditions as SetTargetSpeed.
+func (t *Treadmill) SetTargetIncline(ctx context.Context, incline byte) error {
+	if info := t.DeviceInfo(); info != nil && incline > info.InclineMax {
+		return fmt.Errorf("%w: incline %d, max %d", ErrTargetOutOfRange, incline, info.InclineMax)
+	}
+
+	return t.stepTo(ctx, int(incline),
+		func() (int, bool) {
+			current, ok := t.CurrentIncline()
+
+			return int(current), ok
+		},
+		func(current, direction int) Message {
+			return &MessageUserIncline{Incline: byte(current + direction)}
+		},
+		MessageTypeIncline,
+	)
+}
+
+func (t *Treadmill) stepTo(
+	ctx context.Context,
+	target int,
+	current func() (int, bool),
+	step func(current, direction int) Message,
+	feedback MessageType,
+) error {
+	value, ok := current()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownCurrentValue, feedback)
+	}
+
+	stalled := 0
+
+	for value != target {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		direction := 1
+		if target < value {
+			direction = -1
+		}
+
+		if _, err := t.writeWithConfirmation(ctx, step(value, direction), MessageTypeACK, matchType(feedback)); err != nil {
+			return err
+		}
+
+		next, _ := current()
+
+		// Make sure we're making progress, the feedback can lag behind by a step but should never go backwards.
+		switch {
+		case distance(next, target) > distance(value, target):
+			return fmt.Errorf("%w: %s went from %d to %d, target %d", ErrTargetInterrupted, feedback, value, next, target)
+		case next == value:
+			if stalled++; stalled >= maxStalledSteps {
+				return fmt.Errorf("%w: %s stuck at %d, target %d", ErrTargetInterrupted, feedback, value, target)
+			}
+		default:
+			stalled = 0
+		}
+
+		value = next
+	}
+
+	return nil
+}
+
+func distance(a, b int) int {
+	if a > b {
+		return a - b
+	}
+
+	return b - a
+}
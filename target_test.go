@@ -0,0 +1,121 @@
+package treadonme_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/treadtest"
+)
+
+var (
+	levelUp   = treadtest.Frame(&treadonme.MessageCommand{Command: treadonme.CommandTypeLevelUp})
+	levelDown = treadtest.Frame(&treadonme.MessageCommand{Command: treadonme.CommandTypeLevelDown})
+	ackLevel  = ack(treadonme.MessageTypeCommand)
+)
+
+func ack(msgType treadonme.MessageType) string {
+	return treadtest.Frame(&treadonme.MessageACK{Acknowledged: msgType})
+}
+
+func speed(s treadonme.Speed) string {
+	return treadtest.Frame(&treadonme.MessageSpeed{Speed: s})
+}
+
+type TargetTestSuite struct {
+	suite.Suite
+}
+
+// treadmill returns a mock treadmill that has reported its device info and the given speed.
+func (s *TargetTestSuite) treadmill(current treadonme.Speed) (*treadtest.Mock, *treadonme.Treadmill) {
+	mock := treadtest.New(s.T())
+	tm := mock.Treadmill()
+
+	mock.Push("5B08F092000178050F125D", speed(current))
+
+	return mock, tm
+}
+
+func (s *TargetTestSuite) TestReachSpeed() {
+	mock, tm := s.treadmill(30)
+	mock.ExpectWrite(levelUp).Reply(ackLevel).ThenPush(speed(31))
+	mock.ExpectWrite(levelUp).Reply(ackLevel).ThenPush(speed(32))
+
+	s.Require().NoError(tm.SetTargetSpeed(context.Background(), 32))
+	s.Require().True(mock.AssertExpectations())
+
+	mock.ExpectWrite(levelDown).Reply(ackLevel).ThenPush(speed(31))
+	s.Require().NoError(tm.SetTargetSpeed(context.Background(), 31))
+	s.Require().True(mock.AssertExpectations())
+}
+
+func (s *TargetTestSuite) TestReachIncline() {
+	mock, tm := s.treadmill(30)
+	mock.Push(treadtest.Frame(&treadonme.MessageIncline{Incline: 0}))
+
+	for incline := byte(1); incline <= 2; incline++ {
+		mock.ExpectWrite(treadtest.Frame(&treadonme.MessageUserIncline{Incline: incline})).
+			Reply(ack(treadonme.MessageTypeUserIncline)).
+			ThenPush(treadtest.Frame(&treadonme.MessageIncline{Incline: incline}))
+	}
+
+	s.Require().NoError(tm.SetTargetIncline(context.Background(), 2))
+	s.Require().True(mock.AssertExpectations())
+
+	current, ok := tm.CurrentIncline()
+	s.Require().True(ok)
+	s.Require().Equal(byte(2), current)
+}
+
+func (s *TargetTestSuite) TestStall() {
+	mock, tm := s.treadmill(30)
+
+	// The feedback is allowed to lag, but not for three steps in a row.
+	for idx := 0; idx < 3; idx++ {
+		mock.ExpectWrite(levelUp).Reply(ackLevel).ThenPush(speed(30))
+	}
+
+	s.Require().ErrorIs(tm.SetTargetSpeed(context.Background(), 35), treadonme.ErrTargetInterrupted)
+	s.Require().True(mock.AssertExpectations())
+}
+
+func (s *TargetTestSuite) TestRegress() {
+	mock, tm := s.treadmill(30)
+
+	// Someone turned the speed down on the console.
+	mock.ExpectWrite(levelUp).Reply(ackLevel).ThenPush(speed(29))
+
+	s.Require().ErrorIs(tm.SetTargetSpeed(context.Background(), 35), treadonme.ErrTargetInterrupted)
+	s.Require().True(mock.AssertExpectations())
+}
+
+func (s *TargetTestSuite) TestTimeout() {
+	mock, tm := s.treadmill(30)
+
+	// The step is acknowledged but the new speed is never reported.
+	mock.ExpectWrite(levelUp).Reply(ackLevel)
+
+	s.Require().ErrorIs(tm.SetTargetSpeed(context.Background(), 35), treadonme.ErrNotConfirmed)
+	s.Require().True(mock.AssertExpectations())
+
+	// Nothing acknowledges the step at all.
+	mock.ExpectWrite(levelUp).Times(10)
+
+	s.Require().ErrorIs(tm.SetTargetSpeed(context.Background(), 35), treadonme.ErrAckTimeout)
+	s.Require().True(mock.AssertExpectations())
+}
+
+func (s *TargetTestSuite) TestOutOfRange() {
+	mock, tm := s.treadmill(30)
+
+	s.Require().ErrorIs(tm.SetTargetSpeed(context.Background(), 121), treadonme.ErrTargetOutOfRange)
+	s.Require().ErrorIs(tm.SetTargetSpeed(context.Background(), 4), treadonme.ErrTargetOutOfRange)
+	s.Require().ErrorIs(tm.SetTargetIncline(context.Background(), 16), treadonme.ErrTargetOutOfRange)
+	s.Require().True(mock.AssertExpectations())
+}
+
+func TestTargetTestSuite(t *testing.T) {
+	t.Parallel()
+	suite.Run(t, new(TargetTestSuite))
+}
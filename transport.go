@@ -0,0 +1,151 @@
+package treadonme
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-ble/ble"
+)
+
+// Transport carries frames between the host and the treadmill.
+type Transport interface {
+	// Write sends a frame to the treadmill.
+	Write(frame []byte) error
+	// Close drops the connection, no more frames are received once it returns.
+	Close() error
+}
+
+// Dialer connects to the treadmill, every frame the treadmill sends is passed to recv.
+type Dialer func(ctx context.Context, recv func(frame []byte)) (Transport, error)
+
+// bleTransport talks to the treadmill through the BLE to UART module, see the README for the details.
+type bleTransport struct {
+	client   ble.Client
+	writeChr *ble.Characteristic
+	// acquired is whether the transport still holds a reference to the shared adapter.
+	acquired bool
+}
+
+// bleDialer connects to the treadmill with the given address over the shared adapter.
+func bleDialer(addr ble.Addr) Dialer {
+	return func(ctx context.Context, recv func(frame []byte)) (Transport, error) {
+		if _, err := AcquireDevice(); err != nil {
+			return nil, err
+		}
+
+		bt := &bleTransport{acquired: true}
+		if err := bt.connect(ctx, addr, recv); err != nil {
+			if closeErr := bt.Close(); closeErr != nil {
+				log.Printf("failed to clean up after failed connect: %s", closeErr)
+			}
+
+			return nil, err
+		}
+
+		return bt, nil
+	}
+}
+
+func (bt *bleTransport) connect(ctx context.Context, addr ble.Addr, recv func(frame []byte)) error {
+	var (
+		mutex sync.Mutex
+		found bool
+	)
+
+	// Limit the amount of time we'll try to connect to something reasonable.
+	toContext, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	dev, err := ble.Connect(toContext, func(a ble.Advertisement) bool {
+		// Discovered this can fire multiple times concurrently which can cause multiple connection attempts in the
+		// event we get two beacons quickly. Mutex this call and only return true once.
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if !found && a.Addr().String() == addr.String() {
+			log.Printf("found treadmill %s, connecting", a.Addr().String())
+
+			found = true
+
+			return true
+		}
+
+		return false
+	})
+	if err != nil {
+		return fmt.Errorf("problem connecting to treadmill: %w", err)
+	}
+
+	bt.client = dev
+
+	svcs, err := dev.DiscoverServices([]ble.UUID{serviceUUID})
+	if err != nil {
+		return fmt.Errorf("failed to discover services on treadmill: %w", err)
+	} else if len(svcs) == 0 {
+		return fmt.Errorf("%w: %s", ErrMissingService, serviceUUID.String())
+	}
+
+	chrs, err := dev.DiscoverCharacteristics([]ble.UUID{writeUUID, notifyUUID}, svcs[0])
+	if err != nil {
+		return fmt.Errorf("failed to discover characteristics on treadmill: %w", err)
+	} else if len(chrs) != 2 {
+		return fmt.Errorf("%w: expected 2, got: %d", ErrMissingCharacteristic, len(chrs))
+	}
+
+	notifyChr := chrs[1]
+	if chrs[0].UUID.Equal(writeUUID) {
+		bt.writeChr = chrs[0]
+	} else {
+		bt.writeChr, notifyChr = chrs[1], chrs[0]
+	}
+
+	desc, err := dev.DiscoverDescriptors(nil, notifyChr)
+	if err != nil {
+		return err
+	} else if len(desc) == 0 {
+		return fmt.Errorf("%w: %d", ErrMissingDescriptor, len(desc))
+	}
+
+	if err := dev.Subscribe(notifyChr, false, recv); err != nil {
+		return fmt.Errorf("failed to subscribe to notify characteristic: %w", err)
+	}
+
+	return nil
+}
+
+func (bt *bleTransport) Write(frame []byte) error {
+	return bt.client.WriteCharacteristic(bt.writeChr, frame, true)
+}
+
+func (bt *bleTransport) Close() (err error) {
+	// The adapter is released even if the connection can't be closed cleanly, otherwise it's never stopped.
+	defer func() {
+		if !bt.acquired {
+			return
+		}
+
+		bt.acquired = false
+
+		if releaseErr := ReleaseDevice(); releaseErr != nil && err == nil {
+			err = releaseErr
+		}
+	}()
+
+	if bt.client != nil {
+		if err := bt.client.ClearSubscriptions(); err != nil {
+			return fmt.Errorf("failed to clear treadmill client subscriptions: %w", err)
+		}
+
+		if err := bt.client.CancelConnection(); err != nil {
+			return err
+		}
+
+		bt.client = nil
+		bt.writeChr = nil
+	}
+
+	return nil
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-ble/ble"
-	"github.com/go-ble/ble/linux"
 )
 
 var (
@@ -24,150 +24,123 @@ var (
 	ErrMissingService        = fmt.Errorf("missing expected service")
 	ErrInvalidReadPayload    = fmt.Errorf("unexpected data from device")
 	ErrAckTimeout            = fmt.Errorf("failed to get acknowledgement from device")
+	ErrNotConfirmed          = fmt.Errorf("treadmill did not confirm command")
+	ErrNotConnected          = fmt.Errorf("not connected to treadmill")
 )
 
+const (
+	// ackAttempts is how many times a command is written before giving up on it being acknowledged.
+	ackAttempts = 10
+	// retryInterval is how long to wait after writing a command before checking for a response and writing it again,
+	// it needs to be at least 300ms.
+	retryInterval = 300 * time.Millisecond
+)
+
+// confirmTimeout is how long to wait for the treadmill to report a change after a command has been acknowledged.
+const confirmTimeout = 10 * time.Second
+
 type MessageListener func(Message, error)
 
 type Treadmill struct {
-	addr       ble.Addr
-	client     ble.Client
-	bleDevice  ble.Device
-	notifyChr  *ble.Characteristic
-	writeChr   *ble.Characteristic
+	dial       Dialer
+	transport  Transport
 	writeMutex sync.Mutex
+	// emergency is the number of emergency stops in progress, nothing else is written while there are any.
+	emergency int32
 
 	listeners []MessageListener
 
 	waitMutex sync.Mutex
-	waitMap   map[MessageType][]chan interface{}
-}
+	waiting   []*expectation
 
-func New(addr string) (*Treadmill, error) {
-	t := &Treadmill{
-		addr:    ble.NewAddr(addr),
-		waitMap: make(map[MessageType][]chan interface{}),
-	}
+	stateMutex sync.Mutex
+	state      treadmillState
 
-	t.AddListener(t.waitForResponseListener)
-
-	return t, nil
+	clock    Clock
+	counters *Counters
+	profile  MessageUserProfile
+	watchdog *TelemetryWatchdog
+	stops    *StopDetector
 }
 
-func (t *Treadmill) Connect(ctx context.Context) error {
-	if t.bleDevice == nil {
-		bleDevice, err := linux.NewDevice()
-		if err != nil {
-			return fmt.Errorf("problem creating new linux ble device handle: %w", err)
-		}
-
-		ble.SetDefaultDevice(bleDevice)
-		t.bleDevice = bleDevice
-	}
-
-	cleanUp := func() {
-		if err := t.Close(); err != nil {
-			log.Printf("failed to clean up after failed connect: %s", err)
-		}
-	}
-
-	var (
-		mutex sync.Mutex
-		found bool
-	)
-
-	// Limit the amount of time we'll try to connect to something reasonable.
-	toContext, cancel := context.WithTimeout(ctx, 30*time.Second)
-	defer cancel()
-
-	dev, err := ble.Connect(toContext, func(a ble.Advertisement) bool {
-		// Discovered this can fire multiple times concurrently which can cause multiple connection attempts in the
-		// event we get two beacons quickly. Mutex this call and only return true once.
-		mutex.Lock()
-		defer mutex.Unlock()
-
-		if !found && a.Addr().String() == t.addr.String() {
-			log.Printf("found treadmill %s, connecting", a.Addr().String())
-
-			found = true
-
-			return true
-		}
-
-		return false
-	})
-	if err != nil {
-		defer cleanUp()
+// treadmillState is the last known state of the treadmill as reported in messages from it.
+type treadmillState struct {
+	info        *MessageDeviceInfo
+	mode        WorkoutMode
+	speed       Speed
+	incline     byte
+	haveSpeed   bool
+	haveIncline bool
+}
 
-		return fmt.Errorf("problem connecting to treadmill: %w", err)
-	}
+// expectation is a pending wait for a message matching some criteria. It's registered before the command that causes
+// the message is written so a fast response can't be missed.
+type expectation struct {
+	match  func(Message) bool
+	result chan interface{}
+}
 
-	svcs, err := dev.DiscoverServices([]ble.UUID{serviceUUID})
-	if err != nil {
-		defer cleanUp()
+// New returns a treadmill that connects over BLE to the given address.
+func New(addr string) (*Treadmill, error) {
+	return NewWithDialer(bleDialer(ble.NewAddr(addr))), nil
+}
 
-		return fmt.Errorf("failed to discover services on treadmill: %w", err)
-	} else if len(svcs) == 0 {
-		return fmt.Errorf("%w: %s", ErrMissingService, serviceUUID.String())
+// NewWithDialer returns a treadmill that connects with the given dialer, it's used to talk to a treadmill over
+// something other than BLE, a fake in tests for instance.
+func NewWithDialer(dial Dialer) *Treadmill {
+	t := &Treadmill{
+		dial:     dial,
+		clock:    realClock{},
+		counters: NewCounters(),
+		profile:  DefaultUserProfile,
 	}
 
-	chrs, err := dev.DiscoverCharacteristics([]ble.UUID{writeUUID, notifyUUID}, svcs[0])
-	if err != nil {
-		defer cleanUp()
+	t.watchdog = NewTelemetryWatchdog(DefaultTelemetryTimeout, t.reconnect)
+	t.stops = NewStopDetector()
 
-		return fmt.Errorf("failed to discover characteristics on treadmill: %w", err)
-	} else if len(chrs) != 2 {
-		return fmt.Errorf("%w: expected 2, got: %d", ErrMissingCharacteristic, len(chrs))
-	}
+	// State has to be updated before anyone waiting on a response is woken up.
+	t.AddListener(t.stateListener)
+	t.AddListener(t.waitForResponseListener)
+	t.AddListener(t.watchdog.HandleMessage)
+	t.AddListener(t.stops.HandleMessage)
 
-	if chrs[0].UUID.Equal(writeUUID) {
-		t.writeChr, t.notifyChr = chrs[0], chrs[1]
-	} else {
-		t.writeChr, t.notifyChr = chrs[1], chrs[0]
-	}
+	return t
+}
 
-	desc, err := dev.DiscoverDescriptors(nil, t.notifyChr)
+func (t *Treadmill) Connect(ctx context.Context) error {
+	transport, err := t.dial(ctx, t.recv)
 	if err != nil {
 		return err
-	} else if len(desc) == 0 {
-		defer cleanUp()
-
-		return fmt.Errorf("%w: %d", ErrMissingDescriptor, len(desc))
-	}
-
-	if err := dev.Subscribe(t.notifyChr, false, t.recv); err != nil {
-		defer cleanUp()
-
-		return fmt.Errorf("failed to subscribe to notify characteristic: %w", err)
 	}
 
-	t.client = dev
+	t.writeMutex.Lock()
+	t.transport = transport
+	t.writeMutex.Unlock()
 
 	return nil
 }
 
+// Close disconnects from the treadmill and stops the telemetry watchdog.
 func (t *Treadmill) Close() error {
-	if t.client != nil {
-		if err := t.client.ClearSubscriptions(); err != nil {
-			return fmt.Errorf("failed to clear treadmill client subscriptions: %w", err)
-		}
+	t.watchdog.Stop()
 
-		if err := t.client.CancelConnection(); err != nil {
-			return err
-		}
+	return t.disconnect()
+}
 
-		t.client = nil
-		t.notifyChr = nil
-		t.writeChr = nil
-	}
+func (t *Treadmill) disconnect() error {
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
 
-	if t.bleDevice != nil {
-		if err := t.bleDevice.Stop(); err != nil {
-			return err
-		}
+	if t.transport == nil {
+		return nil
+	}
 
-		t.bleDevice = nil
+	if err := t.transport.Close(); err != nil {
+		return err
 	}
 
+	t.transport = nil
+
 	return nil
 }
 
@@ -180,6 +153,38 @@ func (t *Treadmill) GetDeviceInfo() (*MessageDeviceInfo, error) {
 	return msg.(*MessageDeviceInfo), nil
 }
 
+// DeviceInfo returns the device info last reported by the treadmill, or nil if it hasn't been requested yet.
+func (t *Treadmill) DeviceInfo() *MessageDeviceInfo {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+
+	return t.state.info
+}
+
+// CurrentSpeed returns the last speed reported by the treadmill, false is returned if no speed has been reported.
+func (t *Treadmill) CurrentSpeed() (Speed, bool) {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+
+	return t.state.speed, t.state.haveSpeed
+}
+
+// CurrentIncline returns the last incline reported by the treadmill, false is returned if no incline has been
+// reported.
+func (t *Treadmill) CurrentIncline() (byte, bool) {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+
+	return t.state.incline, t.state.haveIncline
+}
+
+func (t *Treadmill) CurrentMode() WorkoutMode {
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+
+	return t.state.mode
+}
+
 func (t *Treadmill) SetUserProfile(sex SexType, age byte, weight Weight, height Height) error {
 	_, err := t.writeWithResponse(&MessageUserProfile{Sex: sex, Age: age, Weight: weight, Height: height}, MessageTypeACK)
 	if err != nil {
@@ -225,133 +230,303 @@ func (t *Treadmill) SetProgram(program Program) error {
 	return nil
 }
 
+// LevelUp steps up the speed (or incline) of the current workout and waits for the treadmill to report the change.
 func (t *Treadmill) LevelUp() error {
-	_, err := t.writeWithResponse(&MessageCommand{Command: CommandTypeLevelUp}, MessageTypeACK)
-	if err != nil {
-		return err
-	}
+	_, err := t.writeWithConfirmation(context.Background(), &MessageCommand{Command: CommandTypeLevelUp}, MessageTypeACK,
+		matchLevelChange)
 
-	return nil
+	return err
 }
 
-func (t *Treadmill) Start() error {
-	profile := &MessageUserProfile{Sex: SexTypeMale, Age: 30, Weight: 155, Height: 72}
-	if _, err := t.writeWithResponse(profile, MessageTypeACK); err != nil {
-		return err
-	}
-
-	if _, err := t.writeWithResponse(&MessageProgram{ProgramManual}, MessageTypeACK); err != nil {
-		return err
-	}
+// LevelDown steps down the speed (or incline) of the current workout and waits for the treadmill to report the change.
+func (t *Treadmill) LevelDown() error {
+	_, err := t.writeWithConfirmation(context.Background(), &MessageCommand{Command: CommandTypeLevelDown},
+		MessageTypeACK, matchLevelChange)
 
-	if _, err := t.writeWithResponse(&MessageWorkoutTarget{}, MessageTypeACK); err != nil {
-		return err
-	}
+	return err
+}
 
-	if _, err := t.writeWithResponse(&MessageSetWorkoutMode{WorkoutModeStart}, MessageTypeSetWorkoutMode); err != nil {
-		return err
-	}
+// Stop ends the current workout and waits for the treadmill to report it's done.
+func (t *Treadmill) Stop() error {
+	t.stops.HostStop()
+
+	_, err := t.writeWithConfirmation(context.Background(), &MessageCommand{Command: CommandTypeStop}, MessageTypeACK,
+		func(msg Message) bool {
+			switch v := msg.(type) {
+			case *MessageWorkoutMode:
+				return v.Mode == WorkoutModeDone
+			case *MessageEndWorkout:
+				return true
+			default:
+				return false
+			}
+		})
 
-	if err := t.Close(); err != nil {
-		return err
-	}
+	return err
+}
 
-	time.Sleep(5 * time.Second)
+// Pause pauses the current workout and waits for the treadmill to report it's paused.
+func (t *Treadmill) Pause() error {
+	t.stops.HostStop()
 
-	var lastErr error
+	_, err := t.writeWithConfirmation(context.Background(), &MessageSetWorkoutMode{Mode: WorkoutModePause},
+		MessageTypeSetWorkoutMode, matchWorkoutMode(WorkoutModePause))
 
-	for idx := 0; idx < 5; idx++ {
-		if lastErr = t.Connect(context.Background()); lastErr == nil {
-			log.Printf("done")
+	return err
+}
 
-			_, err := t.GetDeviceInfo()
+// Resume resumes a paused workout and waits for the treadmill to report it's running.
+func (t *Treadmill) Resume() error {
+	_, err := t.writeWithConfirmation(context.Background(), &MessageSetWorkoutMode{Mode: WorkoutModeRunning},
+		MessageTypeSetWorkoutMode, matchWorkoutMode(WorkoutModeRunning))
 
-			return err
-		}
-	}
+	return err
+}
 
-	return lastErr
+// Start starts a manual workout without any targets, see StartWorkout to configure the workout.
+func (t *Treadmill) Start() error {
+	return t.StartWorkout(context.Background(), StartOptions{})
 }
 
 func (t *Treadmill) WaitForResponse(ctx context.Context, msgType MessageType) (Message, error) {
+	return t.await(ctx, t.expect(matchType(msgType)))
+}
+
+func (t *Treadmill) expect(match func(Message) bool) *expectation {
 	t.waitMutex.Lock()
+	defer t.waitMutex.Unlock()
+
+	exp := &expectation{match: match, result: make(chan interface{}, 1)}
+	t.waiting = append(t.waiting, exp)
 
-	waitChan := make(chan interface{})
-	defer close(waitChan)
+	return exp
+}
 
-	t.waitMap[msgType] = append(t.waitMap[msgType], waitChan)
-	t.waitMutex.Unlock()
+func (t *Treadmill) await(ctx context.Context, exp *expectation) (Message, error) {
+	defer t.unexpect(exp)
 
 	select {
-	case msg := <-waitChan:
-		switch v := msg.(type) {
-		case Message:
-			return v, nil
-		case error:
-			return nil, v
-		}
+	case result := <-exp.result:
+		return expectationResult(result)
 	case <-ctx.Done():
 		return nil, ctx.Err()
 	}
+}
 
-	// We shouldn't usually get here.
-	return nil, nil
+func (t *Treadmill) unexpect(exp *expectation) {
+	t.waitMutex.Lock()
+	defer t.waitMutex.Unlock()
+
+	for idx, e := range t.waiting {
+		if e == exp {
+			t.waiting = append(t.waiting[:idx], t.waiting[idx+1:]...)
+
+			return
+		}
+	}
 }
 
 func (t *Treadmill) waitForResponseListener(msg Message, err error) {
 	t.waitMutex.Lock()
 	defer t.waitMutex.Unlock()
 
-	if err != nil {
-		// If there's an error notify everyone and bail.
-		for _, chans := range t.waitMap {
-			for _, c := range chans {
-				c <- err
-			}
+	remaining := t.waiting[:0]
+
+	for _, exp := range t.waiting {
+		// If there's an error notify everyone, otherwise just the ones waiting on this message. Results are buffered
+		// and each expectation is only ever notified once so this never blocks.
+		switch {
+		case err != nil:
+			exp.result <- err
+		case exp.match(msg):
+			exp.result <- msg
+		default:
+			remaining = append(remaining, exp)
 		}
+	}
 
-		t.waitMap = make(map[MessageType][]chan interface{})
-	} else {
-		for _, notify := range t.waitMap[msg.MessageType()] {
-			notify <- msg
-		}
+	t.waiting = remaining
+}
+
+func expectationResult(result interface{}) (Message, error) {
+	switch v := result.(type) {
+	case Message:
+		return v, nil
+	case error:
+		return nil, v
+	default:
+		// We shouldn't usually get here.
+		return nil, nil
+	}
+}
+
+func matchType(msgType MessageType) func(Message) bool {
+	return func(msg Message) bool {
+		return msg.MessageType() == msgType
+	}
+}
+
+// matchResponse matches the response to a command, for ACKs it must be acknowledging the command that was sent.
+func matchResponse(sent Message, expect MessageType) func(Message) bool {
+	if expect != MessageTypeACK {
+		return matchType(expect)
+	}
+
+	return func(msg Message) bool {
+		ack, ok := msg.(*MessageACK)
+
+		return ok && ack.Acknowledged == sent.MessageType()
+	}
+}
+
+func matchWorkoutMode(mode WorkoutMode) func(Message) bool {
+	return func(msg Message) bool {
+		v, ok := msg.(*MessageWorkoutMode)
 
-		t.waitMap[msg.MessageType()] = make([]chan interface{}, 0, 5)
+		return ok && v.Mode == mode
 	}
 }
 
+func matchLevelChange(msg Message) bool {
+	switch msg.MessageType() {
+	case MessageTypeSpeed, MessageTypeIncline, MessageTypeLevel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (t *Treadmill) stateListener(msg Message, err error) {
+	if err != nil {
+		return
+	}
+
+	t.stateMutex.Lock()
+	defer t.stateMutex.Unlock()
+
+	switch v := msg.(type) {
+	case *MessageDeviceInfo:
+		t.state.info = v
+	case *MessageWorkoutMode:
+		t.state.mode = v.Mode
+	case *MessageSpeed:
+		t.state.speed, t.state.haveSpeed = v.Speed, true
+	case *MessageIncline:
+		t.state.incline, t.state.haveIncline = v.Incline, true
+	case *MessageWorkoutData:
+		t.state.speed, t.state.haveSpeed = v.Speed, true
+		t.state.incline, t.state.haveIncline = v.Incline, true
+	}
+}
+
+// SetClock replaces the clock the treadmill waits with, it should be called before connecting.
+func (t *Treadmill) SetClock(clock Clock) {
+	t.clock = clock
+	t.stops.SetClock(clock)
+	t.watchdog.SetClock(clock)
+}
+
+// Clock returns the clock the treadmill waits with.
+func (t *Treadmill) Clock() Clock {
+	return t.clock
+}
+
+// SetCounters replaces the counters the treadmill's traffic is counted in, it should be called before connecting.
+func (t *Treadmill) SetCounters(counters *Counters) {
+	t.counters = counters
+}
+
+func (t *Treadmill) Counters() *Counters {
+	return t.counters
+}
+
+// SetTelemetryTimeout sets how long the treadmill can go without sending workout data while running before the
+// telemetry is considered stalled and the connection is re-established, zero turns the watchdog off. It defaults to
+// DefaultTelemetryTimeout.
+func (t *Treadmill) SetTelemetryTimeout(timeout time.Duration) {
+	t.watchdog.SetTimeout(timeout)
+}
+
+// AddTelemetryListener registers a listener for the telemetry watchdog's events.
+func (t *Treadmill) AddTelemetryListener(listener TelemetryListener) {
+	t.watchdog.AddListener(listener)
+}
+
+// AddStopListener registers a listener for the workout being stopped by the runner rather than the host, for instance
+// by pulling the safety key.
+func (t *Treadmill) AddStopListener(listener StopListener) {
+	t.stops.AddListener(listener)
+}
+
+// SetStartProfile sets the user profile sent to the treadmill by Start, it's used to estimate the calories burned.
+// Profile.UserProfile converts a runner's profile into the treadmill's units.
+func (t *Treadmill) SetStartProfile(profile *MessageUserProfile) {
+	t.profile = *profile
+}
+
 func (t *Treadmill) AddListener(listener MessageListener) {
 	t.listeners = append(t.listeners, listener)
 }
 
 func (t *Treadmill) writeWithResponse(msg Message, expect MessageType) (Message, error) {
-	var response Message
-	var responseError error
+	exp := t.expect(matchResponse(msg, expect))
+	defer t.unexpect(exp)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	go func() {
-		response, responseError = t.WaitForResponse(ctx, expect)
-	}()
-
-	for idx := 0; idx < 10; idx++ {
+	for idx := 0; idx < ackAttempts; idx++ {
 		if err := t.write(msg); err != nil {
 			return nil, err
 		}
 
-		// We need to wait at least 300ms before retrying.
-		time.Sleep(300 * time.Millisecond)
+		// The treadmill needs time to catch up before it's written to again.
+		<-t.clock.After(retryInterval)
 
-		if response != nil || responseError != nil {
-			return response, responseError
+		select {
+		case result := <-exp.result:
+			return expectationResult(result)
+		default:
 		}
 	}
 
+	t.counters.ackTimeout()
+
 	return nil, fmt.Errorf("%w: waiting on %s from command %s", ErrAckTimeout, expect, msg)
 }
 
+// writeWithConfirmation writes a command and waits for its response, then waits for the treadmill to report that
+// the command took effect with a message matching confirm. The confirming message is returned.
+func (t *Treadmill) writeWithConfirmation(
+	ctx context.Context, msg Message, expect MessageType, confirm func(Message) bool,
+) (Message, error) {
+	confirmation := t.expect(confirm)
+	defer t.unexpect(confirmation)
+
+	if _, err := t.writeWithResponse(msg, expect); err != nil {
+		return nil, err
+	}
+
+	select {
+	case result := <-confirmation.result:
+		return expectationResult(result)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-t.clock.After(confirmTimeout):
+		// The confirmation might have arrived just as the timeout passed.
+		select {
+		case result := <-confirmation.result:
+			return expectationResult(result)
+		default:
+			return nil, fmt.Errorf("%w: %s", ErrNotConfirmed, msg)
+		}
+	}
+}
+
 func (t *Treadmill) write(msg Message) error {
+	return t.writeFrame(msg, false)
+}
+
+// writeFrame writes a message, unless an emergency stop is in progress and it isn't the stop itself or a reply to the
+// treadmill.
+func (t *Treadmill) writeFrame(msg Message, priority bool) error {
 	data, err := EncodeMessage(msg)
 	if err != nil {
 		return err
@@ -360,18 +535,32 @@ func (t *Treadmill) write(msg Message) error {
 	t.writeMutex.Lock()
 	defer t.writeMutex.Unlock()
 
+	// The connection is dropped while it's re-established, by the telemetry watchdog for instance.
+	if t.transport == nil {
+		return fmt.Errorf("%w: not sending %s", ErrNotConnected, msg)
+	}
+
+	reply := msg.MessageType() == MessageTypeACK || msg.MessageType() == MessageTypeWorkoutMode
+	if !priority && !reply && atomic.LoadInt32(&t.emergency) > 0 {
+		return fmt.Errorf("%w: not sending %s", ErrEmergencyStop, msg)
+	}
+
 	log.Printf("C->T: %s -- %s", hex.EncodeToString(data), msg.String())
 
-	if err := t.client.WriteCharacteristic(t.writeChr, data, true); err != nil {
+	if err := t.transport.Write(data); err != nil {
 		return err
 	}
 
+	t.counters.frameSent(msg.MessageType())
+
 	return nil
 }
 
 func (t *Treadmill) recv(data []byte) {
 	msg, err := ParseMessage(data)
 	if err != nil {
+		t.counters.parseError()
+
 		for _, l := range t.listeners {
 			l(nil, err)
 		}
@@ -380,17 +569,32 @@ func (t *Treadmill) recv(data []byte) {
 	}
 
 	log.Printf("T->C: %s -- %s", hex.EncodeToString(data), msg.String())
+	t.counters.frameReceived(msg.MessageType())
+
+	if reply := Reply(msg); reply != nil {
+		t.reply(reply)
+	}
+
+	for _, l := range t.listeners {
+		l(msg, nil)
+	}
+}
 
+// Reply returns the message the host answers a message from the treadmill with, or nil if it isn't answered.
+func Reply(msg Message) Message {
 	switch msg.MessageType() {
 	case MessageTypeACK:
 		// Don't ACK the ACK's, that'd be bad.
+		return nil
 	case MessageTypeSetWorkoutMode:
 		// Special, don't do anything.
+		return nil
 	case MessageTypeDeviceInfo:
 		// Also don't do anything.
+		return nil
 	case MessageTypeWorkoutMode:
 		// These are special, we just need to echo what we heard.
-		t.ackWorkoutMode(msg.(*MessageWorkoutMode))
+		return msg
 	case MessageTypeWorkoutData:
 		fallthrough
 	case MessageTypeHeartRateType:
@@ -418,17 +622,15 @@ func (t *Treadmill) recv(data []byte) {
 	case MessageTypeEndWorkout:
 		fallthrough
 	case MessageTypeProgramGraphics:
-		t.ackCommand(msg.MessageType())
+		return &MessageACK{Acknowledged: msg.MessageType()}
 	default:
 		log.Printf("unhandled ack condition: %s", msg)
-	}
 
-	for _, l := range t.listeners {
-		l(msg, nil)
+		return nil
 	}
 }
 
-func (t *Treadmill) ackWorkoutMode(msg *MessageWorkoutMode) {
+func (t *Treadmill) reply(msg Message) {
 	go func() {
 		if err := t.write(msg); err != nil {
 			for _, l := range t.listeners {
@@ -437,13 +639,3 @@ func (t *Treadmill) ackWorkoutMode(msg *MessageWorkoutMode) {
 		}
 	}()
 }
-
-func (t *Treadmill) ackCommand(msg MessageType) {
-	go func() {
-		if err := t.write(&MessageACK{Acknowledged: msg}); err != nil {
-			for _, l := range t.listeners {
-				l(nil, err)
-			}
-		}
-	}()
-}
@@ -0,0 +1,129 @@
+package treadtest
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// Clock is a fake clock for the treadmill to wait with. Waiting on it never blocks: After moves the clock forward by
+// the duration straight away, running everything scheduled on the way, so a scripted exchange that would take minutes
+// runs instantly. Anything the treadmill is waiting for that's scheduled within the wait has arrived by the time it
+// returns.
+type Clock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	seq    uint64
+	timers []*timer
+}
+
+type timer struct {
+	at  time.Time
+	seq uint64
+	fn  func()
+}
+
+var _ treadonme.Clock = (*Clock)(nil)
+
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.now
+}
+
+// After moves the clock forward by the duration and returns a channel that has already fired.
+func (c *Clock) After(d time.Duration) <-chan time.Time {
+	ch := make(chan time.Time, 1)
+	ch <- c.Advance(d)
+
+	return ch
+}
+
+// AfterFunc schedules fn to be called once the clock has moved forward by the duration, it's called by whatever moves
+// the clock. The returned function unschedules it again.
+func (c *Clock) AfterFunc(d time.Duration, fn func()) func() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.seq++
+	t := &timer{at: c.now.Add(d), seq: c.seq, fn: fn}
+	c.timers = append(c.timers, t)
+
+	return func() bool {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+
+		for idx, pending := range c.timers {
+			if pending == t {
+				c.timers = append(c.timers[:idx], c.timers[idx+1:]...)
+
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Advance moves the clock forward by the duration, calling everything scheduled on the way in order. It returns the
+// time the clock was moved to.
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+
+	for {
+		next := c.next(target)
+		if next == nil {
+			break
+		}
+
+		c.now = next.at
+		c.mutex.Unlock()
+
+		next.fn()
+
+		c.mutex.Lock()
+	}
+
+	// Another goroutine may have moved the clock further while the lock was released.
+	if c.now.Before(target) {
+		c.now = target
+	}
+
+	now := c.now
+	c.mutex.Unlock()
+
+	return now
+}
+
+// next removes and returns the earliest timer due by the target, timers due at the same time run in the order they
+// were scheduled.
+func (c *Clock) next(target time.Time) *timer {
+	if len(c.timers) == 0 {
+		return nil
+	}
+
+	sort.Slice(c.timers, func(i, j int) bool {
+		if c.timers[i].at.Equal(c.timers[j].at) {
+			return c.timers[i].seq < c.timers[j].seq
+		}
+
+		return c.timers[i].at.Before(c.timers[j].at)
+	})
+
+	if c.timers[0].at.After(target) {
+		return nil
+	}
+
+	next := c.timers[0]
+	c.timers = c.timers[1:]
+
+	return next
+}
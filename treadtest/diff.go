@@ -0,0 +1,53 @@
+package treadtest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// Diff describes the difference between two frames, marking the bytes that differ:
+//
+//	expected: 5B 05 04 0A 00 00 00 5D  WorkoutTarget[Time=10,Calories=0]
+//	actual:   5B 05 04 0B 00 00 00 5D  WorkoutTarget[Time=11,Calories=0]
+//	                   ^^
+func Diff(expected, actual []byte) string {
+	length := len(expected)
+	if len(actual) > length {
+		length = len(actual)
+	}
+
+	marks := make([]string, length)
+	for idx := range marks {
+		marks[idx] = "  "
+		if idx >= len(expected) || idx >= len(actual) || expected[idx] != actual[idx] {
+			marks[idx] = "^^"
+		}
+	}
+
+	return fmt.Sprintf("  expected: %s\n  actual:   %s\n            %s",
+		describe(expected), describe(actual), strings.TrimRight(strings.Join(marks, " "), " "))
+}
+
+// describe formats a frame followed by the message it decodes to.
+func describe(frame []byte) string {
+	msg, err := treadonme.ParseMessage(frame)
+	if err != nil && len(frame) > 2 {
+		// Some requests from the host, like asking for the device info, don't decode as the message they're for.
+		return fmt.Sprintf("%s  %s (%s)", format(frame), treadonme.MessageType(frame[2]), err)
+	} else if err != nil {
+		return fmt.Sprintf("%s  (%s)", format(frame), err)
+	}
+
+	return fmt.Sprintf("%s  %s", format(frame), msg)
+}
+
+func format(frame []byte) string {
+	parts := make([]string, len(frame))
+	for idx, b := range frame {
+		parts[idx] = fmt.Sprintf("%02X", b)
+	}
+
+	return strings.Join(parts, " ")
+}
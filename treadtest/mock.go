@@ -0,0 +1,354 @@
+// Package treadtest is a scripted fake treadmill for testing code built on treadonme without a treadmill. A test
+// scripts the frames it expects the host to write and what the treadmill replies with, runs the code under test
+// against Mock.Treadmill and then checks every expectation was met with Mock.AssertExpectations:
+//
+//	mock := treadtest.New(t)
+//	mock.ExpectWrite("5B01F05D").Reply("5B08F092000178050F125D").
+//		ThenEvery(time.Second, 0, "5B0F06093B0000000000050000000000015D")
+//
+//	tm := mock.Treadmill()
+//	info, err := tm.GetDeviceInfo()
+//	...
+//	mock.AssertExpectations()
+//
+// Frames are written in hex the way the README's message table has them, Frame encodes a message instead. The
+// treadmill waits with the mock's fake clock so the script runs instantly. The host's replies to the treadmill, ACKs
+// and echoes, are checked by the mock and don't need to be scripted.
+package treadtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+var ErrUnexpectedWrite = fmt.Errorf("unexpected write to treadmill")
+
+// settleTimeout is how long AssertExpectations gives the host to catch up, the host replies to the treadmill from its
+// own goroutines.
+const settleTimeout = time.Second
+
+// TestingT is the part of testing.T the mock reports failures to.
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Mock is a scripted treadmill, the host's writes have to match the expected writes in order.
+type Mock struct {
+	t     TestingT
+	clock *Clock
+
+	mutex     sync.Mutex
+	recv      func([]byte)
+	connected bool
+	connects  int
+	exchanges []*Exchange
+	next      int
+	// owed are the replies the host owes for frames the treadmill sent.
+	owed [][]byte
+}
+
+// Exchange is an expected write from the host and what the treadmill does about it.
+type Exchange struct {
+	mock    *Mock
+	frame   []byte
+	times   int
+	written int
+	replies [][]byte
+	then    []func()
+}
+
+func New(t TestingT) *Mock {
+	return &Mock{
+		t:     t,
+		clock: NewClock(time.Date(2021, time.January, 1, 0, 0, 0, 0, time.UTC)),
+	}
+}
+
+// Clock returns the fake clock the mock's treadmill waits with, and that the treadmill's pushes are scheduled on.
+func (m *Mock) Clock() *Clock {
+	return m.clock
+}
+
+// Treadmill returns a treadmill connected to the mock that waits with the mock's clock.
+func (m *Mock) Treadmill() *treadonme.Treadmill {
+	tm := treadonme.NewWithDialer(m.Dial)
+	tm.SetClock(m.clock)
+
+	if err := tm.Connect(context.Background()); err != nil {
+		m.t.Errorf("problem connecting to mock treadmill: %s", err)
+	}
+
+	return tm
+}
+
+// Dial is a treadonme.Dialer connecting to the mock, for treadmills set up by hand with treadonme.NewWithDialer.
+func (m *Mock) Dial(_ context.Context, recv func(frame []byte)) (treadonme.Transport, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.recv = recv
+	m.connected = true
+	m.connects++
+
+	return &transport{mock: m}, nil
+}
+
+// Connects returns how many times the host has connected.
+func (m *Mock) Connects() int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.connects
+}
+
+// ExpectWrite adds the frame to the writes expected from the host.
+func (m *Mock) ExpectWrite(frame string) *Exchange {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	e := &Exchange{mock: m, frame: decode(frame), times: 1}
+	m.exchanges = append(m.exchanges, e)
+
+	return e
+}
+
+// Times expects the frame to be written the given number of times in a row, when the treadmill doesn't reply to it
+// for instance.
+func (e *Exchange) Times(times int) *Exchange {
+	e.mock.mutex.Lock()
+	defer e.mock.mutex.Unlock()
+
+	e.times = times
+
+	return e
+}
+
+// Reply sends the frames to the host every time the expected frame is written, before the write returns.
+func (e *Exchange) Reply(frames ...string) *Exchange {
+	e.mock.mutex.Lock()
+	defer e.mock.mutex.Unlock()
+
+	for _, frame := range frames {
+		e.replies = append(e.replies, decode(frame))
+	}
+
+	return e
+}
+
+// ThenPush sends the frames to the host once the expected frame has been written and replied to.
+func (e *Exchange) ThenPush(frames ...string) *Exchange {
+	data := decodeAll(frames)
+
+	return e.andThen(func() {
+		e.mock.deliver(data...)
+	})
+}
+
+// ThenEvery starts sending the frame to the host every interval once the expected frame has been written, count
+// times or for as long as the test runs if it's zero.
+func (e *Exchange) ThenEvery(interval time.Duration, count int, frame string) *Exchange {
+	data := decode(frame)
+
+	return e.andThen(func() {
+		e.mock.schedule(interval, count, data)
+	})
+}
+
+func (e *Exchange) andThen(fn func()) *Exchange {
+	e.mock.mutex.Lock()
+	defer e.mock.mutex.Unlock()
+
+	e.then = append(e.then, fn)
+
+	return e
+}
+
+// Push sends the frames to the host now.
+func (m *Mock) Push(frames ...string) {
+	m.deliver(decodeAll(frames)...)
+}
+
+// Every sends the frame to the host every interval, count times or for as long as the test runs if it's zero.
+func (m *Mock) Every(interval time.Duration, count int, frame string) {
+	m.schedule(interval, count, decode(frame))
+}
+
+func (m *Mock) schedule(interval time.Duration, remaining int, frame []byte) {
+	m.clock.AfterFunc(interval, func() {
+		m.deliver(frame)
+
+		if remaining != 1 {
+			m.schedule(interval, remaining-1, frame)
+		}
+	})
+}
+
+// deliver sends frames to the host, noting the replies it owes for them. Frames sent while the host isn't connected
+// are lost like they would be over BLE.
+func (m *Mock) deliver(frames ...[]byte) {
+	for _, frame := range frames {
+		m.mutex.Lock()
+		if !m.connected {
+			m.mutex.Unlock()
+
+			continue
+		}
+
+		if msg, err := treadonme.ParseMessage(frame); err == nil {
+			if reply := treadonme.Reply(msg); reply != nil {
+				m.owed = append(m.owed, encode(reply))
+			}
+		}
+
+		recv := m.recv
+		m.mutex.Unlock()
+
+		recv(frame)
+	}
+}
+
+func (m *Mock) write(frame []byte) error {
+	m.mutex.Lock()
+
+	for idx, owed := range m.owed {
+		if bytes.Equal(owed, frame) {
+			m.owed = append(m.owed[:idx], m.owed[idx+1:]...)
+			m.mutex.Unlock()
+
+			return nil
+		}
+	}
+
+	if m.next >= len(m.exchanges) {
+		m.mutex.Unlock()
+		m.t.Errorf("%s, nothing else was expected:\n  actual:   %s", ErrUnexpectedWrite, describe(frame))
+
+		return fmt.Errorf("%w: %s", ErrUnexpectedWrite, format(frame))
+	}
+
+	e := m.exchanges[m.next]
+	if !bytes.Equal(e.frame, frame) {
+		m.mutex.Unlock()
+		m.t.Errorf("%s:\n%s", ErrUnexpectedWrite, Diff(e.frame, frame))
+
+		return fmt.Errorf("%w: %s", ErrUnexpectedWrite, format(frame))
+	}
+
+	var then []func()
+
+	if e.written++; e.written >= e.times {
+		m.next++
+		then = e.then
+	}
+
+	replies := e.replies
+	m.mutex.Unlock()
+
+	m.deliver(replies...)
+
+	for _, fn := range then {
+		fn()
+	}
+
+	return nil
+}
+
+func (m *Mock) close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.connected = false
+	// Nobody is left to reply.
+	m.owed = nil
+}
+
+// AssertExpectations reports every expected write that didn't happen and every frame the host didn't reply to,
+// returning whether there were none.
+func (m *Mock) AssertExpectations() bool {
+	if h, ok := m.t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	deadline := time.Now().Add(settleTimeout)
+	for !m.met() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ok := true
+
+	for _, e := range m.exchanges[m.next:] {
+		ok = false
+		m.t.Errorf("expected write %d more time(s):\n  expected: %s", e.times-e.written, describe(e.frame))
+	}
+
+	for _, owed := range m.owed {
+		ok = false
+		m.t.Errorf("host didn't reply to the treadmill:\n  expected: %s", describe(owed))
+	}
+
+	return ok
+}
+
+func (m *Mock) met() bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return m.next >= len(m.exchanges) && len(m.owed) == 0
+}
+
+type transport struct {
+	mock *Mock
+}
+
+func (t *transport) Write(frame []byte) error {
+	return t.mock.write(frame)
+}
+
+func (t *transport) Close() error {
+	t.mock.close()
+
+	return nil
+}
+
+// Frame encodes a message as hex for scripting.
+func Frame(msg treadonme.Message) string {
+	return strings.ToUpper(hex.EncodeToString(encode(msg)))
+}
+
+func encode(msg treadonme.Message) []byte {
+	data, err := treadonme.EncodeMessage(msg)
+	if err != nil {
+		panic(fmt.Sprintf("treadtest: can't encode %s: %s", msg, err))
+	}
+
+	return data
+}
+
+// decode turns a scripted frame into bytes, the frame can be in either case and have spaces between the bytes.
+func decode(frame string) []byte {
+	data, err := hex.DecodeString(strings.ReplaceAll(frame, " ", ""))
+	if err != nil {
+		panic(fmt.Sprintf("treadtest: invalid frame %q: %s", frame, err))
+	}
+
+	return data
+}
+
+func decodeAll(frames []string) [][]byte {
+	data := make([][]byte, 0, len(frames))
+	for _, frame := range frames {
+		data = append(data, decode(frame))
+	}
+
+	return data
+}
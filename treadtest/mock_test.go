@@ -0,0 +1,163 @@
+package treadtest_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/treadtest"
+)
+
+const (
+	deviceInfo   = "5B01F05D"
+	deviceReply  = "5B08F092000178050F125D"
+	workoutData  = "5B0F06093B0000000000050000000000015D"
+	targetTime10 = "5B05040A0000005D"
+)
+
+// recordingT records the failures reported by the mock.
+type recordingT struct {
+	mutex  sync.Mutex
+	errors []string
+}
+
+func (r *recordingT) Errorf(format string, args ...interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingT) failures() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	return append([]string(nil), r.errors...)
+}
+
+type MockTestSuite struct {
+	suite.Suite
+}
+
+func (s *MockTestSuite) TestScript() {
+	mock := treadtest.New(s.T())
+	mock.ExpectWrite(deviceInfo).Reply(deviceReply).ThenEvery(time.Second, 5, workoutData)
+
+	tm := mock.Treadmill()
+
+	var (
+		mutex    sync.Mutex
+		received int
+	)
+
+	tm.AddListener(func(msg treadonme.Message, err error) {
+		if _, ok := msg.(*treadonme.MessageWorkoutData); ok {
+			mutex.Lock()
+			received++
+			mutex.Unlock()
+		}
+	})
+
+	start := mock.Clock().Now()
+
+	info, err := tm.GetDeviceInfo()
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.DeviceModelF80, info.Model)
+
+	mock.Clock().Advance(10 * time.Second)
+	s.Require().Equal(10*time.Second+300*time.Millisecond, mock.Clock().Now().Sub(start))
+
+	mutex.Lock()
+	s.Require().Equal(5, received)
+	mutex.Unlock()
+
+	s.Require().True(mock.AssertExpectations())
+
+	speed, ok := tm.CurrentSpeed()
+	s.Require().True(ok)
+	s.Require().Equal(treadonme.Speed(5), speed)
+}
+
+func (s *MockTestSuite) TestConfirmation() {
+	mock := treadtest.New(s.T())
+	mock.ExpectWrite(treadtest.Frame(&treadonme.MessageCommand{Command: treadonme.CommandTypeStop})).
+		Reply(treadtest.Frame(&treadonme.MessageACK{Acknowledged: treadonme.MessageTypeCommand})).
+		ThenPush(treadtest.Frame(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeDone}))
+
+	tm := mock.Treadmill()
+
+	s.Require().NoError(tm.Stop())
+	s.Require().Equal(treadonme.WorkoutModeDone, tm.CurrentMode())
+
+	// The echo of the workout mode is checked without being scripted.
+	s.Require().True(mock.AssertExpectations())
+}
+
+func (s *MockTestSuite) TestAckTimeout() {
+	mock := treadtest.New(s.T())
+	mock.ExpectWrite(targetTime10).Times(10)
+
+	tm := mock.Treadmill()
+
+	start := time.Now()
+	s.Require().ErrorIs(tm.SetWorkoutTime(10*time.Minute), treadonme.ErrAckTimeout)
+	s.Require().Less(int64(time.Since(start)), int64(time.Second))
+	s.Require().True(mock.AssertExpectations())
+}
+
+func (s *MockTestSuite) TestUnexpectedWrite() {
+	rt := &recordingT{}
+
+	mock := treadtest.New(rt)
+	mock.ExpectWrite(targetTime10).Reply("5B0400044F4B5D")
+
+	tm := mock.Treadmill()
+
+	s.Require().ErrorIs(tm.SetWorkoutTime(11*time.Minute), treadtest.ErrUnexpectedWrite)
+	s.Require().False(mock.AssertExpectations())
+
+	failures := rt.failures()
+	s.Require().Len(failures, 2)
+	s.Require().Equal("unexpected write to treadmill:\n"+
+		"  expected: 5B 05 04 0A 00 00 00 5D  WorkoutTarget[Time=10,Calories=0]\n"+
+		"  actual:   5B 05 04 0B 00 00 00 5D  WorkoutTarget[Time=11,Calories=0]\n"+
+		"                     ^^", failures[0])
+	s.Require().True(strings.HasPrefix(failures[1], "expected write 1 more time(s):"))
+}
+
+func (s *MockTestSuite) TestDiffLength() {
+	s.Require().Equal(
+		"  expected: 5B 01 F0 5D  DeviceInfo (invalid message: expected 8 bytes for DeviceInfo, got: 1)\n"+
+			"  actual:   5B 02 F0 5D 00  DeviceInfo (invalid message: expected message to end with 93, got 0: 5b02f05d00)\n"+
+			"               ^^       ^^",
+		treadtest.Diff([]byte{0x5b, 0x01, 0xf0, 0x5d}, []byte{0x5b, 0x02, 0xf0, 0x5d, 0x00}),
+	)
+}
+
+func (s *MockTestSuite) TestClock() {
+	clock := treadtest.NewClock(time.Unix(0, 0))
+
+	var order []int
+
+	clock.AfterFunc(2*time.Second, func() { order = append(order, 2) })
+	clock.AfterFunc(time.Second, func() {
+		order = append(order, 1)
+		clock.AfterFunc(time.Second, func() { order = append(order, 3) })
+	})
+
+	clock.Advance(1500 * time.Millisecond)
+	s.Require().Equal([]int{1}, order)
+
+	at := <-clock.After(time.Second)
+	s.Require().Equal(time.Unix(2, 500000000), at)
+	s.Require().Equal([]int{1, 2, 3}, order)
+}
+
+func TestMockTestSuite(t *testing.T) {
+	t.Parallel()
+	suite.Run(t, new(MockTestSuite))
+}
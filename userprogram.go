@@ -0,0 +1,75 @@
+package treadonme
+
+import (
+	"fmt"
+)
+
+var ErrInvalidUserProgram = fmt.Errorf("invalid user program")
+
+type UserProgramSegment struct {
+	Speed   Speed
+	Incline byte
+}
+
+// UserProgram is a custom program stored in one of the user program slots on the console. Most consoles have 18
+// segments (see MessageDeviceInfo.UserSegment).
+type UserProgram struct {
+	Slot     Program
+	Segments []UserProgramSegment
+}
+
+// Validate checks the program fits in a user slot and within the limits of the treadmill.
+func (up *UserProgram) Validate(info *MessageDeviceInfo) error {
+	if up.Slot != ProgramUser1 && up.Slot != ProgramUser2 {
+		return fmt.Errorf("%w: %s isn't a user program slot", ErrInvalidUserProgram, up.Slot)
+	}
+
+	if len(up.Segments) != int(info.UserSegment) {
+		return fmt.Errorf("%w: expected %d segments, got %d", ErrInvalidUserProgram, info.UserSegment, len(up.Segments))
+	}
+
+	for idx, seg := range up.Segments {
+		if seg.Speed < info.MinSpeed || seg.Speed > info.MaxSpeed {
+			return fmt.Errorf("%w: segment %d speed %d is outside of %d-%d",
+				ErrInvalidUserProgram, idx, seg.Speed, info.MinSpeed, info.MaxSpeed)
+		} else if seg.Incline > info.InclineMax {
+			return fmt.Errorf("%w: segment %d incline %d is above %d",
+				ErrInvalidUserProgram, idx, seg.Incline, info.InclineMax)
+		}
+	}
+
+	return nil
+}
+
+// UploadUserProgram writes a custom program into a user program slot. The slot is selected first, then the console
+// expects a UserLevel (the segment speed) and UserIncline pair for every segment in order. Every write is
+// acknowledged so this takes a while.
+func (t *Treadmill) UploadUserProgram(program *UserProgram) error {
+	info := t.DeviceInfo()
+	if info == nil {
+		var err error
+		if info, err = t.GetDeviceInfo(); err != nil {
+			return err
+		}
+	}
+
+	if err := program.Validate(info); err != nil {
+		return err
+	}
+
+	if _, err := t.writeWithResponse(&MessageProgram{Program: program.Slot}, MessageTypeACK); err != nil {
+		return err
+	}
+
+	for _, seg := range program.Segments {
+		if _, err := t.writeWithResponse(&MessageUserLevel{Level: byte(seg.Speed)}, MessageTypeACK); err != nil {
+			return err
+		}
+
+		if _, err := t.writeWithResponse(&MessageUserIncline{Incline: seg.Incline}, MessageTypeACK); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,70 @@
+package treadonme_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+)
+
+type UserProgramTestSuite struct {
+	suite.Suite
+
+	info *treadonme.MessageDeviceInfo
+}
+
+func (s *UserProgramTestSuite) SetupTest() {
+	msg, err := treadonme.ParseMessage(fromHex("5b08f092000178050f125d"))
+	s.Require().NoError(err)
+
+	s.info = msg.(*treadonme.MessageDeviceInfo)
+}
+
+func (s *UserProgramTestSuite) program() *treadonme.UserProgram {
+	program := &treadonme.UserProgram{Slot: treadonme.ProgramUser1}
+	for idx := 0; idx < 18; idx++ {
+		program.Segments = append(program.Segments, treadonme.UserProgramSegment{Speed: 30, Incline: 2})
+	}
+
+	return program
+}
+
+func (s *UserProgramTestSuite) TestValid() {
+	s.Require().NoError(s.program().Validate(s.info))
+}
+
+func (s *UserProgramTestSuite) TestInvalid() {
+	wrongSlot := s.program()
+	wrongSlot.Slot = treadonme.ProgramHill
+
+	tooShort := s.program()
+	tooShort.Segments = tooShort.Segments[:10]
+
+	tooFast := s.program()
+	tooFast.Segments[3].Speed = 121
+
+	tooSlow := s.program()
+	tooSlow.Segments[3].Speed = 4
+
+	tooSteep := s.program()
+	tooSteep.Segments[17].Incline = 16
+
+	for _, program := range []*treadonme.UserProgram{wrongSlot, tooShort, tooFast, tooSlow, tooSteep} {
+		s.Require().ErrorIs(program.Validate(s.info), treadonme.ErrInvalidUserProgram)
+	}
+}
+
+func (s *UserProgramTestSuite) TestParseProgram() {
+	program, err := treadonme.ParseProgram("user2")
+	s.Require().NoError(err)
+	s.Require().Equal(treadonme.ProgramUser2, program)
+
+	_, err = treadonme.ParseProgram("user3")
+	s.Require().ErrorIs(err, treadonme.ErrUnknownProgram)
+}
+
+func TestUserProgramTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &UserProgramTestSuite{})
+}
@@ -0,0 +1,237 @@
+package treadonme
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTelemetryTimeout is how long a running treadmill can go without sending workout data before the watchdog
+// considers the telemetry stalled. Workout data normally arrives about once a second.
+const DefaultTelemetryTimeout = 10 * time.Second
+
+type TelemetryEventType byte
+
+const (
+	TelemetryEventStalled TelemetryEventType = iota
+	TelemetryEventReconnectFailed
+	TelemetryEventRecovered
+)
+
+func (tet TelemetryEventType) String() string {
+	switch tet {
+	case TelemetryEventStalled:
+		return "Stalled"
+	case TelemetryEventReconnectFailed:
+		return "ReconnectFailed"
+	case TelemetryEventRecovered:
+		return "Recovered"
+	default:
+		return "Unknown"
+	}
+}
+
+// TelemetryEvent is emitted by the watchdog when workout data stops arriving, every time reconnecting fails while it's
+// stalled and once it's arriving again.
+type TelemetryEvent struct {
+	Type TelemetryEventType
+	// Since is when the last workout data arrived before the stall.
+	Since time.Time
+	// At is when the event happened, for TelemetryEventRecovered it's when workout data arrived again.
+	At  time.Time
+	Err error
+}
+
+type TelemetryListener func(TelemetryEvent)
+
+// TelemetryWatchdog expects workout data at a regular cadence while the treadmill is running. If none arrives within
+// the timeout it reports the telemetry as stalled and reconnects, retrying every timeout until data arrives again or
+// the workout stops running. Register TelemetryWatchdog.HandleMessage as a listener on the treadmill.
+type TelemetryWatchdog struct {
+	timeout   time.Duration
+	reconnect func(ctx context.Context) error
+
+	mutex      sync.Mutex
+	clock      Clock
+	running    bool
+	stalled    bool
+	last       time.Time
+	stopTimer  func() bool
+	generation uint64
+	listeners  []TelemetryListener
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewTelemetryWatchdog(timeout time.Duration, reconnect func(ctx context.Context) error) *TelemetryWatchdog {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &TelemetryWatchdog{
+		timeout:   timeout,
+		reconnect: reconnect,
+		clock:     realClock{},
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+}
+
+func (tw *TelemetryWatchdog) AddListener(listener TelemetryListener) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	tw.listeners = append(tw.listeners, listener)
+}
+
+// SetClock replaces the clock the watchdog times the workout data with.
+func (tw *TelemetryWatchdog) SetClock(clock Clock) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	tw.clock = clock
+	tw.arm()
+}
+
+// SetTimeout changes the timeout, zero turns the watchdog off.
+func (tw *TelemetryWatchdog) SetTimeout(timeout time.Duration) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	tw.timeout = timeout
+	tw.arm()
+}
+
+func (tw *TelemetryWatchdog) HandleMessage(msg Message, err error) {
+	if err != nil {
+		return
+	}
+
+	var event *TelemetryEvent
+
+	tw.mutex.Lock()
+
+	switch v := msg.(type) {
+	case *MessageWorkoutMode:
+		if v.Mode == WorkoutModeRunning {
+			if !tw.running {
+				tw.running = true
+				tw.last = tw.clock.Now()
+				tw.arm()
+			}
+		} else {
+			// The treadmill only sends workout data while running, and telling us it isn't shows the link is fine.
+			event = tw.recover()
+			tw.running = false
+			tw.arm()
+		}
+	case *MessageWorkoutData:
+		event = tw.recover()
+		tw.last = tw.clock.Now()
+		tw.arm()
+	case *MessageEndWorkout:
+		event = tw.recover()
+		tw.running = false
+		tw.arm()
+	}
+
+	listeners := tw.listeners
+	tw.mutex.Unlock()
+
+	if event != nil {
+		emitTelemetry(listeners, *event)
+	}
+}
+
+// Stop stops watching until the treadmill reports it's running again and cancels any reconnect in progress.
+func (tw *TelemetryWatchdog) Stop() {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	tw.running = false
+	tw.stalled = false
+	tw.arm()
+	tw.cancel()
+	tw.ctx, tw.cancel = context.WithCancel(context.Background())
+}
+
+// Stalled returns whether the telemetry is currently stalled.
+func (tw *TelemetryWatchdog) Stalled() bool {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	return tw.stalled
+}
+
+// arm restarts the timer if the watchdog should be watching, otherwise it stops it. The generation makes sure a timer
+// that fired just as it was being replaced doesn't do anything.
+func (tw *TelemetryWatchdog) arm() {
+	if tw.stopTimer != nil {
+		tw.stopTimer()
+		tw.stopTimer = nil
+	}
+
+	tw.generation++
+
+	if !tw.running || tw.timeout <= 0 {
+		return
+	}
+
+	generation := tw.generation
+	tw.stopTimer = tw.clock.AfterFunc(tw.timeout, func() {
+		// Reconnecting can take a while, and a fake clock calls this from whatever is moving it.
+		go tw.expired(generation)
+	})
+}
+
+// recover ends a stall, returning the event to emit if there was one.
+func (tw *TelemetryWatchdog) recover() *TelemetryEvent {
+	if !tw.stalled {
+		return nil
+	}
+
+	tw.stalled = false
+
+	return &TelemetryEvent{Type: TelemetryEventRecovered, Since: tw.last, At: tw.clock.Now()}
+}
+
+func (tw *TelemetryWatchdog) expired(generation uint64) {
+	tw.mutex.Lock()
+	if generation != tw.generation {
+		tw.mutex.Unlock()
+
+		return
+	}
+
+	tw.stopTimer = nil
+	first := !tw.stalled
+	tw.stalled = true
+	since := tw.last
+	listeners := tw.listeners
+	clock := tw.clock
+	ctx := tw.ctx
+	tw.mutex.Unlock()
+
+	if first {
+		emitTelemetry(listeners, TelemetryEvent{Type: TelemetryEventStalled, Since: since, At: clock.Now()})
+	}
+
+	if err := tw.reconnect(ctx); err != nil {
+		emitTelemetry(listeners, TelemetryEvent{
+			Type: TelemetryEventReconnectFailed, Since: since, At: clock.Now(), Err: err,
+		})
+	}
+
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	// Keep trying until data arrives or the workout stops, unless something else has already rearmed the timer.
+	if tw.stalled && generation == tw.generation {
+		tw.arm()
+	}
+}
+
+func emitTelemetry(listeners []TelemetryListener, event TelemetryEvent) {
+	for _, l := range listeners {
+		l(event)
+	}
+}
@@ -0,0 +1,212 @@
+package treadonme_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/treadtest"
+)
+
+var errRadio = fmt.Errorf("radio trouble")
+
+type WatchdogTestSuite struct {
+	suite.Suite
+}
+
+// watchdog returns a watchdog with a short timeout whose reconnects fail while fail is set, and a channel the events
+// are delivered on.
+func (s *WatchdogTestSuite) watchdog(
+	fail *bool, mutex *sync.Mutex,
+) (*treadonme.TelemetryWatchdog, chan treadonme.TelemetryEvent, *int) {
+	reconnects := 0
+	events := make(chan treadonme.TelemetryEvent, 10)
+
+	wd := treadonme.NewTelemetryWatchdog(50*time.Millisecond, func(ctx context.Context) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		reconnects++
+		if *fail {
+			return errRadio
+		}
+
+		return nil
+	})
+	wd.AddListener(func(event treadonme.TelemetryEvent) {
+		events <- event
+	})
+
+	return wd, events, &reconnects
+}
+
+func (s *WatchdogTestSuite) next(events chan treadonme.TelemetryEvent) treadonme.TelemetryEvent {
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		s.FailNow("no telemetry event")
+
+		return treadonme.TelemetryEvent{}
+	}
+}
+
+func (s *WatchdogTestSuite) TestStallAndRecover() {
+	var mutex sync.Mutex
+
+	fail := true
+	wd, events, reconnects := s.watchdog(&fail, &mutex)
+
+	session := treadonme.NewSession(&treadonme.MessageDeviceInfo{Units: treadonme.UnitsTypeImperial})
+	wd.AddListener(session.HandleTelemetry)
+
+	wd.HandleMessage(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeRunning}, nil)
+	wd.HandleMessage(&treadonme.MessageWorkoutData{Speed: 30}, nil)
+
+	stalled := s.next(events)
+	s.Require().Equal(treadonme.TelemetryEventStalled, stalled.Type)
+	s.Require().True(wd.Stalled())
+
+	failed := s.next(events)
+	s.Require().Equal(treadonme.TelemetryEventReconnectFailed, failed.Type)
+	s.Require().ErrorIs(failed.Err, errRadio)
+	s.Require().Equal(stalled.Since, failed.Since)
+
+	// It keeps trying until the treadmill is back.
+	mutex.Lock()
+	fail = false
+	mutex.Unlock()
+
+	s.Require().Eventually(func() bool {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		return *reconnects >= 2
+	}, time.Second, 10*time.Millisecond)
+
+	wd.HandleMessage(&treadonme.MessageWorkoutData{Speed: 30}, nil)
+
+	recovered := s.next(events)
+	s.Require().Equal(treadonme.TelemetryEventRecovered, recovered.Type)
+	s.Require().False(wd.Stalled())
+
+	gaps := session.Gaps()
+	s.Require().Len(gaps, 1)
+	s.Require().Equal(stalled.Since, gaps[0].Start)
+	s.Require().Equal(recovered.At, gaps[0].End)
+
+	wd.Stop()
+}
+
+func (s *WatchdogTestSuite) TestOnlyWhileRunning() {
+	var mutex sync.Mutex
+
+	fail := false
+	wd, events, reconnects := s.watchdog(&fail, &mutex)
+
+	// Nothing is expected before the workout is running or while it's paused.
+	wd.HandleMessage(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeRunning}, nil)
+	wd.HandleMessage(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModePause}, nil)
+	time.Sleep(150 * time.Millisecond)
+
+	// Workout data arriving in time keeps it quiet.
+	wd.HandleMessage(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeRunning}, nil)
+
+	for idx := 0; idx < 5; idx++ {
+		time.Sleep(20 * time.Millisecond)
+		wd.HandleMessage(&treadonme.MessageWorkoutData{}, nil)
+	}
+
+	wd.Stop()
+	time.Sleep(150 * time.Millisecond)
+
+	s.Require().Empty(events)
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	s.Require().Zero(*reconnects)
+}
+
+func (s *WatchdogTestSuite) TestClock() {
+	var mutex sync.Mutex
+
+	fail := false
+	wd, events, _ := s.watchdog(&fail, &mutex)
+
+	clock := treadtest.NewClock(time.Unix(1651406400, 0))
+	wd.SetClock(clock)
+
+	wd.HandleMessage(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModeRunning}, nil)
+	clock.Advance(40 * time.Millisecond)
+	wd.HandleMessage(&treadonme.MessageWorkoutData{}, nil)
+	clock.Advance(40 * time.Millisecond)
+	s.Require().Empty(events)
+
+	clock.Advance(10 * time.Millisecond)
+	stalled := s.next(events)
+	s.Require().Equal(treadonme.TelemetryEventStalled, stalled.Type)
+	s.Require().Equal(time.Unix(1651406400, 0).Add(40*time.Millisecond), stalled.Since)
+	s.Require().Equal(time.Unix(1651406400, 0).Add(90*time.Millisecond), stalled.At)
+
+	wd.Stop()
+}
+
+// TestWriteWhileReconnecting checks writes fail while the watchdog has dropped the connection to re-establish it.
+func (s *WatchdogTestSuite) TestWriteWhileReconnecting() {
+	ft := &fakeTransport{respond: func(frame string, _ int) []string {
+		if frame == "5B01F05D" {
+			return []string{"5B08F092000178050F125D"}
+		}
+
+		return nil
+	}}
+
+	dials := 0
+	reconnecting := make(chan struct{})
+	release := make(chan struct{})
+
+	tm := treadonme.NewWithDialer(func(ctx context.Context, recv func([]byte)) (treadonme.Transport, error) {
+		// Hold up the first reconnect until the writes have been tried.
+		if dials++; dials == 2 {
+			close(reconnecting)
+			<-release
+		}
+
+		return ft.dial(ctx, recv)
+	})
+	s.Require().NoError(tm.Connect(context.Background()))
+
+	defer func() { s.Require().NoError(tm.Close()) }()
+
+	tm.SetTelemetryTimeout(50 * time.Millisecond)
+	ft.push("5B0203045D")
+
+	select {
+	case <-reconnecting:
+	case <-time.After(time.Second):
+		s.FailNow("watchdog didn't reconnect")
+	}
+
+	s.Require().ErrorIs(tm.SetWorkoutTime(10*time.Minute), treadonme.ErrNotConnected)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	s.Require().ErrorIs(tm.EmergencyStop(ctx), context.DeadlineExceeded)
+
+	close(release)
+	s.Require().Eventually(func() bool {
+		return tm.DeviceInfo() != nil
+	}, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestWatchdogTestSuite(t *testing.T) {
+	t.Parallel()
+
+	suite.Run(t, &WatchdogTestSuite{})
+}
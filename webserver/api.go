@@ -0,0 +1,538 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/ftms"
+	"github.com/swedishborgie/treadonme/history"
+	"github.com/swedishborgie/treadonme/profiles"
+)
+
+var (
+	errMethodNotAllowed = fmt.Errorf("method not allowed")
+	errBadRequest       = fmt.Errorf("bad request")
+	errNotFound         = fmt.Errorf("not found")
+)
+
+type apiError struct {
+	Error apiErrorBody `json:"error"`
+}
+
+type apiErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type startRequest struct {
+	User           string `json:"user"`
+	Program        string `json:"program"`
+	TargetMinutes  int    `json:"target_minutes"`
+	TargetCalories uint16 `json:"target_calories"`
+	MaxIncline     byte   `json:"max_incline"`
+}
+
+func (req *startRequest) options() (treadonme.StartOptions, error) {
+	opts := treadonme.StartOptions{
+		TargetTime:     time.Duration(req.TargetMinutes) * time.Minute,
+		TargetCalories: req.TargetCalories,
+		MaxIncline:     req.MaxIncline,
+	}
+
+	if req.Program != "" {
+		program, err := treadonme.ParseProgram(req.Program)
+		if err != nil {
+			return opts, fmt.Errorf("%w: %s", errBadRequest, err)
+		}
+
+		opts.Program = program
+	}
+
+	return opts, nil
+}
+
+type statusResponse struct {
+	State     string           `json:"state"`
+	Mode      string           `json:"mode,omitempty"`
+	SessionID string           `json:"session_id,omitempty"`
+	User      string           `json:"user,omitempty"`
+	Units     string           `json:"units,omitempty"`
+	Sample    *sampleResponse  `json:"sample,omitempty"`
+	Program   *programResponse `json:"program,omitempty"`
+	// Error is the last error reported by the treadmill during the workout, if it hasn't been cleared.
+	Error *errorEventResponse `json:"error,omitempty"`
+}
+
+type errorEventResponse struct {
+	Time        time.Time `json:"time"`
+	Code        byte      `json:"code"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Severity    string    `json:"severity"`
+}
+
+func newErrorEventResponse(event treadonme.ErrorEvent) *errorEventResponse {
+	return &errorEventResponse{
+		Time:        event.Time,
+		Code:        byte(event.Code),
+		Name:        event.Code.String(),
+		Description: event.Description,
+		Severity:    strings.ToLower(event.Severity.String()),
+	}
+}
+
+type programResponse struct {
+	Levels           []int   `json:"levels"`
+	Segment          int     `json:"segment"`
+	Row              byte    `json:"row"`
+	SegmentRemaining float64 `json:"segment_remaining"`
+}
+
+type sampleResponse struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Elapsed       float64   `json:"elapsed"`
+	Distance      float64   `json:"distance"`
+	Speed         float64   `json:"speed"`
+	Incline       byte      `json:"incline"`
+	HeartRate     byte      `json:"heart_rate"`
+	Calories      uint16    `json:"calories"`
+	ProgramRow    byte      `json:"program_row"`
+	ProgramColumn byte      `json:"program_column"`
+	Mode          string    `json:"mode"`
+}
+
+type deviceResponse struct {
+	Model       string  `json:"model"`
+	Version     byte    `json:"version"`
+	Units       string  `json:"units"`
+	MaxSpeed    float64 `json:"max_speed"`
+	MinSpeed    float64 `json:"min_speed"`
+	InclineMax  byte    `json:"incline_max"`
+	UserSegment byte    `json:"user_segment"`
+}
+
+type workoutResponse struct {
+	ID       string                `json:"id"`
+	User     string                `json:"user,omitempty"`
+	Model    string                `json:"model"`
+	Units    string                `json:"units"`
+	Start    time.Time             `json:"start"`
+	End      time.Time             `json:"end"`
+	Seconds  uint16                `json:"seconds"`
+	Distance float64               `json:"distance"`
+	Calories uint16                `json:"calories"`
+	Gaps     []gapResponse         `json:"gaps,omitempty"`
+	Errors   []*errorEventResponse `json:"errors,omitempty"`
+	Samples  []sampleResponse      `json:"samples,omitempty"`
+}
+
+// gapResponse is a span of a workout where the telemetry stalled, the end is missing if it never recovered.
+type gapResponse struct {
+	Start time.Time  `json:"start"`
+	End   *time.Time `json:"end,omitempty"`
+}
+
+// deviceSummaryResponse is a treadmill in the overview of every treadmill.
+type deviceSummaryResponse struct {
+	Name       string          `json:"name"`
+	MACAddress string          `json:"mac_address"`
+	Driver     string          `json:"driver"`
+	Status     *statusResponse `json:"status"`
+}
+
+type okResponse struct {
+	OK bool `json:"ok"`
+}
+
+// apiHandler serves the workout history and the overview of every treadmill. Each treadmill's api is served under
+// /devices/<name>, the default treadmill's api is also served without the prefix.
+func (ws *webserver) apiHandler() http.Handler {
+	deviceHandlers := make(map[string]http.Handler, len(ws.devices))
+	for _, d := range ws.devices {
+		deviceHandlers[d.name] = d.apiHandler()
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/workouts", apiMethod(http.MethodGet, ws.apiListWorkouts))
+	mux.HandleFunc("/workouts/", ws.apiWorkout)
+	mux.HandleFunc("/profiles", ws.apiProfiles)
+	mux.HandleFunc("/profiles/", ws.apiProfile)
+	mux.HandleFunc("/devices", apiMethod(http.MethodGet, ws.apiListDevices))
+	mux.HandleFunc("/devices/", func(w http.ResponseWriter, r *http.Request) {
+		name, rest := splitDevicePath(strings.TrimPrefix(r.URL.Path, "/devices/"))
+
+		handler, ok := deviceHandlers[name]
+		if !ok {
+			writeAPIError(w, fmt.Errorf("%w: %q", errUnknownDevice, name))
+
+			return
+		}
+
+		r.URL.Path = rest
+		handler.ServeHTTP(w, r)
+	})
+	mux.Handle("/", deviceHandlers[ws.defaultDevice().name])
+
+	return mux
+}
+
+func (d *device) apiHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", apiMethod(http.MethodGet, d.apiStatus))
+	mux.HandleFunc("/device", apiMethod(http.MethodGet, d.apiDevice))
+	mux.HandleFunc("/workout/", apiMethod(http.MethodPost, d.apiWorkoutControl))
+	mux.HandleFunc("/level/", apiMethod(http.MethodPost, d.apiLevelControl))
+	mux.HandleFunc("/plan", d.apiPlan)
+	mux.HandleFunc("/heartbeat", apiMethod(http.MethodPost, d.apiHeartbeat))
+	mux.HandleFunc("/stop", apiMethod(http.MethodPost, d.apiEmergencyStop))
+	mux.HandleFunc("/programs/", apiMethod(http.MethodPost, d.apiUserProgram))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, fmt.Errorf("%w: %s", errNotFound, r.URL.Path))
+	})
+
+	return mux
+}
+
+func apiMethod(method string, handler func(*http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeAPIError(w, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+
+			return
+		}
+
+		serveAPI(w, r, handler)
+	}
+}
+
+func (ws *webserver) apiListDevices(r *http.Request) (interface{}, error) {
+	resp := make([]*deviceSummaryResponse, 0, len(ws.devices))
+	for _, d := range ws.devices {
+		resp = append(resp, &deviceSummaryResponse{
+			Name:       d.name,
+			MACAddress: d.macAddress,
+			Driver:     d.driver,
+			Status:     d.status(),
+		})
+	}
+
+	return resp, nil
+}
+
+func (d *device) apiStatus(r *http.Request) (interface{}, error) {
+	return d.status(), nil
+}
+
+func (d *device) status() *statusResponse {
+	d.tmMutex.Lock()
+	connected, starting, session, program := d.tmClient != nil, d.starting, d.session, d.program
+	d.tmMutex.Unlock()
+
+	status := &statusResponse{State: "disconnected"}
+
+	switch {
+	case connected:
+		status.State = "connected"
+	case starting:
+		status.State = "starting"
+	}
+
+	// Only report on the session while it's still in progress.
+	if connected && session != nil {
+		status.Mode = session.Mode().String()
+		status.SessionID = session.ID
+		status.User = session.User
+		status.Units = session.Units.String()
+
+		if sample, ok := session.LastSample(); ok {
+			status.Sample = newSampleResponse(sample)
+		}
+
+		if reported := session.Errors(); len(reported) > 0 && reported[len(reported)-1].Code != treadonme.ErrorCodeNone {
+			status.Error = newErrorEventResponse(reported[len(reported)-1])
+		}
+	}
+
+	if connected && program != nil {
+		if profile, ok := program.Profile(); ok {
+			status.Program = newProgramResponse(profile)
+		}
+	}
+
+	return status
+}
+
+func (d *device) apiDevice(r *http.Request) (interface{}, error) {
+	d.tmMutex.Lock()
+	info := d.devInfo
+	d.tmMutex.Unlock()
+
+	if info == nil {
+		return nil, errNotStarted
+	}
+
+	return &deviceResponse{
+		Model:       info.Model.String(),
+		Version:     info.Version,
+		Units:       info.Units.String(),
+		MaxSpeed:    info.MaxSpeed.Float(),
+		MinSpeed:    info.MinSpeed.Float(),
+		InclineMax:  info.InclineMax,
+		UserSegment: info.UserSegment,
+	}, nil
+}
+
+func (d *device) apiWorkoutControl(r *http.Request) (interface{}, error) {
+	action := strings.TrimPrefix(r.URL.Path, "/workout/")
+
+	switch action {
+	case "start":
+		req := &startRequest{}
+		if err := decodeAPIRequest(r, req); err != nil {
+			return nil, err
+		}
+
+		opts, err := req.options()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := d.startTreadmill(apiSource(r), req.User, opts); err != nil {
+			return nil, err
+		}
+	case "stop", "pause", "resume":
+		if err := d.runCommand(apiSource(r), action, ""); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown workout action %q", errNotFound, action)
+	}
+
+	return d.status(), nil
+}
+
+func (d *device) apiLevelControl(r *http.Request) (interface{}, error) {
+	action := strings.TrimPrefix(r.URL.Path, "/level/")
+
+	switch action {
+	case "up", "down":
+		if err := d.runCommand(apiSource(r), "level"+action, ""); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown level action %q", errNotFound, action)
+	}
+
+	return d.status(), nil
+}
+
+func (ws *webserver) apiListWorkouts(r *http.Request) (interface{}, error) {
+	query := history.Query{User: r.URL.Query().Get("user")}
+
+	for param, target := range map[string]*time.Time{"from": &query.From, "to": &query.To} {
+		if value := r.URL.Query().Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s must be an RFC 3339 timestamp", errBadRequest, param)
+			}
+
+			*target = parsed
+		}
+	}
+
+	workouts, err := ws.history.List(query)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]*workoutResponse, 0, len(workouts))
+	for _, w := range workouts {
+		resp = append(resp, newWorkoutResponse(w))
+	}
+
+	return resp, nil
+}
+
+func (ws *webserver) apiWorkout(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/workouts/")
+
+	switch r.Method {
+	case http.MethodGet:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			workout, err := ws.history.Get(id)
+			if err != nil {
+				return nil, err
+			}
+
+			resp := newWorkoutResponse(workout)
+			for _, sample := range workout.Samples {
+				resp.Samples = append(resp.Samples, *newSampleResponse(sample))
+			}
+
+			return resp, nil
+		})
+	case http.MethodDelete:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			if err := ws.history.Delete(id); err != nil {
+				return nil, err
+			}
+
+			return &okResponse{OK: true}, nil
+		})
+	default:
+		w.Header().Set("Allow", "GET, DELETE")
+		writeAPIError(w, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+	}
+}
+
+func newSampleResponse(sample treadonme.Sample) *sampleResponse {
+	return &sampleResponse{
+		Timestamp:     sample.Timestamp,
+		Elapsed:       sample.Elapsed.Seconds(),
+		Distance:      float64(sample.Distance) / 100,
+		Speed:         sample.Speed.Float(),
+		Incline:       sample.Incline,
+		HeartRate:     sample.HeartRate,
+		Calories:      sample.Calories,
+		ProgramRow:    sample.ProgramRow,
+		ProgramColumn: sample.ProgramColumn,
+		Mode:          sample.Mode.String(),
+	}
+}
+
+func newProgramResponse(profile treadonme.ProgramProfile) *programResponse {
+	// Levels are converted so they aren't encoded as base64.
+	levels := make([]int, len(profile.Levels))
+	for idx, level := range profile.Levels {
+		levels[idx] = int(level)
+	}
+
+	return &programResponse{
+		Levels:           levels,
+		Segment:          profile.Segment,
+		Row:              profile.Row,
+		SegmentRemaining: profile.SegmentRemaining.Seconds(),
+	}
+}
+
+func newWorkoutResponse(w *history.Workout) *workoutResponse {
+	resp := &workoutResponse{
+		ID:    w.ID,
+		User:  w.User,
+		Model: w.Model.String(),
+		Units: w.Units.String(),
+		Start: w.Start,
+		End:   w.End,
+	}
+
+	for _, gap := range w.Gaps {
+		g := gapResponse{Start: gap.Start}
+		if !gap.End.IsZero() {
+			end := gap.End
+			g.End = &end
+		}
+
+		resp.Gaps = append(resp.Gaps, g)
+	}
+
+	for _, event := range w.Errors {
+		resp.Errors = append(resp.Errors, newErrorEventResponse(event))
+	}
+
+	if w.Summary != nil {
+		resp.Seconds = w.Summary.Seconds
+		resp.Distance = float64(w.Summary.Distance) / 100
+		resp.Calories = w.Summary.Calories
+	}
+
+	return resp
+}
+
+func decodeAPIRequest(r *http.Request, req interface{}) error {
+	// An empty body is fine, all request fields are optional.
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+
+	return nil
+}
+
+func serveAPI(w http.ResponseWriter, r *http.Request, handler func(*http.Request) (interface{}, error)) {
+	resp, err := handler(r)
+	if err != nil {
+		writeAPIError(w, err)
+
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	status, code := http.StatusInternalServerError, "internal_error"
+
+	switch {
+	case errors.Is(err, errNotFound), errors.Is(err, errUnknownDevice), errors.Is(err, history.ErrNotFound),
+		errors.Is(err, history.ErrInvalidID), errors.Is(err, profiles.ErrNotFound):
+		status, code = http.StatusNotFound, "not_found"
+	case errors.Is(err, errBadRequest):
+		status, code = http.StatusBadRequest, "bad_request"
+	case errors.Is(err, errMethodNotAllowed):
+		status, code = http.StatusMethodNotAllowed, "method_not_allowed"
+	case errors.Is(err, errPlanRunning):
+		status, code = http.StatusConflict, "plan_running"
+	case errors.Is(err, errAlreadyStarted):
+		status, code = http.StatusConflict, "already_started"
+	case errors.Is(err, errNotStarted):
+		status, code = http.StatusConflict, "not_started"
+	case errors.Is(err, errNotSupported):
+		status, code = http.StatusNotImplemented, "not_supported"
+	case errors.Is(err, treadonme.ErrTargetOutOfRange):
+		status, code = http.StatusBadRequest, "out_of_range"
+	case errors.Is(err, profiles.ErrExists):
+		status, code = http.StatusConflict, "already_exists"
+	case errors.Is(err, treadonme.ErrSafetyViolation):
+		status, code = http.StatusForbidden, "safety_violation"
+	case errors.Is(err, treadonme.ErrInvalidStartOptions):
+		status, code = http.StatusBadRequest, "invalid_start_options"
+	case errors.Is(err, treadonme.ErrInvalidProfile):
+		status, code = http.StatusBadRequest, "invalid_profile"
+	case errors.Is(err, treadonme.ErrInvalidUserProgram):
+		status, code = http.StatusBadRequest, "invalid_program"
+	case errors.Is(err, treadonme.ErrNotConfirmed):
+		status, code = http.StatusGatewayTimeout, "not_confirmed"
+	case errors.Is(err, treadonme.ErrAckTimeout), errors.Is(err, ftms.ErrControlTimeout):
+		status, code = http.StatusGatewayTimeout, "treadmill_timeout"
+	case errors.Is(err, ftms.ErrControlFailed):
+		status, code = http.StatusBadGateway, "rejected"
+	case errors.Is(err, treadonme.ErrEmergencyStop):
+		status, code = http.StatusConflict, "emergency_stop"
+	case errors.Is(err, treadonme.ErrTargetInterrupted):
+		status, code = http.StatusConflict, "target_interrupted"
+	case errors.Is(err, treadonme.ErrUnknownCurrentValue):
+		status, code = http.StatusServiceUnavailable, "unknown_state"
+	case errors.Is(err, treadonme.ErrNotConnected), errors.Is(err, ftms.ErrNotConnected):
+		status, code = http.StatusServiceUnavailable, "not_connected"
+	}
+
+	writeJSON(w, status, &apiError{Error: apiErrorBody{Code: code, Message: err.Error()}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("problem writing api response: %s", err)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/ftms"
+)
+
+type APITestSuite struct {
+	suite.Suite
+}
+
+func (s *APITestSuite) TestErrors() {
+	for _, tc := range []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{treadonme.ErrEmergencyStop, http.StatusConflict, "emergency_stop"},
+		{treadonme.ErrTargetInterrupted, http.StatusConflict, "target_interrupted"},
+		{treadonme.ErrUnknownCurrentValue, http.StatusServiceUnavailable, "unknown_state"},
+		{treadonme.ErrNotConnected, http.StatusServiceUnavailable, "not_connected"},
+		{ftms.ErrNotConnected, http.StatusServiceUnavailable, "not_connected"},
+		{fmt.Errorf("something else"), http.StatusInternalServerError, "internal_error"},
+	} {
+		w := httptest.NewRecorder()
+		writeAPIError(w, fmt.Errorf("%w: while testing", tc.err))
+		s.Require().Equal(tc.status, w.Code, tc.err)
+
+		resp := &apiError{}
+		s.Require().NoError(json.NewDecoder(w.Body).Decode(resp))
+		s.Require().Equal(tc.code, resp.Error.Code, tc.err)
+	}
+}
+
+func TestAPITestSuite(t *testing.T) {
+	t.Parallel()
+	suite.Run(t, new(APITestSuite))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+var errInvalidConfig = fmt.Errorf("invalid config")
+
+// validDeviceName keeps device names safe to use in urls and mqtt topics.
+var validDeviceName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// defaultDeviceName is the name of the treadmill configured with --mac-address.
+const defaultDeviceName = "default"
+
+type config struct {
+	Treadmills []treadmillConfig `yaml:"treadmills"`
+}
+
+type treadmillConfig struct {
+	Name        string `yaml:"name"`
+	MACAddress  string `yaml:"mac_address"`
+	Driver      string `yaml:"driver"`
+	HRMAddress  string `yaml:"hrm_address"`
+	FTMSAdapter *int   `yaml:"ftms_adapter"`
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("problem reading config: %w", err)
+	}
+
+	cfg := &config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidConfig, err)
+	}
+
+	if len(cfg.Treadmills) == 0 {
+		return nil, fmt.Errorf("%w: no treadmills configured", errInvalidConfig)
+	}
+
+	names := map[string]bool{}
+
+	for idx := range cfg.Treadmills {
+		tc := &cfg.Treadmills[idx]
+
+		if tc.Driver == "" {
+			tc.Driver = driverSole
+		}
+
+		switch {
+		case !validDeviceName.MatchString(tc.Name):
+			return nil, fmt.Errorf("%w: treadmill %d has an invalid name %q", errInvalidConfig, idx+1, tc.Name)
+		case names[tc.Name]:
+			return nil, fmt.Errorf("%w: treadmill %q is configured twice", errInvalidConfig, tc.Name)
+		case tc.MACAddress == "":
+			return nil, fmt.Errorf("%w: treadmill %q has no mac address", errInvalidConfig, tc.Name)
+		case tc.Driver != driverSole && tc.Driver != driverFTMS:
+			return nil, fmt.Errorf("%w: %q for treadmill %q", errUnknownDriver, tc.Driver, tc.Name)
+		}
+
+		names[tc.Name] = true
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/ftms"
+	"github.com/swedishborgie/treadonme/mqtt"
+)
+
+// device is a single treadmill managed by the server, every treadmill has its own connection, session, plan and
+// websocket clients.
+type device struct {
+	name        string
+	macAddress  string
+	driver      string
+	hrmAddress  string
+	ftmsAdapter int
+	server      *webserver
+
+	tmClient   treadonme.Equipment
+	tmMutex    sync.Mutex
+	devInfo    *treadonme.MessageDeviceInfo
+	session    *treadonme.Session
+	program    *treadonme.ProgramTracker
+	hrm        *treadonme.HeartRateMonitor
+	ftmsCancel context.CancelFunc
+	mqtt       *mqtt.Publisher
+	starting   bool
+
+	wsClients     []*websocket.Conn
+	wsConnections uint64
+	wsMutex       sync.Mutex
+
+	counters *treadonme.Counters
+	guard    *treadonme.Guard
+
+	planMutex  sync.Mutex
+	planCancel context.CancelFunc
+	planName   string
+	planEvent  *planEventResponse
+
+	hbMutex      sync.Mutex
+	hbTimer      *time.Timer
+	hbGeneration uint64
+	hbController string
+}
+
+func newDevice(server *webserver, tc treadmillConfig) *device {
+	ftmsAdapter := -1
+	if tc.FTMSAdapter != nil {
+		ftmsAdapter = *tc.FTMSAdapter
+	}
+
+	d := &device{
+		name:        tc.Name,
+		macAddress:  tc.MACAddress,
+		driver:      tc.Driver,
+		hrmAddress:  tc.HRMAddress,
+		ftmsAdapter: ftmsAdapter,
+		server:      server,
+		counters:    treadonme.NewCounters(),
+		guard:       treadonme.NewGuard(server.safety),
+	}
+
+	d.guard.AddAuditListener(d.auditListener)
+
+	return d
+}
+
+func (d *device) startTreadmill(source, user string, opts treadonme.StartOptions) error {
+	d.tmMutex.Lock()
+	if d.tmClient != nil || d.starting {
+		d.tmMutex.Unlock()
+
+		return errAlreadyStarted
+	}
+
+	// Connecting can take a while, don't hold the lock so status requests aren't blocked in the meantime.
+	d.starting = true
+	d.tmMutex.Unlock()
+
+	tm, session, err := d.connectTreadmill(source, user, opts)
+
+	d.tmMutex.Lock()
+	d.starting = false
+
+	if err != nil {
+		d.devInfo = nil
+		d.tmMutex.Unlock()
+
+		return err
+	}
+
+	d.tmClient = tm
+	d.session = session
+	d.tmMutex.Unlock()
+
+	d.takeControl(source)
+
+	return nil
+}
+
+// newEquipment creates a driver for the configured treadmill.
+func (d *device) newEquipment() (treadonme.Equipment, error) {
+	if d.driver == driverFTMS {
+		return ftms.NewClient(d.macAddress), nil
+	}
+
+	tm, err := treadonme.New(d.macAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	tm.SetCounters(d.counters)
+
+	return tm, nil
+}
+
+func (d *device) connectTreadmill(
+	source, user string, opts treadonme.StartOptions,
+) (treadonme.Equipment, *treadonme.Session, error) {
+	tm, err := d.newEquipment()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tm.Connect(context.Background()); err != nil {
+		return nil, nil, err
+	}
+
+	fail := func(err error) (treadonme.Equipment, *treadonme.Session, error) {
+		if closeErr := tm.Close(); closeErr != nil {
+			log.Printf("problem closing %s treadmill after failed start: %s", d.name, closeErr)
+		}
+
+		return nil, nil, err
+	}
+
+	tm.AddListener(d.treadmillListener)
+	tm.AddListener(treadonme.ErrorEventListener(d.errorListener))
+
+	devInfo, err := tm.GetDeviceInfo()
+	if err != nil {
+		return fail(err)
+	}
+
+	session := treadonme.NewSession(devInfo)
+	session.User = user
+	tm.AddListener(session.HandleMessage)
+
+	if d.server.influx != nil {
+		d.server.influx.Track(session)
+	}
+
+	if d.mqtt != nil {
+		d.mqtt.SetDeviceInfo(devInfo)
+		session.AddSampleListener(d.mqtt.HandleSample)
+	}
+
+	program := treadonme.NewProgramTracker()
+	program.AddListener(d.programListener)
+	tm.AddListener(program.HandleMessage)
+
+	d.tmMutex.Lock()
+	d.devInfo = devInfo
+	d.program = program
+	d.tmMutex.Unlock()
+
+	guarded := d.control(tm, source)
+
+	if sole, ok := tm.(*treadonme.Treadmill); ok {
+		sole.SetTelemetryTimeout(d.server.telemetryTimeout)
+		sole.AddTelemetryListener(session.HandleTelemetry)
+		sole.AddTelemetryListener(d.telemetryListener)
+		sole.AddStopListener(d.stopListener)
+
+		// Sole treadmills send their heart rate type once they're ready for the workout to be set up.
+		if _, err := sole.WaitForResponse(context.Background(), treadonme.MessageTypeHeartRateType); err != nil {
+			return fail(err)
+		}
+
+		// The treadmill uses the profile to estimate the calories burned.
+		opts.Profile = d.server.startProfile(user, devInfo.Units)
+
+		if err := guarded.Perform(treadonme.SafetyActionStart, 0, func() error {
+			return sole.StartWorkout(context.Background(), opts)
+		}); err != nil {
+			return fail(err)
+		}
+	} else if opts != (treadonme.StartOptions{}) {
+		return fail(fmt.Errorf("%w: programs and workout targets", errNotSupported))
+	} else if err := guarded.Start(); err != nil {
+		return fail(err)
+	}
+
+	if d.hrmAddress != "" {
+		d.connectHeartRateMonitor(session)
+	}
+
+	if d.ftmsAdapter >= 0 {
+		d.serveFTMS(tm)
+	}
+
+	return tm, session, nil
+}
+
+// connectHeartRateMonitor connects to the external heart rate monitor, the workout carries on with the treadmill's
+// heart rate if it can't be found.
+func (d *device) connectHeartRateMonitor(session *treadonme.Session) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.server.connectTimeout)
+	defer cancel()
+
+	hrm := treadonme.NewHeartRateMonitor(d.hrmAddress)
+	hrm.AddListener(session.HandleHeartRate)
+	hrm.AddListener(d.heartRateListener)
+
+	if err := hrm.Connect(ctx); err != nil {
+		log.Printf("problem connecting to heart rate monitor for %s at %s: %s", d.name, d.hrmAddress, err)
+
+		return
+	}
+
+	d.tmMutex.Lock()
+	d.hrm = hrm
+	d.tmMutex.Unlock()
+}
+
+// serveFTMS advertises the treadmill as a standard ftms treadmill on the second adapter until the workout ends.
+func (d *device) serveFTMS(tm treadonme.Equipment) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	name := "treadonme"
+	if d.name != defaultDeviceName {
+		name += "-" + d.name
+	}
+
+	server := ftms.NewServer(name, d.ftmsAdapter, d.control(tm, "ftms"))
+	tm.AddListener(server.HandleMessage)
+
+	go func() {
+		if err := server.Serve(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("problem serving ftms on hci%d: %s", d.ftmsAdapter, err)
+		}
+	}()
+
+	d.tmMutex.Lock()
+	d.ftmsCancel = cancel
+	d.tmMutex.Unlock()
+}
+
+// withTreadmill runs fn with the connected treadmill, if there's no workout in progress a temporary connection is made
+// for the duration of the call.
+func (d *device) withTreadmill(fn func(treadonme.Equipment) error) error {
+	d.tmMutex.Lock()
+	if tm := d.tmClient; tm != nil {
+		d.tmMutex.Unlock()
+
+		return fn(tm)
+	} else if d.starting {
+		d.tmMutex.Unlock()
+
+		return errAlreadyStarted
+	}
+
+	d.starting = true
+	d.tmMutex.Unlock()
+
+	defer func() {
+		d.tmMutex.Lock()
+		d.starting = false
+		d.tmMutex.Unlock()
+	}()
+
+	tm, err := d.newEquipment()
+	if err != nil {
+		return err
+	}
+
+	if err := tm.Connect(context.Background()); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err := tm.Close(); err != nil {
+			log.Printf("problem closing temporary treadmill connection: %s", err)
+		}
+	}()
+
+	// The treadmill won't respond to anything else until it's been asked for its device info.
+	if _, err := tm.GetDeviceInfo(); err != nil {
+		return err
+	}
+
+	return fn(tm)
+}
+
+// control wraps the treadmill so the actions sent through it are checked against the safety policy and audited as
+// coming from source.
+func (d *device) control(tm treadonme.Equipment, source string) *treadonme.GuardedEquipment {
+	return d.guard.Equipment(tm, d.name+"/"+source)
+}
+
+func (d *device) auditListener(entry *treadonme.AuditEntry) {
+	if !entry.Allowed {
+		log.Printf("blocked %s from %s: %s", entry.Action, entry.Source, entry.Error)
+	}
+
+	if d.server.audit != nil {
+		d.server.audit.Record(entry)
+	}
+}
+
+// treadmill returns the connected treadmill, or an error if there's no workout in progress.
+func (d *device) treadmill() (treadonme.Equipment, error) {
+	d.tmMutex.Lock()
+	defer d.tmMutex.Unlock()
+
+	if d.tmClient == nil {
+		return nil, errNotStarted
+	}
+
+	return d.tmClient, nil
+}
+
+func (d *device) stopTreadmill() {
+	d.stopPlan()
+	d.disarmHeartbeat()
+
+	d.tmMutex.Lock()
+	defer d.tmMutex.Unlock()
+
+	if d.tmClient == nil {
+		return
+	}
+
+	if err := d.tmClient.Close(); err != nil {
+		log.Printf("problem closing %s treadmill after workout: %s", d.name, err)
+	}
+
+	if d.ftmsCancel != nil {
+		d.ftmsCancel()
+	}
+
+	if d.hrm != nil {
+		if err := d.hrm.Close(); err != nil {
+			log.Printf("problem closing heart rate monitor after workout: %s", err)
+		}
+	}
+
+	if d.session != nil {
+		if err := d.server.history.SaveSession(d.session); err != nil {
+			log.Printf("problem saving workout %s: %s", d.session.ID, err)
+		}
+	}
+
+	if d.mqtt != nil {
+		d.mqtt.Idle()
+	}
+
+	// The session is kept around after the workout so it can still be exported.
+	d.tmClient = nil
+	d.devInfo = nil
+	d.program = nil
+	d.hrm = nil
+	d.ftmsCancel = nil
+}
+
+func (d *device) treadmillListener(msg treadonme.Message, err error) {
+	if err != nil {
+		d.notifyClients(&MessageWrapper{Error: err.Error()})
+
+		return
+	}
+
+	d.notifyClients(&MessageWrapper{Type: msg.MessageType().String(), Message: msg})
+
+	if msg.MessageType() == treadonme.MessageTypeEndWorkout {
+		go d.stopTreadmill()
+	}
+}
+
+func (d *device) programListener(profile treadonme.ProgramProfile) {
+	d.notifyClients(&MessageWrapper{Type: "ProgramProfile", Event: newProgramResponse(profile)})
+}
+
+func (d *device) errorListener(event treadonme.ErrorEvent) {
+	if event.Code != treadonme.ErrorCodeNone {
+		log.Printf("%s reported %s error E%d %s: %s", d.name, event.Severity, byte(event.Code), event.Code,
+			event.Description)
+	}
+
+	d.notifyClients(&MessageWrapper{Type: "ErrorEvent", Event: newErrorEventResponse(event)})
+}
+
+type telemetryEventResponse struct {
+	Since time.Time `json:"since"`
+	At    time.Time `json:"at"`
+	Error string    `json:"error,omitempty"`
+}
+
+func (d *device) telemetryListener(event treadonme.TelemetryEvent) {
+	resp := &telemetryEventResponse{Since: event.Since, At: event.At}
+	if event.Err != nil {
+		resp.Error = event.Err.Error()
+	}
+
+	log.Printf("%s telemetry %s since %s: %s", d.name, event.Type, event.Since.Format(time.RFC3339), resp.Error)
+
+	d.notifyClients(&MessageWrapper{Type: "Telemetry" + event.Type.String(), Event: resp})
+}
+
+func (d *device) heartRateListener(m *treadonme.HeartRateMeasurement, err error) {
+	if err != nil {
+		log.Printf("problem reading heart rate monitor: %s", err)
+
+		return
+	}
+
+	d.notifyClients(&MessageWrapper{Type: "HeartRateMeasurement", Event: m})
+}
+
+// addClient adds a websocket client and returns the source its commands are audited as, every connection gets its own.
+func (d *device) addClient(client *websocket.Conn) string {
+	d.wsMutex.Lock()
+	defer d.wsMutex.Unlock()
+	d.wsClients = append(d.wsClients, client)
+	d.wsConnections++
+
+	return fmt.Sprintf("websocket-%d", d.wsConnections)
+}
+
+func (d *device) removeClient(client *websocket.Conn) {
+	d.wsMutex.Lock()
+	defer d.wsMutex.Unlock()
+
+	scrubbed := make([]*websocket.Conn, 0, len(d.wsClients)-1)
+	for _, c := range d.wsClients {
+		if c != client {
+			scrubbed = append(scrubbed, c)
+		}
+	}
+	d.wsClients = scrubbed
+}
+
+func (d *device) notifyClients(msg *MessageWrapper) {
+	d.wsMutex.Lock()
+	defer d.wsMutex.Unlock()
+
+	for _, c := range d.wsClients {
+		if err := c.WriteJSON(msg); err != nil {
+			log.Printf("problem writing message to websocket client: %s", err)
+		}
+	}
+}
+
+// writeClient writes to a single client, websocket connections only support one concurrent writer.
+func (d *device) writeClient(c *websocket.Conn, msg *MessageWrapper) {
+	d.wsMutex.Lock()
+	defer d.wsMutex.Unlock()
+
+	if err := c.WriteJSON(msg); err != nil {
+		log.Printf("problem writing message to websocket client: %s", err)
+	}
+}
+
+func (d *device) wsEndpoint(w http.ResponseWriter, r *http.Request) {
+	c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("unable to upgrade: %s", err)
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			log.Printf("problem closing websocket client: %s", err)
+		}
+	}()
+
+	source := d.addClient(c)
+	defer d.removeClient(c)
+
+	if d.devInfo != nil {
+		if err := c.WriteJSON(&MessageWrapper{Type: treadonme.MessageTypeDeviceInfo.String(), Message: d.devInfo}); err != nil {
+			log.Printf("problem writing initial dev info to client: %s", err)
+
+			return
+		}
+	}
+
+	for {
+		cm := &ClientMessage{}
+
+		if err := c.ReadJSON(cm); err != nil {
+			log.Printf("unable to read message from websocket client: %s", err)
+
+			return
+		}
+
+		if cm.Command == "heartbeat" {
+			d.heartbeat(source)
+
+			continue
+		}
+
+		if err := d.runCommand(source, cm.Command, cm.User); err != nil {
+			log.Printf("problem running %s command: %s", cm.Command, err)
+
+			d.writeClient(c, &MessageWrapper{Error: err.Error()})
+		}
+	}
+}
+
+// mqttCommand runs commands published to the mqtt set topic, only starting and stopping workouts is supported.
+func (d *device) mqttCommand(command string) error {
+	if command != "start" && command != "stop" {
+		return fmt.Errorf("%w: %q", errUnknownCommand, command)
+	}
+
+	return d.runCommand("mqtt", command, "")
+}
+
+// runCommand runs a command from source, a short name for where it came from that's recorded in the audit log. The
+// source takes control of the workout once the command has been run.
+func (d *device) runCommand(source, command, user string) error {
+	if command == "start" {
+		return d.startTreadmill(source, user, treadonme.StartOptions{})
+	}
+
+	conn, err := d.treadmill()
+	if err != nil {
+		return err
+	}
+
+	tm := d.control(conn, source)
+
+	switch command {
+	case "stop":
+		err = tm.Stop()
+	case "pause":
+		err = tm.Pause()
+	case "resume":
+		err = tm.Resume()
+	case "levelup":
+		err = tm.LevelUp()
+	case "leveldown":
+		err = tm.LevelDown()
+	default:
+		return fmt.Errorf("%w: %q", errUnknownCommand, command)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	d.takeControl(source)
+
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+// emergencyStopTimeout is how long to keep trying to stop the treadmill.
+const emergencyStopTimeout = 10 * time.Second
+
+type stopEventResponse struct {
+	Type string    `json:"type"`
+	Time time.Time `json:"time"`
+	Mode string    `json:"mode,omitempty"`
+}
+
+func (d *device) apiEmergencyStop(r *http.Request) (interface{}, error) {
+	if err := d.emergencyStop(apiSource(r)); err != nil {
+		return nil, err
+	}
+
+	return &okResponse{OK: true}, nil
+}
+
+// emergencyStop stops the treadmill ahead of anything else being sent to it, it doesn't wait for other commands.
+func (d *device) emergencyStop(source string) error {
+	// A running plan would carry on sending commands.
+	d.stopPlan()
+
+	conn, err := d.treadmill()
+	if err != nil {
+		return err
+	}
+
+	return d.control(conn, source).Perform(treadonme.SafetyActionStop, 0, func() error {
+		stopper, ok := conn.(treadonme.EmergencyStopper)
+		if !ok {
+			return conn.Stop()
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), emergencyStopTimeout)
+		defer cancel()
+
+		return stopper.EmergencyStop(ctx)
+	})
+}
+
+// stopListener is told when the runner stops the treadmill, whatever the host was doing is abandoned.
+func (d *device) stopListener(event treadonme.StopEvent) {
+	d.stopPlan()
+
+	log.Printf("%s stopped by the runner: %s", d.name, event.Type)
+
+	resp := &stopEventResponse{Type: event.Type.String(), Time: event.Time}
+	if event.Mode != 0 {
+		resp.Mode = event.Mode.String()
+	}
+
+	d.notifyClients(&MessageWrapper{Type: "StopEvent", Event: resp})
+}
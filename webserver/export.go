@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/history"
+)
+
+func (d *device) exportEndpoint(w http.ResponseWriter, r *http.Request) {
+	workout, err := d.exportWorkout(r.URL.Query().Get("id"))
+	if errors.Is(err, history.ErrNotFound) || errors.Is(err, history.ErrInvalidID) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+
+		return
+	}
+
+	format, err := treadonme.ParseExportFormat(queryDefault(r, "format", string(treadonme.ExportFormatCSV)))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+
+		return
+	}
+
+	units := workout.Units
+	if unitsParam := r.URL.Query().Get("units"); unitsParam != "" {
+		if units, err = treadonme.ParseUnitsType(unitsParam); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"workout-%s.%s\"", workout.ID, format))
+
+	if err := treadonme.ExportSamples(w, workout.Samples, workout.Units, format, units); err != nil {
+		log.Printf("problem exporting workout %s: %s", workout.ID, err)
+	}
+}
+
+// exportWorkout finds the workout to export, either from history or the current (or most recent) session when no id
+// is given.
+func (d *device) exportWorkout(id string) (*history.Workout, error) {
+	if id != "" {
+		return d.server.history.Get(id)
+	}
+
+	d.tmMutex.Lock()
+	session := d.session
+	d.tmMutex.Unlock()
+
+	if session == nil {
+		return nil, fmt.Errorf("%w: no workout has been recorded", history.ErrNotFound)
+	}
+
+	return history.FromSession(session), nil
+}
+
+func queryDefault(r *http.Request, key, def string) string {
+	if value := r.URL.Query().Get(key); value != "" {
+		return value
+	}
+
+	return def
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	heartbeatPause = "pause"
+	heartbeatSlow  = "slow"
+)
+
+var errUnknownHeartbeatAction = fmt.Errorf("unknown heartbeat action")
+
+const (
+	// heartbeatSlowTimeout is how long to wait for the treadmill to reach the safe speed.
+	heartbeatSlowTimeout = 30 * time.Second
+	// clientIDHeader identifies a REST client so its heartbeats can be told apart from other clients on the same host.
+	clientIDHeader = "X-Client-ID"
+)
+
+type heartbeatResponse struct {
+	Armed   bool    `json:"armed"`
+	Timeout float64 `json:"timeout,omitempty"`
+	// Controller is the client in control when the heartbeat came from someone else.
+	Controller string `json:"controller,omitempty"`
+}
+
+type heartbeatLostEvent struct {
+	Source string `json:"source"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+}
+
+func (d *device) apiHeartbeat(r *http.Request) (interface{}, error) {
+	return d.heartbeat(apiSource(r)), nil
+}
+
+// apiSource identifies the client making a REST request by the ID it sent in the X-Client-ID header, or its address if
+// it didn't send one.
+func apiSource(r *http.Request) string {
+	id := r.Header.Get(clientIDHeader)
+	if id == "" {
+		id = r.RemoteAddr
+		if host, _, err := net.SplitHostPort(id); err == nil {
+			id = host
+		}
+	}
+
+	return "api-" + id
+}
+
+// takeControl records the client that last controlled the workout, only its heartbeats keep the dead-man switch
+// alive. An armed switch stays armed when another client takes control, the new client gets a full timeout to send its
+// first heartbeat.
+func (d *device) takeControl(source string) {
+	d.hbMutex.Lock()
+	defer d.hbMutex.Unlock()
+
+	if source == d.hbController {
+		return
+	}
+
+	d.hbController = source
+
+	if d.hbTimer != nil {
+		d.armHeartbeat()
+	}
+}
+
+// heartbeat arms the dead-man switch, or pushes it back if it's already armed. If there's no heartbeat within the
+// timeout the workout is paused or slowed down. It's only armed while a workout is in progress, and only once the
+// client controlling the workout has sent a heartbeat so clients that don't know about heartbeats aren't affected.
+// Heartbeats from other clients are ignored.
+func (d *device) heartbeat(source string) *heartbeatResponse {
+	timeout := d.server.heartbeatTimeout
+
+	d.tmMutex.Lock()
+	running := d.tmClient != nil
+	d.tmMutex.Unlock()
+
+	if timeout <= 0 || !running {
+		return &heartbeatResponse{}
+	}
+
+	d.hbMutex.Lock()
+	defer d.hbMutex.Unlock()
+
+	if source != d.hbController {
+		return &heartbeatResponse{Controller: d.hbController}
+	}
+
+	d.armHeartbeat()
+
+	return &heartbeatResponse{Armed: true, Timeout: timeout.Seconds()}
+}
+
+// armHeartbeat starts the timeout over, hbMutex has to be held.
+func (d *device) armHeartbeat() {
+	if d.hbTimer != nil {
+		d.hbTimer.Stop()
+	}
+
+	// The generation makes sure a timer that fired just as it was being replaced doesn't do anything.
+	d.hbGeneration++
+	generation := d.hbGeneration
+	d.hbTimer = time.AfterFunc(d.server.heartbeatTimeout, func() {
+		d.heartbeatExpired(generation)
+	})
+}
+
+// disarmHeartbeat turns off the dead-man switch and forgets the controlling client, it's called once the workout is
+// over.
+func (d *device) disarmHeartbeat() {
+	d.hbMutex.Lock()
+	defer d.hbMutex.Unlock()
+
+	if d.hbTimer != nil {
+		d.hbTimer.Stop()
+		d.hbTimer = nil
+	}
+
+	d.hbGeneration++
+	d.hbController = ""
+}
+
+func (d *device) heartbeatExpired(generation uint64) {
+	d.hbMutex.Lock()
+	if generation != d.hbGeneration {
+		d.hbMutex.Unlock()
+
+		return
+	}
+
+	source := d.hbController
+	d.hbTimer = nil
+	d.hbMutex.Unlock()
+
+	// A running plan would carry on changing the speed.
+	d.stopPlan()
+
+	event := &heartbeatLostEvent{Source: source, Action: d.server.heartbeatAction}
+
+	if err := d.heartbeatAction(); err != nil {
+		event.Error = err.Error()
+	}
+
+	log.Printf("no heartbeat from %s for %s within %s, %s: %s", source, d.name, d.server.heartbeatTimeout,
+		event.Action, event.Error)
+
+	d.notifyClients(&MessageWrapper{Type: "HeartbeatLost", Event: event})
+}
+
+func (d *device) heartbeatAction() error {
+	conn, err := d.treadmill()
+	if err != nil {
+		return err
+	}
+
+	tm := d.control(conn, "heartbeat")
+
+	if d.server.heartbeatAction != heartbeatSlow {
+		return tm.Pause()
+	}
+
+	safeSpeed := d.server.heartbeatSafeSpeed
+	if info := tm.DeviceInfo(); info != nil && safeSpeed < info.MinSpeed {
+		safeSpeed = info.MinSpeed
+	}
+
+	if speed, ok := tm.CurrentSpeed(); ok && speed <= safeSpeed {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), heartbeatSlowTimeout)
+	defer cancel()
+
+	return tm.SetTargetSpeed(ctx, safeSpeed)
+}
+
+// validHeartbeatAction checks the action given with --heartbeat-action.
+func validHeartbeatAction(action string) error {
+	if action != heartbeatPause && action != heartbeatSlow {
+		return fmt.Errorf("%w: %q", errUnknownHeartbeatAction, action)
+	}
+
+	return nil
+}
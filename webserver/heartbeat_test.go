@@ -0,0 +1,129 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/treadtest"
+)
+
+var (
+	setPause = treadtest.Frame(&treadonme.MessageSetWorkoutMode{Mode: treadonme.WorkoutModePause})
+	paused   = treadtest.Frame(&treadonme.MessageWorkoutMode{Mode: treadonme.WorkoutModePause})
+)
+
+type HeartbeatTestSuite struct {
+	suite.Suite
+}
+
+// device returns a device with a workout in progress on a mock treadmill.
+func (s *HeartbeatTestSuite) device() (*device, *treadtest.Mock) {
+	mock := treadtest.New(s.T())
+
+	d := newDevice(&webserver{heartbeatTimeout: 50 * time.Millisecond, heartbeatAction: heartbeatPause},
+		treadmillConfig{Name: "test"})
+	d.tmClient = mock.Treadmill()
+
+	s.T().Cleanup(d.disarmHeartbeat)
+
+	return d, mock
+}
+
+func (s *HeartbeatTestSuite) TestController() {
+	d, _ := s.device()
+	d.takeControl("websocket-1")
+
+	s.Require().Equal(&heartbeatResponse{Controller: "websocket-1"}, d.heartbeat("websocket-2"))
+	s.Require().Equal(&heartbeatResponse{Armed: true, Timeout: 0.05}, d.heartbeat("websocket-1"))
+}
+
+func (s *HeartbeatTestSuite) TestCommandTakesControl() {
+	d, mock := s.device()
+	d.takeControl("websocket-1")
+
+	mock.ExpectWrite(setPause).Reply(setPause).ThenPush(paused)
+	s.Require().NoError(d.runCommand("api-phone", "pause", ""))
+	s.Require().True(mock.AssertExpectations())
+
+	s.Require().False(d.heartbeat("websocket-1").Armed)
+	s.Require().True(d.heartbeat("api-phone").Armed)
+}
+
+func (s *HeartbeatTestSuite) TestOtherClientsDontKeepAlive() {
+	d, mock := s.device()
+	d.takeControl("websocket-1")
+
+	mock.ExpectWrite(setPause).Reply(setPause).ThenPush(paused)
+	s.Require().True(d.heartbeat("websocket-1").Armed)
+
+	// Another client heartbeating well within the timeout doesn't stop the workout from being paused.
+	for idx := 0; idx < 10; idx++ {
+		s.Require().False(d.heartbeat("websocket-2").Armed)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.Require().True(mock.AssertExpectations())
+	s.Require().Equal(treadonme.WorkoutModePause, d.tmClient.(*treadonme.Treadmill).CurrentMode())
+}
+
+func (s *HeartbeatTestSuite) TestHandover() {
+	d, mock := s.device()
+	d.takeControl("websocket-1")
+	s.Require().True(d.heartbeat("websocket-1").Armed)
+
+	// Most of the timeout has gone by when another client takes over, it still gets the full timeout.
+	time.Sleep(40 * time.Millisecond)
+	d.takeControl("websocket-2")
+	time.Sleep(30 * time.Millisecond)
+	s.Require().True(d.heartbeat("websocket-2").Armed)
+	s.Require().True(mock.AssertExpectations())
+
+	// Without any heartbeats from the new controller the workout is paused.
+	mock.ExpectWrite(setPause).Reply(setPause).ThenPush(paused)
+	time.Sleep(100 * time.Millisecond)
+	s.Require().True(mock.AssertExpectations())
+}
+
+func (s *HeartbeatTestSuite) TestWebsocketSources() {
+	d, _ := s.device()
+
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		sources = map[string]bool{}
+	)
+
+	for idx := 0; idx < 20; idx++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			source := d.addClient(nil)
+
+			mutex.Lock()
+			sources[source] = true
+			mutex.Unlock()
+		}()
+	}
+
+	wg.Wait()
+	s.Require().Len(sources, 20)
+}
+
+func (s *HeartbeatTestSuite) TestAPISource() {
+	r := httptest.NewRequest("POST", "/api/v1/heartbeat", nil)
+	s.Require().Equal("api-192.0.2.1", apiSource(r))
+
+	r.Header.Set(clientIDHeader, "phone")
+	s.Require().Equal("api-phone", apiSource(r))
+}
+
+func TestHeartbeatTestSuite(t *testing.T) {
+	t.Parallel()
+	suite.Run(t, new(HeartbeatTestSuite))
+}
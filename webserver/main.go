@@ -3,15 +3,20 @@ package main
 import (
 	"context"
 	"embed"
+	"fmt"
 	"io/fs"
 	"log"
 	"net/http"
 	"os"
-	"sync"
+	"path/filepath"
+	"strings"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/swedishborgie/treadonme"
+	"github.com/swedishborgie/treadonme/history"
+	"github.com/swedishborgie/treadonme/influx"
+	"github.com/swedishborgie/treadonme/mqtt"
+	"github.com/swedishborgie/treadonme/profiles"
 	"github.com/urfave/cli/v2"
 )
 
@@ -20,24 +25,47 @@ var staticFS embed.FS
 
 type webserver struct {
 	bindAddr       string
-	macAddress     string
 	connectTimeout time.Duration
-	tmClient       *treadonme.Treadmill
-	tmMutex        sync.Mutex
-	devInfo        *treadonme.MessageDeviceInfo
-
-	wsClients []*websocket.Conn
-	wsMutex   sync.Mutex
+	history        *history.Store
+	profiles       *profiles.Store
+	influx         *influx.Exporter
+	safety         treadonme.SafetyPolicy
+	audit          *treadonme.AuditLog
+	// heartbeatTimeout is how long a controlling client can go without a heartbeat, zero turns heartbeats off.
+	heartbeatTimeout   time.Duration
+	heartbeatAction    string
+	heartbeatSafeSpeed treadonme.Speed
+	// telemetryTimeout is how long a running treadmill can go without sending workout data before reconnecting.
+	telemetryTimeout time.Duration
+	// devices are the treadmills being managed, the first one is the default for the unprefixed paths.
+	devices []*device
 }
 
+var (
+	errAlreadyStarted = fmt.Errorf("a workout is already in progress")
+	errNotStarted     = fmt.Errorf("no workout is in progress")
+	errUnknownCommand = fmt.Errorf("unknown command")
+	errUnknownDriver  = fmt.Errorf("unknown driver")
+	errNotSupported   = fmt.Errorf("not supported by this treadmill")
+	errUnknownDevice  = fmt.Errorf("unknown treadmill")
+	errNoTreadmills   = fmt.Errorf("either a mac address or a config file with treadmills is required")
+)
+
+const (
+	driverSole = "sole"
+	driverFTMS = "ftms"
+)
+
 type ClientMessage struct {
 	Command string
+	User    string
 }
 
 type MessageWrapper struct {
 	Error   string
 	Type    string
 	Message treadonme.Message
+	Event   interface{} `json:",omitempty"`
 }
 
 func main() {
@@ -53,10 +81,31 @@ func main() {
 				Value:   ":8089",
 			},
 			&cli.StringFlag{
-				Name:     "mac-address",
-				Usage:    "the mac address of the treadmill",
-				EnvVars:  []string{"TREAD_MAC_ADDRESS"},
-				Required: true,
+				Name:    "mac-address",
+				Usage:   "the mac address of the treadmill",
+				EnvVars: []string{"TREAD_MAC_ADDRESS"},
+			},
+			&cli.StringFlag{
+				Name:    "config",
+				Usage:   "a yaml file with several named treadmills to manage in place of --mac-address",
+				EnvVars: []string{"TREAD_CONFIG"},
+			},
+			&cli.StringFlag{
+				Name:    "driver",
+				Usage:   "the protocol the treadmill speaks, either sole or ftms",
+				EnvVars: []string{"TREAD_DRIVER"},
+				Value:   driverSole,
+			},
+			&cli.StringFlag{
+				Name:    "hrm-address",
+				Usage:   "the mac address of an optional bluetooth heart rate monitor",
+				EnvVars: []string{"TREAD_HRM_ADDRESS"},
+			},
+			&cli.IntFlag{
+				Name:    "ftms-adapter",
+				Usage:   "the hci device id of a second adapter to advertise as an ftms treadmill on (-1 disables)",
+				EnvVars: []string{"TREAD_FTMS_ADAPTER"},
+				Value:   -1,
 			},
 			&cli.DurationFlag{
 				Name:    "connect-timeout",
@@ -64,6 +113,103 @@ func main() {
 				EnvVars: []string{"TREAD_CONNECT_TIMEOUT"},
 				Value:   60 * time.Second,
 			},
+			&cli.DurationFlag{
+				Name:    "telemetry-timeout",
+				Usage:   "how long a running treadmill can go without workout data before reconnecting, 0 to turn off",
+				EnvVars: []string{"TREAD_TELEMETRY_TIMEOUT"},
+				Value:   treadonme.DefaultTelemetryTimeout,
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-broker",
+				Usage:   "the host:port of an mqtt broker to publish workout state to (empty disables)",
+				EnvVars: []string{"TREAD_MQTT_BROKER"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-username",
+				Usage:   "the username for the mqtt broker",
+				EnvVars: []string{"TREAD_MQTT_USERNAME"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-password",
+				Usage:   "the password for the mqtt broker",
+				EnvVars: []string{"TREAD_MQTT_PASSWORD"},
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-prefix",
+				Usage:   "the topic workout state is published under, commands are read from <prefix>/set",
+				EnvVars: []string{"TREAD_MQTT_PREFIX"},
+				Value:   "treadonme",
+			},
+			&cli.StringFlag{
+				Name:    "mqtt-discovery-prefix",
+				Usage:   "the home assistant discovery prefix (empty disables discovery)",
+				EnvVars: []string{"TREAD_MQTT_DISCOVERY_PREFIX"},
+				Value:   "homeassistant",
+			},
+			&cli.StringFlag{
+				Name:    "influx-url",
+				Usage:   "the influxdb write url to push samples to, including the org, bucket and precision=ns",
+				EnvVars: []string{"TREAD_INFLUX_URL"},
+			},
+			&cli.StringFlag{
+				Name:    "influx-token",
+				Usage:   "the influxdb api token",
+				EnvVars: []string{"TREAD_INFLUX_TOKEN"},
+			},
+			&cli.StringFlag{
+				Name:    "influx-file",
+				Usage:   "a file to append samples to as influxdb line protocol instead of pushing them",
+				EnvVars: []string{"TREAD_INFLUX_FILE"},
+			},
+			&cli.Int64Flag{
+				Name:    "influx-queue-size",
+				Usage:   "the most bytes of samples kept on disk while influxdb can't be reached",
+				EnvVars: []string{"TREAD_INFLUX_QUEUE_SIZE"},
+				Value:   64 << 20,
+			},
+			&cli.Float64Flag{
+				Name:    "max-speed",
+				Usage:   "the fastest speed (in the treadmill's units) that can be set remotely, 0 for the treadmill's max",
+				EnvVars: []string{"TREAD_MAX_SPEED"},
+			},
+			&cli.IntFlag{
+				Name:    "max-incline",
+				Usage:   "the highest incline that can be set remotely, 0 for the treadmill's max",
+				EnvVars: []string{"TREAD_MAX_INCLINE"},
+			},
+			&cli.Float64Flag{
+				Name:    "max-speed-change",
+				Usage:   "how much the speed can be raised remotely every 10 seconds, 0 for no limit",
+				EnvVars: []string{"TREAD_MAX_SPEED_CHANGE"},
+			},
+			&cli.IntFlag{
+				Name:    "max-incline-change",
+				Usage:   "how much the incline can be raised remotely every 10 seconds, 0 for no limit",
+				EnvVars: []string{"TREAD_MAX_INCLINE_CHANGE"},
+			},
+			&cli.DurationFlag{
+				Name:    "heartbeat-timeout",
+				Usage:   "how long a client controlling the workout can go without sending a heartbeat, 0 to turn off",
+				EnvVars: []string{"TREAD_HEARTBEAT_TIMEOUT"},
+				Value:   15 * time.Second,
+			},
+			&cli.StringFlag{
+				Name:    "heartbeat-action",
+				Usage:   "what to do when the heartbeat is lost, either pause or slow",
+				EnvVars: []string{"TREAD_HEARTBEAT_ACTION"},
+				Value:   heartbeatPause,
+			},
+			&cli.Float64Flag{
+				Name:    "heartbeat-safe-speed",
+				Usage:   "the speed to slow down to when the heartbeat is lost, 0 for the treadmill's min",
+				EnvVars: []string{"TREAD_HEARTBEAT_SAFE_SPEED"},
+			},
+			&cli.StringFlag{
+				Name:    "data-dir",
+				Usage:   "the directory workout history is stored in",
+				EnvVars: []string{"TREAD_DATA_DIR"},
+				Value:   "data",
+			},
 		},
 	}
 
@@ -75,165 +221,225 @@ func main() {
 func run(cliCtx *cli.Context) error {
 	ws := &webserver{
 		bindAddr:       cliCtx.String("bind-address"),
-		macAddress:     cliCtx.String("mac-address"),
 		connectTimeout: cliCtx.Duration("connect-timeout"),
+		safety: treadonme.SafetyPolicy{
+			MaxSpeed:         treadonme.Speed(cliCtx.Float64("max-speed") * 10),
+			MaxIncline:       byte(cliCtx.Int("max-incline")),
+			MaxSpeedChange:   treadonme.Speed(cliCtx.Float64("max-speed-change") * 10),
+			MaxInclineChange: byte(cliCtx.Int("max-incline-change")),
+		},
+		heartbeatTimeout:   cliCtx.Duration("heartbeat-timeout"),
+		heartbeatAction:    cliCtx.String("heartbeat-action"),
+		heartbeatSafeSpeed: treadonme.Speed(cliCtx.Float64("heartbeat-safe-speed") * 10),
+		telemetryTimeout:   cliCtx.Duration("telemetry-timeout"),
 	}
 
-	log.Printf("starting server listening on %s looking for treadill at %s", ws.bindAddr, ws.macAddress)
-
-	if err := ws.start(); err != nil {
+	if err := validHeartbeatAction(ws.heartbeatAction); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (ws *webserver) start() error {
-	subDir, err := fs.Sub(staticFS, "static")
+	treadmills, err := treadmillConfigs(cliCtx)
 	if err != nil {
-		panic(err)
-	}
-	http.Handle("/", http.FileServer(http.FS(subDir)))
-	http.HandleFunc("/ws", ws.wsEndpoint)
-
-	if err := http.ListenAndServe(ws.bindAddr, nil); err != nil {
 		return err
 	}
 
-	return nil
-}
-
-func (ws *webserver) startTreadmill() error {
-	ws.tmMutex.Lock()
-	defer ws.tmMutex.Unlock()
-
-	tm, err := treadonme.New(ws.macAddress)
-	if err != nil {
-		return err
+	for _, tc := range treadmills {
+		ws.devices = append(ws.devices, newDevice(ws, tc))
 	}
 
-	if err := tm.Connect(context.Background()); err != nil {
+	store, err := history.Open(cliCtx.String("data-dir"))
+	if err != nil {
 		return err
 	}
 
-	tm.AddListener(ws.treadmillListener)
+	ws.history = store
 
-	devInfo, err := tm.GetDeviceInfo()
+	auditFile, err := os.OpenFile(filepath.Join(cliCtx.String("data-dir"), "audit.log"),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
 	if err != nil {
-		return err
+		return fmt.Errorf("problem opening audit log: %w", err)
 	}
 
-	ws.devInfo = devInfo
+	defer func() { _ = auditFile.Close() }()
+
+	ws.audit = treadonme.NewAuditLog(auditFile)
 
-	if _, err := tm.WaitForResponse(context.Background(), treadonme.MessageTypeHeartRateType); err != nil {
+	if ws.profiles, err = profiles.Open(filepath.Join(cliCtx.String("data-dir"), "profiles.json")); err != nil {
 		return err
 	}
 
-	if err := tm.Start(); err != nil {
+	if err := ws.startInflux(cliCtx); err != nil {
 		return err
 	}
 
-	ws.tmClient = tm
+	if cliCtx.String("mqtt-broker") != "" {
+		ws.startMQTT(cliCtx)
+	}
 
-	return nil
-}
+	for _, d := range ws.devices {
+		log.Printf("managing treadmill %s at %s", d.name, d.macAddress)
+	}
 
-func (ws *webserver) stopTreadmill() {
-	ws.tmMutex.Lock()
-	defer ws.tmMutex.Unlock()
+	log.Printf("starting server listening on %s", ws.bindAddr)
 
-	if err := ws.tmClient.Close(); err != nil {
-		log.Printf("problem closing treadmill after workout: %s", err)
+	if err := ws.start(); err != nil {
+		return err
 	}
 
-	ws.tmClient = nil
-	ws.devInfo = nil
+	return nil
 }
 
-func (ws *webserver) treadmillListener(msg treadonme.Message, err error) {
-	if err != nil {
-		ws.notifyClients(&MessageWrapper{Error: err.Error()})
+// treadmillConfigs returns the treadmills from the config file, or the single treadmill given by the flags.
+func treadmillConfigs(cliCtx *cli.Context) ([]treadmillConfig, error) {
+	if path := cliCtx.String("config"); path != "" {
+		cfg, err := loadConfig(path)
+		if err != nil {
+			return nil, err
+		}
 
-		return
+		return cfg.Treadmills, nil
 	}
 
-	ws.notifyClients(&MessageWrapper{Type: msg.MessageType().String(), Message: msg})
+	if cliCtx.String("mac-address") == "" {
+		return nil, errNoTreadmills
+	}
 
-	if msg.MessageType() == treadonme.MessageTypeEndWorkout {
-		go ws.stopTreadmill()
+	driver := cliCtx.String("driver")
+	if driver != driverSole && driver != driverFTMS {
+		return nil, fmt.Errorf("%w: %q", errUnknownDriver, driver)
 	}
-}
 
-func (ws *webserver) addClient(client *websocket.Conn) {
-	ws.wsMutex.Lock()
-	defer ws.wsMutex.Unlock()
-	ws.wsClients = append(ws.wsClients, client)
+	ftmsAdapter := cliCtx.Int("ftms-adapter")
+
+	return []treadmillConfig{{
+		Name:        defaultDeviceName,
+		MACAddress:  cliCtx.String("mac-address"),
+		Driver:      driver,
+		HRMAddress:  cliCtx.String("hrm-address"),
+		FTMSAdapter: &ftmsAdapter,
+	}}, nil
 }
 
-func (ws *webserver) removeClient(client *websocket.Conn) {
-	ws.wsMutex.Lock()
-	defer ws.wsMutex.Unlock()
+// startMQTT starts publishing the state of every treadmill. With several treadmills each one is published under its
+// own topic and appears as its own device in home assistant.
+func (ws *webserver) startMQTT(cliCtx *cli.Context) {
+	for _, d := range ws.devices {
+		config := mqtt.PublisherConfig{
+			Client: mqtt.Config{
+				Broker:   cliCtx.String("mqtt-broker"),
+				Username: cliCtx.String("mqtt-username"),
+				Password: cliCtx.String("mqtt-password"),
+			},
+			Prefix:          cliCtx.String("mqtt-prefix"),
+			DiscoveryPrefix: cliCtx.String("mqtt-discovery-prefix"),
+		}
 
-	scrubbed := make([]*websocket.Conn, 0, len(ws.wsClients)-1)
-	for _, c := range ws.wsClients {
-		if c != client {
-			scrubbed = append(scrubbed, c)
+		if len(ws.devices) > 1 {
+			config.Prefix += "/" + d.name
+			config.NodeID = "treadonme_" + d.name
+			config.Name = d.name
 		}
-	}
-	ws.wsClients = scrubbed
-}
 
-func (ws *webserver) notifyClients(msg *MessageWrapper) {
-	ws.wsMutex.Lock()
-	defer ws.wsMutex.Unlock()
+		d.mqtt = mqtt.NewPublisher(config, d.mqttCommand)
 
-	for _, c := range ws.wsClients {
-		if err := c.WriteJSON(msg); err != nil {
-			log.Printf("problem writing message to websocket client: %s", err)
-		}
+		go func(d *device) {
+			if err := d.mqtt.Run(context.Background()); err != nil {
+				log.Printf("mqtt publisher for %s stopped: %s", d.name, err)
+			}
+		}(d)
 	}
 }
 
-func (ws *webserver) wsEndpoint(w http.ResponseWriter, r *http.Request) {
-	c, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+// startInflux starts exporting samples to influxdb if a url or file was given.
+func (ws *webserver) startInflux(cliCtx *cli.Context) error {
+	var writer influx.Writer
+
+	switch {
+	case cliCtx.String("influx-url") != "":
+		writer = &influx.HTTPWriter{URL: cliCtx.String("influx-url"), Token: cliCtx.String("influx-token")}
+	case cliCtx.String("influx-file") != "":
+		writer = &influx.FileWriter{Path: cliCtx.String("influx-file")}
+	default:
+		return nil
+	}
+
+	queue, err := influx.OpenQueue(filepath.Join(cliCtx.String("data-dir"), ".influx-queue"),
+		cliCtx.Int64("influx-queue-size"))
 	if err != nil {
-		log.Printf("unable to upgrade: %s", err)
+		return err
 	}
-	defer func() {
-		if err := c.Close(); err != nil {
-			log.Printf("problem closing websocket client: %s", err)
+
+	ws.influx = influx.NewExporter(writer, queue, influx.ExporterConfig{})
+
+	go func() {
+		if err := ws.influx.Run(context.Background()); err != nil {
+			log.Printf("influx exporter stopped: %s", err)
 		}
 	}()
 
-	ws.addClient(c)
-	defer ws.removeClient(c)
+	return nil
+}
 
-	if ws.devInfo != nil {
-		if err := c.WriteJSON(&MessageWrapper{Type: treadonme.MessageTypeDeviceInfo.String(), Message: ws.devInfo}); err != nil {
-			log.Printf("problem writing initial dev info to client: %s", err)
+func (ws *webserver) start() error {
+	subDir, err := fs.Sub(staticFS, "static")
+	if err != nil {
+		panic(err)
+	}
+	http.Handle("/", http.FileServer(http.FS(subDir)))
+	http.HandleFunc("/ws", ws.defaultDevice().wsEndpoint)
+	http.HandleFunc("/export", ws.defaultDevice().exportEndpoint)
+	http.HandleFunc("/devices/", ws.deviceEndpoint)
+	http.HandleFunc("/metrics", ws.metricsEndpoint)
+	http.Handle("/api/v1/", http.StripPrefix("/api/v1", ws.apiHandler()))
 
-			return
-		}
+	if err := http.ListenAndServe(ws.bindAddr, nil); err != nil {
+		return err
 	}
 
-	for {
-		cm := &ClientMessage{}
+	return nil
+}
 
-		if err := c.ReadJSON(cm); err != nil {
-			log.Printf("unable to read message from websocket client: %s", err)
+// deviceEndpoint serves the websocket and export for a single treadmill at /devices/<name>/ws and
+// /devices/<name>/export.
+func (ws *webserver) deviceEndpoint(w http.ResponseWriter, r *http.Request) {
+	name, rest := splitDevicePath(strings.TrimPrefix(r.URL.Path, "/devices/"))
 
-			return
-		}
+	d, err := ws.device(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
 
-		switch cm.Command {
-		case "start":
-			if err := ws.startTreadmill(); err != nil {
-				log.Printf("problem starting treadmill: %s", err)
+		return
+	}
 
-				if writeErr := c.WriteJSON(&MessageWrapper{Error: err.Error()}); writeErr != nil {
-					log.Printf("problem writing error message to client: %s", writeErr)
-				}
-			}
+	switch rest {
+	case "/ws":
+		d.wsEndpoint(w, r)
+	case "/export":
+		d.exportEndpoint(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (ws *webserver) defaultDevice() *device {
+	return ws.devices[0]
+}
+
+func (ws *webserver) device(name string) (*device, error) {
+	for _, d := range ws.devices {
+		if d.name == name {
+			return d, nil
 		}
 	}
+
+	return nil, fmt.Errorf("%w: %q", errUnknownDevice, name)
+}
+
+// splitDevicePath splits "<name>/rest" into the name and "/rest".
+func splitDevicePath(path string) (string, string) {
+	if idx := strings.Index(path, "/"); idx >= 0 {
+		return path[:idx], path[idx:]
+	}
+
+	return path, ""
 }
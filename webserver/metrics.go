@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+var workoutModes = []treadonme.WorkoutMode{
+	treadonme.WorkoutModeIdle,
+	treadonme.WorkoutModeStart,
+	treadonme.WorkoutModeRunning,
+	treadonme.WorkoutModePause,
+	treadonme.WorkoutModeDone,
+}
+
+// deviceMetrics is a snapshot of a treadmill's state for the metrics endpoint.
+type deviceMetrics struct {
+	label       string
+	connected   bool
+	session     *treadonme.Session
+	clients     int
+	connections uint64
+	counters    treadonme.CounterSnapshot
+}
+
+func (d *device) metrics() *deviceMetrics {
+	d.tmMutex.Lock()
+	connected, session := d.tmClient != nil, d.session
+	d.tmMutex.Unlock()
+
+	d.wsMutex.Lock()
+	clients, connections := len(d.wsClients), d.wsConnections
+	d.wsMutex.Unlock()
+
+	return &deviceMetrics{
+		label:       fmt.Sprintf(`device="%s"`, d.name),
+		connected:   connected,
+		session:     session,
+		clients:     clients,
+		connections: connections,
+		counters:    d.counters.Snapshot(),
+	}
+}
+
+// metricsEndpoint serves metrics in the Prometheus text exposition format, every series has a device label.
+func (ws *webserver) metricsEndpoint(w http.ResponseWriter, r *http.Request) {
+	devices := make([]*deviceMetrics, 0, len(ws.devices))
+	for _, d := range ws.devices {
+		devices = append(devices, d.metrics())
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	mw := &metricsWriter{w: bufio.NewWriter(w)}
+
+	mw.header("treadonme_connected", "gauge", "Whether a treadmill is connected.")
+
+	for _, dm := range devices {
+		mw.value("treadonme_connected", dm.label, boolValue(dm.connected))
+	}
+
+	// Workout telemetry is only reported while a workout is in progress.
+	workouts := make([]*deviceMetrics, 0, len(devices))
+	samples := map[*deviceMetrics]treadonme.Sample{}
+
+	for _, dm := range devices {
+		if !dm.connected || dm.session == nil {
+			continue
+		}
+
+		workouts = append(workouts, dm)
+
+		if sample, ok := dm.session.LastSample(); ok {
+			samples[dm] = sample
+		}
+	}
+
+	if len(workouts) > 0 {
+		mw.header("treadonme_workout_mode", "gauge", "The current workout mode, one series per mode.")
+	}
+
+	for _, dm := range workouts {
+		mode := dm.session.Mode()
+
+		for _, m := range workoutModes {
+			mw.value("treadonme_workout_mode", fmt.Sprintf(`%s,mode="%s"`, dm.label, m), boolValue(m == mode))
+		}
+	}
+
+	mw.sampleGauge(workouts, samples, "treadonme_speed", "The current speed in the treadmill's units per hour.",
+		true, func(sample treadonme.Sample) float64 { return sample.Speed.Float() })
+	mw.sampleGauge(workouts, samples, "treadonme_incline", "The current incline.",
+		false, func(sample treadonme.Sample) float64 { return float64(sample.Incline) })
+
+	if len(samples) > 0 {
+		mw.header("treadonme_heart_rate", "gauge", "The current heart rate in beats per minute.")
+	}
+
+	for _, dm := range workouts {
+		if sample, ok := samples[dm]; ok {
+			mw.value("treadonme_heart_rate", fmt.Sprintf(`%s,source="%s"`, dm.label, sample.HeartRateSource),
+				float64(sample.HeartRate))
+		}
+	}
+
+	mw.sampleGauge(workouts, samples, "treadonme_distance",
+		"The distance covered in the workout in the treadmill's units.", true, func(sample treadonme.Sample) float64 {
+			// Distances are transmitted multiplied by one hundred.
+			return float64(sample.Distance) / 100
+		})
+	mw.sampleGauge(workouts, samples, "treadonme_calories", "The calories burned in the current workout.",
+		false, func(sample treadonme.Sample) float64 { return float64(sample.Calories) })
+
+	mw.header("treadonme_frames_sent_total", "counter", "Frames sent to the treadmill by message type.")
+
+	for _, dm := range devices {
+		for _, msgType := range sortedTypes(dm.counters.Sent) {
+			mw.value("treadonme_frames_sent_total", fmt.Sprintf(`%s,type="%s"`, dm.label, msgType),
+				float64(dm.counters.Sent[msgType]))
+		}
+	}
+
+	mw.header("treadonme_frames_received_total", "counter", "Frames received from the treadmill by message type.")
+
+	for _, dm := range devices {
+		for _, msgType := range sortedTypes(dm.counters.Received) {
+			mw.value("treadonme_frames_received_total", fmt.Sprintf(`%s,type="%s"`, dm.label, msgType),
+				float64(dm.counters.Received[msgType]))
+		}
+	}
+
+	mw.deviceMetric(devices, "treadonme_ack_timeouts_total", "counter", "Commands the treadmill didn't acknowledge.",
+		func(dm *deviceMetrics) float64 { return float64(dm.counters.AckTimeouts) })
+	mw.deviceMetric(devices, "treadonme_parse_errors_total", "counter",
+		"Frames from the treadmill that couldn't be parsed.",
+		func(dm *deviceMetrics) float64 { return float64(dm.counters.ParseErrors) })
+	mw.deviceMetric(devices, "treadonme_reconnects_total", "counter", "Reconnects to the treadmill.",
+		func(dm *deviceMetrics) float64 { return float64(dm.counters.Reconnects) })
+	mw.deviceMetric(devices, "treadonme_websocket_clients", "gauge", "Connected websocket clients.",
+		func(dm *deviceMetrics) float64 { return float64(dm.clients) })
+	mw.deviceMetric(devices, "treadonme_websocket_connections_total", "counter",
+		"Websocket clients that have connected.",
+		func(dm *deviceMetrics) float64 { return float64(dm.connections) })
+
+	if err := mw.flush(); err != nil {
+		log.Printf("problem writing metrics: %s", err)
+	}
+}
+
+// metricsWriter writes the text exposition format, the first write error is kept and returned from flush.
+type metricsWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (mw *metricsWriter) header(name, metricType, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func (mw *metricsWriter) value(name, labels string, value float64) {
+	if labels != "" {
+		name += "{" + labels + "}"
+	}
+
+	mw.printf("%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+// sampleGauge writes a gauge from the last sample of every workout in progress, optionally labelled with the units.
+func (mw *metricsWriter) sampleGauge(workouts []*deviceMetrics, samples map[*deviceMetrics]treadonme.Sample,
+	name, help string, withUnits bool, value func(treadonme.Sample) float64) {
+	if len(samples) == 0 {
+		return
+	}
+
+	mw.header(name, "gauge", help)
+
+	for _, dm := range workouts {
+		sample, ok := samples[dm]
+		if !ok {
+			continue
+		}
+
+		labels := dm.label
+		if withUnits {
+			labels += fmt.Sprintf(`,units="%s"`, dm.session.Units)
+		}
+
+		mw.value(name, labels, value(sample))
+	}
+}
+
+// deviceMetric writes a metric with one series per treadmill.
+func (mw *metricsWriter) deviceMetric(devices []*deviceMetrics, name, metricType, help string,
+	value func(*deviceMetrics) float64) {
+	mw.header(name, metricType, help)
+
+	for _, dm := range devices {
+		mw.value(name, dm.label, value(dm))
+	}
+}
+
+func (mw *metricsWriter) printf(format string, args ...interface{}) {
+	if mw.err == nil {
+		_, mw.err = fmt.Fprintf(mw.w, format, args...)
+	}
+}
+
+func (mw *metricsWriter) flush() error {
+	if mw.err != nil {
+		return mw.err
+	}
+
+	return mw.w.Flush()
+}
+
+func sortedTypes(counts map[treadonme.MessageType]uint64) []treadonme.MessageType {
+	types := make([]treadonme.MessageType, 0, len(counts))
+	for msgType := range counts {
+		types = append(types, msgType)
+	}
+
+	sort.Slice(types, func(i, j int) bool {
+		return types[i] < types[j]
+	})
+
+	return types
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+var errPlanRunning = fmt.Errorf("a workout plan is already running")
+
+type planEventResponse struct {
+	Type      string   `json:"type"`
+	Step      int      `json:"step"`
+	Steps     int      `json:"steps"`
+	Name      string   `json:"name,omitempty"`
+	Remaining float64  `json:"remaining"`
+	Speed     *float64 `json:"speed,omitempty"`
+	Incline   *byte    `json:"incline,omitempty"`
+	Error     string   `json:"error,omitempty"`
+}
+
+type planResponse struct {
+	Running bool               `json:"running"`
+	Name    string             `json:"name,omitempty"`
+	Event   *planEventResponse `json:"event,omitempty"`
+}
+
+func newPlanEventResponse(event treadonme.PlanEvent) *planEventResponse {
+	resp := &planEventResponse{
+		Type:      event.Type.String(),
+		Step:      event.Step,
+		Steps:     event.Steps,
+		Name:      event.Segment.Name,
+		Remaining: event.Remaining.Seconds(),
+		Incline:   event.Segment.Incline,
+	}
+
+	if event.Segment.Speed != nil {
+		speed := event.Segment.Speed.Float()
+		resp.Speed = &speed
+	}
+
+	if event.Err != nil {
+		resp.Error = event.Err.Error()
+	}
+
+	return resp
+}
+
+func (d *device) apiPlan(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			return d.planStatus(), nil
+		})
+	case http.MethodPost:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", errBadRequest, err)
+			}
+
+			plan, err := treadonme.ParsePlan(body)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", errBadRequest, err)
+			}
+
+			if err := d.startPlan(plan); err != nil {
+				return nil, err
+			}
+
+			d.takeControl(apiSource(r))
+
+			return d.planStatus(), nil
+		})
+	case http.MethodDelete:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			d.stopPlan()
+
+			return d.planStatus(), nil
+		})
+	default:
+		w.Header().Set("Allow", "GET, POST, DELETE")
+		writeAPIError(w, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+	}
+}
+
+func (d *device) startPlan(plan *treadonme.Plan) error {
+	tm, err := d.treadmill()
+	if err != nil {
+		return err
+	}
+
+	d.planMutex.Lock()
+	defer d.planMutex.Unlock()
+
+	if d.planCancel != nil {
+		return errPlanRunning
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d.planCancel = cancel
+	d.planName = plan.Name
+	d.planEvent = nil
+
+	executor := treadonme.NewPlanExecutor(d.control(tm, "plan"), plan)
+	executor.AddListener(d.planListener)
+
+	go func() {
+		defer cancel()
+
+		if err := executor.Run(ctx); err != nil {
+			log.Printf("workout plan %q stopped: %s", plan.Name, err)
+		}
+
+		d.planMutex.Lock()
+		d.planCancel = nil
+		d.planMutex.Unlock()
+	}()
+
+	return nil
+}
+
+func (d *device) stopPlan() {
+	d.planMutex.Lock()
+	defer d.planMutex.Unlock()
+
+	if d.planCancel != nil {
+		d.planCancel()
+	}
+}
+
+func (d *device) planStatus() *planResponse {
+	d.planMutex.Lock()
+	defer d.planMutex.Unlock()
+
+	return &planResponse{Running: d.planCancel != nil, Name: d.planName, Event: d.planEvent}
+}
+
+func (d *device) planListener(event treadonme.PlanEvent) {
+	resp := newPlanEventResponse(event)
+
+	d.planMutex.Lock()
+	d.planEvent = resp
+	d.planMutex.Unlock()
+
+	d.notifyClients(&MessageWrapper{Type: "PlanEvent", Event: resp})
+}
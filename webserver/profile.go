@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+type profileRequest struct {
+	Sex    string  `json:"sex"`
+	Age    byte    `json:"age"`
+	Weight float64 `json:"weight"`
+	Height float64 `json:"height"`
+	Units  string  `json:"units"`
+}
+
+// profileRequestWithName is used to create profiles, when updating the name comes from the path.
+type profileRequestWithName struct {
+	Name string `json:"name"`
+	profileRequest
+}
+
+type profileResponse struct {
+	Name   string  `json:"name"`
+	Sex    string  `json:"sex"`
+	Age    byte    `json:"age"`
+	Weight float64 `json:"weight"`
+	Height float64 `json:"height"`
+	Units  string  `json:"units"`
+}
+
+func newProfileResponse(p *treadonme.Profile) *profileResponse {
+	return &profileResponse{
+		Name:   p.Name,
+		Sex:    strings.ToLower(p.Sex.String()),
+		Age:    p.Age,
+		Weight: p.Weight,
+		Height: p.Height,
+		Units:  strings.ToLower(p.Units.String()),
+	}
+}
+
+func (req *profileRequest) profile(name string) (*treadonme.Profile, error) {
+	sex, err := treadonme.ParseSexType(req.Sex)
+	if err != nil {
+		return nil, err
+	}
+
+	units, err := treadonme.ParseUnitsType(req.Units)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errBadRequest, err)
+	}
+
+	return &treadonme.Profile{
+		Name:   name,
+		Sex:    sex,
+		Age:    req.Age,
+		Weight: req.Weight,
+		Height: req.Height,
+		Units:  units,
+	}, nil
+}
+
+func (ws *webserver) apiProfiles(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			list := ws.profiles.List()
+
+			resp := make([]*profileResponse, 0, len(list))
+			for _, p := range list {
+				resp = append(resp, newProfileResponse(p))
+			}
+
+			return resp, nil
+		})
+	case http.MethodPost:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			req := &profileRequestWithName{}
+			if err := decodeAPIRequest(r, req); err != nil {
+				return nil, err
+			}
+
+			p, err := req.profile(req.Name)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := ws.profiles.Create(p); err != nil {
+				return nil, err
+			}
+
+			return newProfileResponse(p), nil
+		})
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		writeAPIError(w, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+	}
+}
+
+func (ws *webserver) apiProfile(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/profiles/")
+
+	switch r.Method {
+	case http.MethodGet:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			p, err := ws.profiles.Get(name)
+			if err != nil {
+				return nil, err
+			}
+
+			return newProfileResponse(p), nil
+		})
+	case http.MethodPut:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			req := &profileRequest{}
+			if err := decodeAPIRequest(r, req); err != nil {
+				return nil, err
+			}
+
+			p, err := req.profile(name)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := ws.profiles.Update(p); err != nil {
+				return nil, err
+			}
+
+			return newProfileResponse(p), nil
+		})
+	case http.MethodDelete:
+		serveAPI(w, r, func(r *http.Request) (interface{}, error) {
+			if err := ws.profiles.Delete(name); err != nil {
+				return nil, err
+			}
+
+			return &okResponse{OK: true}, nil
+		})
+	default:
+		w.Header().Set("Allow", "GET, PUT, DELETE")
+		writeAPIError(w, fmt.Errorf("%w: %s", errMethodNotAllowed, r.Method))
+	}
+}
+
+// startProfile returns the profile to send to the treadmill when the user starts a workout, workouts without a user
+// or for a user without a profile use the default profile.
+func (ws *webserver) startProfile(user string, units treadonme.UnitsType) *treadonme.MessageUserProfile {
+	if user == "" {
+		return &treadonme.DefaultUserProfile
+	}
+
+	p, err := ws.profiles.Get(user)
+	if err != nil {
+		log.Printf("using the default profile for %s: %s", user, err)
+
+		return &treadonme.DefaultUserProfile
+	}
+
+	return p.UserProfile(units)
+}
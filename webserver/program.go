@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/swedishborgie/treadonme"
+)
+
+type userProgramRequest struct {
+	Segments []userProgramSegment `json:"segments"`
+}
+
+// userProgramSegment is a segment of a user program with the speed in the units of the treadmill.
+type userProgramSegment struct {
+	Speed   float64 `json:"speed"`
+	Incline byte    `json:"incline"`
+}
+
+func (d *device) apiUserProgram(r *http.Request) (interface{}, error) {
+	slot, err := treadonme.ParseProgram(strings.TrimPrefix(r.URL.Path, "/programs/"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errNotFound, err)
+	}
+
+	req := &userProgramRequest{}
+	if err := decodeAPIRequest(r, req); err != nil {
+		return nil, err
+	}
+
+	program := &treadonme.UserProgram{Slot: slot}
+
+	for idx, seg := range req.Segments {
+		// Speeds are sent as a single byte, anything else would wrap around to a different speed.
+		if seg.Speed < 0 || math.Round(seg.Speed*10) > math.MaxUint8 {
+			return nil, fmt.Errorf("%w: segment %d: speed %.1f is out of range", errBadRequest, idx+1, seg.Speed)
+		}
+
+		program.Segments = append(program.Segments, treadonme.UserProgramSegment{
+			// Speeds are transmitted multiplied by ten.
+			Speed:   treadonme.Speed(math.Round(seg.Speed * 10)),
+			Incline: seg.Incline,
+		})
+	}
+
+	if err := d.withTreadmill(func(tm treadonme.Equipment) error {
+		sole, ok := tm.(*treadonme.Treadmill)
+		if !ok {
+			return fmt.Errorf("%w: user programs", errNotSupported)
+		}
+
+		// The treadmill runs the segments without asking once the program is started, so they're checked up front.
+		return d.control(tm, apiSource(r)).PerformUpload(program, func() error {
+			return sole.UploadUserProgram(program)
+		})
+	}); err != nil {
+		return nil, err
+	}
+
+	return &okResponse{OK: true}, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+type ProgramTestSuite struct {
+	suite.Suite
+}
+
+func (s *ProgramTestSuite) TestSpeedOutOfRange() {
+	d := newDevice(&webserver{}, treadmillConfig{Name: "test"})
+
+	for _, body := range []string{
+		`{"segments": [{"speed": 30}]}`,
+		`{"segments": [{"speed": 3}, {"speed": -1}]}`,
+	} {
+		r := httptest.NewRequest("POST", "/programs/user1", strings.NewReader(body))
+
+		_, err := d.apiUserProgram(r)
+		s.Require().ErrorIs(err, errBadRequest, body)
+	}
+}
+
+func TestProgramTestSuite(t *testing.T) {
+	t.Parallel()
+	suite.Run(t, new(ProgramTestSuite))
+}